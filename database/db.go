@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"slices"
+	"strings"
 
 	"github.com/agassiz/3x-ui/v2/config"
 	"github.com/agassiz/3x-ui/v2/database/model"
@@ -33,11 +34,30 @@ func initModels() error {
 		&model.User{},
 		&model.Inbound{},
 		&model.OutboundTraffics{},
+		&model.OutboundTrafficHistory{},
+		&model.ClientTrafficHistory{},
 		&model.Setting{},
 		&model.InboundClientIps{},
+		&model.IpBan{},
+		&model.ClientSession{},
+		&model.ConnectionLog{},
+		&model.RoutingPreset{},
+		&model.Plan{},
+		&model.QuotaPool{},
+		&model.ClientUsageSnapshot{},
+		&model.InboundUsageSnapshot{},
+		&model.ClientStrike{},
+		&model.SubscriptionFetch{},
+		&model.LeakReport{},
+		&model.TrialClaim{},
+		&model.InviteCode{},
+		&model.Referral{},
+		&model.Invoice{},
 		&xray.ClientTraffic{},
 		&model.HistoryOfSeeders{},
 		&model.ClashSubscription{},
+		&model.Outbound{},
+		&model.OutboundSubscription{},
 	}
 	for _, model := range models {
 		if err := db.AutoMigrate(model); err != nil {
@@ -45,6 +65,17 @@ func initModels() error {
 			return err
 		}
 	}
+	// Payment references are unique once set, so that a payment cannot be recorded twice
+	indexes := []string{
+		"DROP INDEX IF EXISTS idx_invoices_reference",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_invoice_reference ON invoices(reference) WHERE reference != ''",
+	}
+	for _, index := range indexes {
+		if err := db.Exec(index).Error; err != nil {
+			log.Printf("Error creating invoice index: %v", err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -269,6 +300,11 @@ func IsNotFound(err error) bool {
 	return err == gorm.ErrRecordNotFound
 }
 
+// IsUniqueViolation checks if the given error is a violated unique constraint.
+func IsUniqueViolation(err error) bool {
+	return err != nil && (errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "UNIQUE constraint failed"))
+}
+
 // IsSQLiteDB checks if the given file is a valid SQLite database by reading its signature.
 func IsSQLiteDB(file io.ReaderAt) (bool, error) {
 	signature := []byte("SQLite format 3\x00")
@@ -43,6 +43,11 @@ type Inbound struct {
 	ExpiryTime           int64                `json:"expiryTime" form:"expiryTime"`                                                                    // Expiration timestamp
 	TrafficReset         string               `json:"trafficReset" form:"trafficReset" gorm:"default:never;index:idx_enable_traffic_reset,priority:2"` // Traffic reset schedule
 	LastTrafficResetTime int64                `json:"lastTrafficResetTime" form:"lastTrafficResetTime" gorm:"default:0"`                               // Last traffic reset timestamp
+	EgressOutbound       string               `json:"egressOutbound" form:"egressOutbound"`                                                            // Default outbound tag for clients of this inbound
+	EgressBalancer       string               `json:"egressBalancer" form:"egressBalancer"`                                                            // Default balancer tag for clients of this inbound
+	SpeedUp              int                  `json:"speedUp" form:"speedUp"`                                                                          // Default upload cap of its clients in Mbps, 0 for no cap
+	SpeedDown            int                  `json:"speedDown" form:"speedDown"`                                                                      // Default download cap of its clients in Mbps, 0 for no cap
+	TrafficMultiplier    float64              `json:"trafficMultiplier" form:"trafficMultiplier" gorm:"default:1"`                                     // Factor applied to client traffic counted toward quotas
 	ClientStats          []xray.ClientTraffic `gorm:"foreignKey:InboundId;references:Id" json:"clientStats" form:"clientStats"`                        // Client traffic statistics
 
 	// Xray configuration fields
@@ -62,6 +67,60 @@ type OutboundTraffics struct {
 	Up    int64  `json:"up" form:"up" gorm:"default:0"`
 	Down  int64  `json:"down" form:"down" gorm:"default:0"`
 	Total int64  `json:"total" form:"total" gorm:"default:0"`
+
+	Quota         int64  `json:"quota" form:"quota" gorm:"default:0"`                     // Traffic quota in bytes, 0 for unlimited
+	ResetDay      int    `json:"resetDay" form:"resetDay" gorm:"default:0"`               // Day of month the traffic is reset, 0 to disable
+	LastResetTime int64  `json:"lastResetTime" form:"lastResetTime" gorm:"default:0"`     // Last traffic reset timestamp
+	QuotaAction   string `json:"quotaAction" form:"quotaAction" gorm:"default:alert"`     // Action when the quota is hit: alert or reroute
+	FallbackTag   string `json:"fallbackTag" form:"fallbackTag"`                          // Outbound receiving the traffic when rerouting
+	QuotaExceeded bool   `json:"quotaExceeded" form:"quotaExceeded" gorm:"default:false"` // Whether the quota has been hit in the current period
+}
+
+// OutboundTrafficHistory stores outbound traffic aggregated per hour or per day.
+type OutboundTrafficHistory struct {
+	Id     int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Tag    string `json:"tag" gorm:"uniqueIndex:idx_outbound_history,priority:1"`
+	Period string `json:"period" gorm:"uniqueIndex:idx_outbound_history,priority:2"` // hour or day
+	Time   int64  `json:"time" gorm:"uniqueIndex:idx_outbound_history,priority:3"`   // Bucket start timestamp
+	Up     int64  `json:"up" gorm:"default:0"`
+	Down   int64  `json:"down" gorm:"default:0"`
+}
+
+// ClientTrafficHistory stores the traffic of a client aggregated into a time bucket.
+type ClientTrafficHistory struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId int    `json:"inboundId" gorm:"index"`
+	Email     string `json:"email" gorm:"uniqueIndex:idx_client_history,priority:1"`
+	Period    string `json:"period" gorm:"uniqueIndex:idx_client_history,priority:2"` // 5m, hour or day
+	Time      int64  `json:"time" gorm:"uniqueIndex:idx_client_history,priority:3"`   // Bucket start timestamp
+	Up        int64  `json:"up" gorm:"default:0"`
+	Down      int64  `json:"down" gorm:"default:0"`
+}
+
+// Outbound is an Xray outbound managed by the panel, added by hand or imported from a subscription.
+type Outbound struct {
+	Id             int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	SubscriptionId int    `json:"subscriptionId" form:"subscriptionId" gorm:"index;default:0"` // Owning subscription, 0 for manual outbounds
+	Remark         string `json:"remark" form:"remark"`                                        // Human-readable remark
+	Enable         bool   `json:"enable" form:"enable"`                                        // Whether the outbound is added to the Xray config
+	Tag            string `json:"tag" form:"tag" gorm:"unique"`                                // Outbound tag used in routing
+	Protocol       string `json:"protocol" form:"protocol"`                                    // Outbound protocol
+	Config         string `json:"config" form:"config"`                                        // Xray outbound JSON without tag
+	Link           string `json:"link" form:"link"`                                            // Share link the outbound was imported from
+}
+
+// OutboundSubscription is a remote list of share links periodically imported as outbounds.
+type OutboundSubscription struct {
+	Id          int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Remark      string `json:"remark" form:"remark"`           // Human-readable remark
+	Url         string `json:"url" form:"url"`                 // Subscription URL
+	Enable      bool   `json:"enable" form:"enable"`           // Whether the subscription is refreshed
+	TagPrefix   string `json:"tagPrefix" form:"tagPrefix"`     // Prefix for tags of imported outbounds
+	Interval    int    `json:"interval" form:"interval"`       // Refresh interval in minutes, 0 to refresh manually only
+	LastUpdated int64  `json:"lastUpdated" form:"lastUpdated"` // Last successful refresh timestamp
+	LastAttempt int64  `json:"lastAttempt" form:"lastAttempt"` // Last refresh attempt timestamp
+	Failures    int    `json:"failures" form:"failures"`       // Refresh attempts that failed in a row
+	LastError   string `json:"lastError" form:"lastError"`     // Error of the last refresh attempt
 }
 
 // InboundClientIps stores IP addresses associated with inbound clients for access control.
@@ -71,6 +130,184 @@ type InboundClientIps struct {
 	Ips         string `json:"ips" form:"ips"`
 }
 
+// IpBan is a source IP blocked by the panel for exceeding a client IP or device limit.
+type IpBan struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Ip        string `json:"ip" form:"ip" gorm:"unique"`
+	Email     string `json:"email" form:"email"`         // Client whose limit was exceeded
+	Reason    string `json:"reason" form:"reason"`       // Limit that caused the ban: ipLimit or deviceLimit
+	Backend   string `json:"backend" form:"backend"`     // Firewall backend holding the block: xray, nftables or iptables
+	CreatedAt int64  `json:"createdAt" form:"createdAt"` // Ban timestamp
+	ExpiresAt int64  `json:"expiresAt" form:"expiresAt"` // Unban timestamp, 0 for permanent
+}
+
+// ClientSession is a device of a client tracked by its source IP for the device limit.
+type ClientSession struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email     string `json:"email" form:"email" gorm:"index"`
+	Ip        string `json:"ip" form:"ip"`               // Current source IP of the device
+	FirstSeen int64  `json:"firstSeen" form:"firstSeen"` // Session start timestamp
+	LastSeen  int64  `json:"lastSeen" form:"lastSeen"`   // Last time the device was seen
+}
+
+// ConnectionLog is a connection read from the Xray access log.
+type ConnectionLog struct {
+	Id          int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	Time        int64  `json:"time" gorm:"index"`        // Connection timestamp
+	Ip          string `json:"ip" gorm:"index"`          // Source IP, anonymized when enabled
+	Email       string `json:"email" gorm:"index"`       // Client email, empty when the client is unknown
+	Network     string `json:"network"`                  // tcp or udp
+	Destination string `json:"destination" gorm:"index"` // Destination domain or IP
+	Port        int    `json:"port"`                     // Destination port
+	InboundTag  string `json:"inboundTag"`
+	OutboundTag string `json:"outboundTag" gorm:"index"`
+	Accepted    bool   `json:"accepted"`
+	Reason      string `json:"reason"` // Rejection reason
+}
+
+// RoutingPreset is a block rule set managed by the panel, applied to all inbounds or to some of them.
+type RoutingPreset struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name        string `json:"name" form:"name" gorm:"unique"` // bittorrent, private, ads or countries
+	Enable      bool   `json:"enable" form:"enable"`
+	InboundTags string `json:"inboundTags" form:"inboundTags"` // Comma separated inbound tags, empty for all inbounds
+	Countries   string `json:"countries" form:"countries"`     // Comma separated country codes blocked by the countries preset
+}
+
+// Plan is a reusable set of limits that clients can be assigned to.
+type Plan struct {
+	Id             int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name           string `json:"name" form:"name" gorm:"unique"`
+	SpeedUp        int    `json:"speedUp" form:"speedUp"`               // Upload cap in Mbps, 0 for no cap
+	SpeedDown      int    `json:"speedDown" form:"speedDown"`           // Download cap in Mbps, 0 for no cap
+	SoftQuotaGB    int64  `json:"softQuotaGB" form:"softQuotaGB"`       // Usage in GB after which clients drop to the throttled tier, 0 for none
+	AccessSchedule string `json:"accessSchedule" form:"accessSchedule"` // Weekly windows its clients may connect in, empty for any time
+	RotateDays     int    `json:"rotateDays" form:"rotateDays"`         // Days between credential rotations of its clients, 0 for none
+	Price          int64  `json:"price" form:"price"`                   // Price of a renewal in cents of the billing currency
+	RenewDays      int    `json:"renewDays" form:"renewDays"`           // Days a paid renewal adds to the expiry, 0 to keep it
+	RenewGB        int64  `json:"renewGB" form:"renewGB"`               // Traffic quota in GB a paid renewal sets, 0 to keep it
+}
+
+// QuotaPool is a traffic quota shared by several clients, possibly on different inbounds.
+type QuotaPool struct {
+	Id    int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Name  string `json:"name" form:"name" gorm:"unique"`
+	Total int64  `json:"total" form:"total"` // Traffic in bytes shared by the members, 0 for no quota
+}
+
+// ClientUsageSnapshot is the usage of a client over one cycle, recorded before its traffic is reset.
+type ClientUsageSnapshot struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId   int    `json:"inboundId"`
+	Email       string `json:"email" gorm:"index"`
+	PeriodStart int64  `json:"periodStart"`            // Start of the cycle in milliseconds, 0 when unknown
+	PeriodEnd   int64  `json:"periodEnd" gorm:"index"` // Time of the reset in milliseconds
+	Up          int64  `json:"up"`
+	Down        int64  `json:"down"`
+	RawUp       int64  `json:"rawUp"`
+	RawDown     int64  `json:"rawDown"`
+	Total       int64  `json:"total"`  // Quota of the cycle, prorated when it changed mid-cycle
+	Reason      string `json:"reason"` // What triggered the reset
+}
+
+// InboundUsageSnapshot is the traffic of an inbound over one period, recorded before it is reset.
+type InboundUsageSnapshot struct {
+	Id          int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	InboundId   int    `json:"inboundId" gorm:"index"`
+	Tag         string `json:"tag"`
+	PeriodStart int64  `json:"periodStart"`            // Start of the period in milliseconds, 0 when unknown
+	PeriodEnd   int64  `json:"periodEnd" gorm:"index"` // Time of the reset in milliseconds
+	Up          int64  `json:"up"`
+	Down        int64  `json:"down"`
+	Total       int64  `json:"total"`
+	Reason      string `json:"reason"` // What triggered the reset
+}
+
+// ClientStrike counts the BitTorrent block hits of a client toward automatic suspension.
+type ClientStrike struct {
+	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email      string `json:"email" form:"email" gorm:"unique"`
+	Strikes    int    `json:"strikes" form:"strikes"`
+	LastStrike int64  `json:"lastStrike" form:"lastStrike"` // Timestamp of the last strike
+	Suspended  bool   `json:"suspended" form:"suspended"`   // Whether the client was disabled by the strike policy
+}
+
+// SubscriptionFetch is a source IP a subscription was fetched from.
+type SubscriptionFetch struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	SubId     string `json:"subId" gorm:"uniqueIndex:idx_subscription_fetch,priority:1"`
+	Ip        string `json:"ip" gorm:"uniqueIndex:idx_subscription_fetch,priority:2"`
+	FirstSeen int64  `json:"firstSeen"`              // First fetch timestamp
+	LastSeen  int64  `json:"lastSeen" gorm:"index"`  // Last fetch timestamp
+	Count     int    `json:"count" gorm:"default:0"` // Fetches from this IP
+}
+
+// LeakReport is a client flagged as likely sharing or reselling its subscription.
+type LeakReport struct {
+	Id           int     `json:"id" gorm:"primaryKey;autoIncrement"`
+	Email        string  `json:"email" gorm:"unique"`
+	SubId        string  `json:"subId"`
+	Score        int     `json:"score"`        // Sharing score, 100 and above is reported
+	SubIps       int     `json:"subIps"`       // Distinct IPs the subscription was fetched from in a day
+	ConnIps      int     `json:"connIps"`      // Distinct IPs the client connected from in a day
+	TrafficRatio float64 `json:"trafficRatio"` // Traffic of the last day over the daily average of the week before
+	Action       string  `json:"action"`       // Automatic response applied: none, rotate or limit
+	DetectedAt   int64   `json:"detectedAt"`   // Last detection timestamp
+}
+
+// TrialClaim is a trial account handed out, kept after its client is deleted so each Telegram
+// user and IP can claim only one.
+type TrialClaim struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	TgId      int64  `json:"tgId" gorm:"index"` // Telegram user that claimed it, 0 when claimed on the web
+	Ip        string `json:"ip" gorm:"index"`   // Source IP of the claim
+	InboundId int    `json:"inboundId"`
+	Email     string `json:"email"`
+	CreatedAt int64  `json:"createdAt" gorm:"index"` // Claim timestamp
+	Deleted   bool   `json:"deleted"`                // Whether its client was deleted after expiring
+}
+
+// InviteCode lets new clients sign up on an inbound, linked to the client that shared it.
+type InviteCode struct {
+	Id         int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Code       string `json:"code" form:"code" gorm:"unique"`
+	Referrer   string `json:"referrer" form:"referrer" gorm:"index"` // Email of the client that shared it, empty for admin codes
+	InboundId  int    `json:"inboundId" form:"inboundId"`            // Inbound redeemed clients are created on
+	PlanId     int    `json:"planId" form:"planId"`                  // Plan redeemed clients are assigned to, 0 for none
+	TotalGB    int64  `json:"totalGB" form:"totalGB"`                // Traffic quota of redeemed clients in GB, 0 for unlimited
+	Days       int    `json:"days" form:"days"`                      // Days redeemed clients last, 0 for no expiry
+	MaxUses    int    `json:"maxUses" form:"maxUses"`                // Redemptions allowed, 0 for unlimited
+	Uses       int    `json:"uses" gorm:"default:0"`                 // Redemptions so far
+	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`          // Time the code stops working in milliseconds, 0 for never
+	CreatedAt  int64  `json:"createdAt"`                             // Creation timestamp
+}
+
+// Referral is a client that signed up with an invite code, kept after the client is deleted.
+type Referral struct {
+	Id        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Code      string `json:"code"`
+	Referrer  string `json:"referrer" gorm:"index"` // Email of the client that shared the code, empty for admin codes
+	Email     string `json:"email" gorm:"unique"`   // Email of the client created
+	TgId      int64  `json:"tgId" gorm:"index"`     // Telegram user that redeemed it, 0 when redeemed on the web
+	Ip        string `json:"ip" gorm:"index"`       // Source IP of the redemption
+	CreatedAt int64  `json:"createdAt"`             // Redemption timestamp
+}
+
+// Invoice is an entry of the billing ledger: an amount a client owes, and its payment once paid.
+type Invoice struct {
+	Id        int    `json:"id" form:"id" gorm:"primaryKey;autoIncrement"`
+	Email     string `json:"email" form:"email" gorm:"index"`
+	PlanId    int    `json:"planId" form:"planId"`              // Plan renewed when it is paid, 0 for none
+	Amount    int64  `json:"amount" form:"amount"`              // Amount in cents of the billing currency, the plan price when empty
+	Note      string `json:"note" form:"note"`                  // Free text shown on the invoice
+	Status    string `json:"status" form:"status" gorm:"index"` // unpaid, paid or void
+	DueTime   int64  `json:"dueTime" form:"dueTime"`            // Payment deadline in milliseconds, 0 for none
+	PaidAt    int64  `json:"paidAt" form:"paidAt"`              // Payment time in milliseconds
+	Method    string `json:"method" form:"method"`              // Payment method, such as cash or a gateway name
+	Reference string `json:"reference" form:"reference"`        // Payment reference of an external system, unique when set
+	CreatedAt int64  `json:"createdAt"`                         // Creation time in milliseconds
+}
+
 // HistoryOfSeeders tracks which database seeders have been executed to prevent re-running.
 type HistoryOfSeeders struct {
 	Id         int    `json:"id" gorm:"primaryKey;autoIncrement"`
@@ -126,4 +363,21 @@ type Client struct {
 	Reset      int    `json:"reset" form:"reset"`           // Reset period in days
 	CreatedAt  int64  `json:"created_at,omitempty"`         // Creation timestamp
 	UpdatedAt  int64  `json:"updated_at,omitempty"`         // Last update timestamp
+
+	EgressOutbound     string  `json:"egressOutbound,omitempty" form:"egressOutbound"`         // Outbound tag this client's traffic exits through
+	EgressBalancer     string  `json:"egressBalancer,omitempty" form:"egressBalancer"`         // Balancer tag this client's traffic exits through
+	LimitDevice        int     `json:"limitDevice,omitempty" form:"limitDevice"`               // Concurrent device limit, replaces limitIp when set
+	PlanId             int     `json:"planId,omitempty" form:"planId"`                         // Plan whose limits apply where the client sets none
+	SpeedUp            int     `json:"speedUp,omitempty" form:"speedUp"`                       // Upload cap in Mbps
+	SpeedDown          int     `json:"speedDown,omitempty" form:"speedDown"`                   // Download cap in Mbps
+	SoftQuotaGB        int64   `json:"softQuotaGB,omitempty" form:"softQuotaGB"`               // Usage in GB after which the client drops to the throttled tier
+	TrafficMultiplier  float64 `json:"trafficMultiplier,omitempty" form:"trafficMultiplier"`   // Replaces the inbound's traffic multiplier for this client
+	PoolId             int     `json:"poolId,omitempty" form:"poolId"`                         // Quota pool the client draws from besides its own quota
+	ResetSchedule      string  `json:"resetSchedule,omitempty" form:"resetSchedule"`           // Traffic reset schedule: anniversary or a cron expression in the panel's time zone
+	ResetAnchor        int64   `json:"resetAnchor,omitempty" form:"resetAnchor"`               // Billing date in milliseconds the anniversary schedule follows, created_at when unset
+	AccessSchedule     string  `json:"accessSchedule,omitempty" form:"accessSchedule"`         // Weekly windows the client may connect in, such as "Mon-Fri 09:00-18:00"
+	RotateDays         int     `json:"rotateDays,omitempty" form:"rotateDays"`                 // Days between rotations of the client's UUID or password
+	RotatedAt          int64   `json:"rotatedAt,omitempty" form:"rotatedAt"`                   // Last credential rotation in milliseconds
+	OldCredential      string  `json:"oldCredential,omitempty" form:"oldCredential"`           // Previous UUID or password, still accepted until OldCredentialUntil
+	OldCredentialUntil int64   `json:"oldCredentialUntil,omitempty" form:"oldCredentialUntil"` // End of the overlap of the previous credential in milliseconds
 }
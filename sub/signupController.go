@@ -0,0 +1,152 @@
+package sub
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/agassiz/3x-ui/v2/config"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/entity"
+	"github.com/agassiz/3x-ui/v2/web/locale"
+	"github.com/agassiz/3x-ui/v2/web/network"
+	"github.com/agassiz/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SignupController handles the public sign-up page of the subscription server, where visitors
+// claim trial accounts and redeem invite codes.
+type SignupController struct {
+	settingService service.SettingService
+	trialService   service.TrialService
+	inviteService  service.InviteService
+	xrayService    service.XrayService
+	tgbot          service.Tgbot
+}
+
+// NewSignupController creates a new sign-up controller and registers its routes under the subscription path.
+func NewSignupController(g *gin.RouterGroup, subPath string) *SignupController {
+	a := &SignupController{}
+	a.initRouter(g.Group(subPath))
+	return a
+}
+
+// initRouter registers the sign-up page and its endpoints on the provided router group.
+func (a *SignupController) initRouter(g *gin.RouterGroup) {
+	gSignup := g.Group("signup")
+	gSignup.GET("", a.signup)
+	gSignup.POST("trial", a.createTrial)
+	gSignup.POST("invite", a.redeemInvite)
+}
+
+// signup renders the sign-up page, or a 404 when no way to sign up is enabled.
+func (a *SignupController) signup(c *gin.Context) {
+	trialEnable, err := a.settingService.GetTrialEnable()
+	if err != nil {
+		logger.Warning("Unable to get the trial setting:", err)
+	}
+	inviteEnable, err := a.settingService.GetInviteEnable()
+	if err != nil {
+		logger.Warning("Unable to get the invite setting:", err)
+	}
+	if !trialEnable && !inviteEnable {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.HTML(http.StatusOK, "signup.html", gin.H{
+		"title":        "subscription.signup",
+		"cur_ver":      config.GetVersion(),
+		"host":         c.Request.Host,
+		"base_path":    c.GetString("base_path"),
+		"trialEnable":  trialEnable,
+		"inviteEnable": inviteEnable,
+	})
+}
+
+// getSignupIp returns the visitor IP that trials and invites are limited by, following forwarding
+// headers only from the trusted proxies.
+func (a *SignupController) getSignupIp(c *gin.Context) string {
+	trustedProxies, err := a.settingService.GetTrustedProxies()
+	if err != nil {
+		logger.Warning("Unable to get the trusted proxies:", err)
+	}
+	return network.RemoteIp(c.Request, trustedProxies)
+}
+
+// createTrial creates a trial account for the visitor, once per IP.
+func (a *SignupController) createTrial(c *gin.Context) {
+	ip := a.getSignupIp(c)
+	client, needRestart, err := a.trialService.CreateTrial(0, ip)
+	switch {
+	case errors.Is(err, service.ErrTrialDisabled):
+		signupMsg(c, false, "subscription.trialDisabled", nil)
+		return
+	case errors.Is(err, service.ErrTrialClaimed):
+		signupMsg(c, false, "subscription.trialClaimed", nil)
+		return
+	case errors.Is(err, service.ErrTrialCapped):
+		signupMsg(c, false, "subscription.trialCapped", nil)
+		return
+	case err != nil && client == nil:
+		logger.Warning("Unable to create a trial account:", err)
+		signupMsg(c, false, "somethingWentWrong", nil)
+		return
+	}
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+	a.tgbot.TrialCreatedNotify(client.Email, ip, 0)
+	signupMsg(c, true, "subscription.trialCreated", a.accountInfo(client))
+}
+
+// redeemInvite creates an account for the visitor from an invite code, once per IP.
+func (a *SignupController) redeemInvite(c *gin.Context) {
+	code := strings.TrimSpace(c.PostForm("code"))
+	client, needRestart, err := a.inviteService.RedeemInvite(code, 0, a.getSignupIp(c))
+	switch {
+	case errors.Is(err, service.ErrInviteClosed):
+		signupMsg(c, false, "subscription.inviteDisabled", nil)
+		return
+	case errors.Is(err, service.ErrInviteCapped):
+		signupMsg(c, false, "subscription.inviteCapped", nil)
+		return
+	case errors.Is(err, service.ErrInviteInvalid):
+		signupMsg(c, false, "subscription.inviteInvalid", nil)
+		return
+	case errors.Is(err, service.ErrInviteRedeemed):
+		signupMsg(c, false, "subscription.inviteRedeemed", nil)
+		return
+	case err != nil && client == nil:
+		logger.Warning("Unable to redeem an invite code:", err)
+		signupMsg(c, false, "somethingWentWrong", nil)
+		return
+	}
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+	a.tgbot.ReferralNotify(code, client.Email)
+	signupMsg(c, true, "subscription.inviteCreated", a.accountInfo(client))
+}
+
+// accountInfo returns what the sign-up page shows about a newly created account.
+func (a *SignupController) accountInfo(client *model.Client) gin.H {
+	subURL, subJsonURL, _ := a.tgbot.GetSubscriptionURLs(client.Email)
+	return gin.H{
+		"email":      client.Email,
+		"subId":      client.SubID,
+		"expiryTime": client.ExpiryTime,
+		"subUrl":     subURL,
+		"subJsonUrl": subJsonURL,
+	}
+}
+
+// signupMsg sends a JSON response with a localized message, in the format the panel pages expect.
+func signupMsg(c *gin.Context, success bool, key string, obj any) {
+	c.JSON(http.StatusOK, entity.Msg{
+		Success: success,
+		Msg:     locale.I18n(locale.Web, key),
+		Obj:     obj,
+	})
+}
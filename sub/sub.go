@@ -33,6 +33,7 @@ func setEmbeddedTemplates(engine *gin.Engine) error {
 		"html/common/page.html",
 		"html/component/aThemeSwitch.html",
 		"html/settings/panel/subscription/subpage.html",
+		"html/settings/panel/subscription/signup.html",
 	)
 	if err != nil {
 		return err
@@ -47,6 +48,7 @@ type Server struct {
 	listener   net.Listener
 
 	sub            *SUBController
+	signup         *SignupController
 	settingService service.SettingService
 
 	ctx    context.Context
@@ -232,6 +234,7 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	s.sub = NewSUBController(
 		g, LinksPath, JsonPath, subJsonEnable, Encrypt, ShowInfo, RemarkModel, SubUpdates,
 		SubJsonFragment, SubJsonNoises, SubJsonMux, SubJsonRules, SubTitle)
+	s.signup = NewSignupController(g, LinksPath)
 
 	return engine, nil
 }
@@ -257,6 +260,10 @@ func (s *Server) getHtmlFiles() ([]string, error) {
 	} else {
 		return nil, err
 	}
+	signup := filepath.Join(dir, "web", "html", "settings", "panel", "subscription", "signup.html")
+	if _, err := os.Stat(signup); err == nil {
+		files = append(files, signup)
+	}
 	return files, nil
 }
 
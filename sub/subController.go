@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/agassiz/3x-ui/v2/config"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,6 +23,7 @@ type SUBController struct {
 
 	subService     *SubService
 	subJsonService *SubJsonService
+	leakService    service.LeakDetectionService
 }
 
 // NewSUBController creates a new subscription controller with the given configuration.
@@ -74,6 +77,7 @@ func (a *SUBController) subs(c *gin.Context) {
 	if err != nil || len(subs) == 0 {
 		c.String(400, "Error!")
 	} else {
+		a.recordFetch(c, subId)
 		result := ""
 		for _, sub := range subs {
 			result += sub + "\n"
@@ -120,6 +124,14 @@ func (a *SUBController) subs(c *gin.Context) {
 				"totalByte":    page.TotalByte,
 				"subUrl":       page.SubUrl,
 				"subJsonUrl":   page.SubJsonUrl,
+				"usedToday":    page.UsedToday,
+				"usedMonth":    page.UsedMonth,
+				"dailyAverage": page.DailyAverage,
+				"depletion":    page.Depletion,
+				"poolName":     page.PoolName,
+				"poolUsed":     page.PoolUsed,
+				"poolTotal":    page.PoolTotal,
+				"poolMembers":  page.PoolMembers,
 				"result":       page.Result,
 			})
 			return
@@ -145,6 +157,7 @@ func (a *SUBController) subJsons(c *gin.Context) {
 	if err != nil || len(jsonSub) == 0 {
 		c.String(400, "Error!")
 	} else {
+		a.recordFetch(c, subId)
 
 		// Add headers
 		a.ApplyCommonHeaders(c, header, a.updateInterval, a.subTitle)
@@ -153,6 +166,13 @@ func (a *SUBController) subJsons(c *gin.Context) {
 	}
 }
 
+// recordFetch records the source IP of a served subscription for leak detection.
+func (a *SUBController) recordFetch(c *gin.Context, subId string) {
+	if err := a.leakService.RecordSubscriptionFetch(subId, c.ClientIP()); err != nil {
+		logger.Warning("Unable to record subscription fetch:", err)
+	}
+}
+
 // ApplyCommonHeaders sets common HTTP headers for subscription responses including user info, update interval, and profile title.
 func (a *SUBController) ApplyCommonHeaders(c *gin.Context, header, updateInterval, profileTitle string) {
 	c.Writer.Header().Set("Subscription-Userinfo", header)
@@ -1038,6 +1038,14 @@ type PageData struct {
 	TotalByte    int64
 	SubUrl       string
 	SubJsonUrl   string
+	UsedToday    string
+	UsedMonth    string
+	DailyAverage string // Average daily usage of the last week, empty when unused
+	Depletion    int64  // Forecast time the quota runs out in milliseconds, 0 when unknown
+	PoolName     string // Quota pool the subscription draws from, empty when none
+	PoolUsed     string
+	PoolTotal    string
+	PoolMembers  string // Share of each pool member
 	Result       []string
 }
 
@@ -1173,6 +1181,41 @@ func (s *SubService) BuildPageData(subId string, hostHeader string, traffic xray
 		datepicker = "gregorian"
 	}
 
+	usedToday, usedMonth := "-", "-"
+	emails := s.getClientEmails(subId)
+	if usage, err := s.inboundService.GetClientsUsage(emails, 1); err == nil {
+		usedToday = common.FormatTraffic(usage)
+	}
+	if usage, err := s.inboundService.GetClientsUsage(emails, 30); err == nil {
+		usedMonth = common.FormatTraffic(usage)
+	}
+
+	dailyAverage, depletion := "", int64(0)
+	if forecast, err := s.inboundService.GetUsageForecast(emails, traffic.Up+traffic.Down, traffic.Total); err == nil && forecast.DailyAverage > 0 {
+		dailyAverage = common.FormatTraffic(forecast.DailyAverage)
+		depletion = forecast.DepletionTime
+	}
+
+	var poolName, poolUsed, poolTotal, poolMembers string
+	for _, email := range emails {
+		pool, err := s.inboundService.GetClientPoolUsage(email)
+		if err != nil || pool == nil {
+			continue
+		}
+		poolName = pool.Name
+		poolUsed = common.FormatTraffic(pool.Used)
+		poolTotal = "∞"
+		if pool.Total > 0 {
+			poolTotal = common.FormatTraffic(pool.Total)
+		}
+		shares := make([]string, 0, len(pool.Members))
+		for _, member := range pool.Members {
+			shares = append(shares, member.Email+": "+common.FormatTraffic(member.Up+member.Down))
+		}
+		poolMembers = strings.Join(shares, ", ")
+		break
+	}
+
 	return PageData{
 		Host:         hostHeader,
 		BasePath:     basePath,
@@ -1190,10 +1233,39 @@ func (s *SubService) BuildPageData(subId string, hostHeader string, traffic xray
 		TotalByte:    traffic.Total,
 		SubUrl:       subURL,
 		SubJsonUrl:   subJsonURL,
+		UsedToday:    usedToday,
+		UsedMonth:    usedMonth,
+		DailyAverage: dailyAverage,
+		Depletion:    depletion,
+		PoolName:     poolName,
+		PoolUsed:     poolUsed,
+		PoolTotal:    poolTotal,
+		PoolMembers:  poolMembers,
 		Result:       subs,
 	}
 }
 
+// getClientEmails returns the emails of the enabled clients belonging to a subscription.
+func (s *SubService) getClientEmails(subId string) []string {
+	inbounds, err := s.getInboundsBySubId(subId)
+	if err != nil {
+		return nil
+	}
+	var emails []string
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			if client.Enable && client.SubID == subId {
+				emails = append(emails, client.Email)
+			}
+		}
+	}
+	return emails
+}
+
 func getHostFromXFH(s string) (string, error) {
 	if strings.Contains(s, ":") {
 		realHost, _, err := net.SplitHostPort(s)
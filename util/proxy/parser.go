@@ -0,0 +1,652 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/goccy/go-yaml"
+)
+
+// ParsedOutbound 从分享链接或订阅解析出的出站
+type ParsedOutbound struct {
+	Remark   string         // 备注（链接中的名称）
+	Protocol string         // 出站协议
+	Link     string         // 原始分享链接
+	Config   map[string]any // Xray出站配置（不含tag）
+}
+
+// ParseLink 解析分享链接为Xray出站配置，是LinkGenerator的逆操作
+func ParseLink(link string) (*ParsedOutbound, error) {
+	link = strings.TrimSpace(link)
+	scheme, _, found := strings.Cut(link, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid share link")
+	}
+
+	var (
+		parsed *ParsedOutbound
+		err    error
+	)
+	switch strings.ToLower(scheme) {
+	case "vmess":
+		parsed, err = parseVmessLink(link)
+	case "vless", "trojan":
+		parsed, err = parseUrlLink(link)
+	case "ss":
+		parsed, err = parseShadowsocksLink(link)
+	case "wireguard", "wg":
+		parsed, err = parseWireguardLink(link)
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	parsed.Link = link
+	return parsed, nil
+}
+
+// ParseSubscription 解析订阅内容，支持base64、纯文本链接列表和Clash配置
+func ParseSubscription(body string) ([]*ParsedOutbound, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, fmt.Errorf("empty subscription")
+	}
+
+	if decoded, ok := decodeBase64(body); ok && strings.Contains(decoded, "://") {
+		body = decoded
+	}
+
+	if !strings.Contains(body, "://") || strings.Contains(body, "proxies:") {
+		if outbounds, err := parseClash(body); err == nil && len(outbounds) > 0 {
+			return outbounds, nil
+		}
+	}
+
+	var outbounds []*ParsedOutbound
+	var lastErr error
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parsed, err := ParseLink(line)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		outbounds = append(outbounds, parsed)
+	}
+	if len(outbounds) == 0 {
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("no outbound found in subscription")
+	}
+	return outbounds, nil
+}
+
+// parseVmessLink 解析vmess://base64(json)格式的链接
+func parseVmessLink(link string) (*ParsedOutbound, error) {
+	decoded, ok := decodeBase64(strings.TrimPrefix(link[strings.Index(link, "://")+3:], "/"))
+	if !ok {
+		return nil, fmt.Errorf("invalid vmess link")
+	}
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(decoded), &obj); err != nil {
+		return nil, fmt.Errorf("invalid vmess link: %v", err)
+	}
+
+	address := anyToString(obj["add"])
+	port, err := strconv.Atoi(anyToString(obj["port"]))
+	if err != nil || address == "" {
+		return nil, fmt.Errorf("invalid vmess server")
+	}
+
+	network := anyToString(obj["net"])
+	if network == "" {
+		network = "tcp"
+	}
+	params := url.Values{}
+	params.Set("type", network)
+	params.Set("security", anyToString(obj["tls"]))
+	params.Set("sni", anyToString(obj["sni"]))
+	params.Set("alpn", anyToString(obj["alpn"]))
+	params.Set("fp", anyToString(obj["fp"]))
+	params.Set("host", anyToString(obj["host"]))
+	params.Set("allowInsecure", anyToString(obj["allowInsecure"]))
+	switch network {
+	case "grpc":
+		params.Set("serviceName", anyToString(obj["path"]))
+		params.Set("authority", anyToString(obj["authority"]))
+		if anyToString(obj["type"]) == "multi" {
+			params.Set("mode", "multi")
+		}
+	case "kcp":
+		params.Set("headerType", anyToString(obj["type"]))
+		params.Set("seed", anyToString(obj["path"]))
+	default:
+		params.Set("headerType", anyToString(obj["type"]))
+		params.Set("path", anyToString(obj["path"]))
+	}
+
+	security := anyToString(obj["scy"])
+	if security == "" {
+		security = "auto"
+	}
+	alterId, _ := strconv.Atoi(anyToString(obj["aid"]))
+
+	return &ParsedOutbound{
+		Remark:   anyToString(obj["ps"]),
+		Protocol: "vmess",
+		Config: map[string]any{
+			"protocol": "vmess",
+			"settings": map[string]any{
+				"vnext": []any{
+					map[string]any{
+						"address": address,
+						"port":    port,
+						"users": []any{
+							map[string]any{
+								"id":       anyToString(obj["id"]),
+								"alterId":  alterId,
+								"security": security,
+							},
+						},
+					},
+				},
+			},
+			"streamSettings": buildStreamSettings(params),
+		},
+	}, nil
+}
+
+// parseUrlLink 解析vless://与trojan://格式的链接
+func parseUrlLink(link string) (*ParsedOutbound, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	address, port, err := splitServer(u)
+	if err != nil {
+		return nil, err
+	}
+	credential := u.User.Username()
+	if credential == "" {
+		return nil, fmt.Errorf("missing credential in %s link", u.Scheme)
+	}
+	params := u.Query()
+
+	var settings map[string]any
+	protocol := strings.ToLower(u.Scheme)
+	if protocol == "vless" {
+		encryption := params.Get("encryption")
+		if encryption == "" {
+			encryption = "none"
+		}
+		user := map[string]any{
+			"id":         credential,
+			"encryption": encryption,
+		}
+		if flow := params.Get("flow"); flow != "" {
+			user["flow"] = flow
+		}
+		settings = map[string]any{
+			"vnext": []any{
+				map[string]any{
+					"address": address,
+					"port":    port,
+					"users":   []any{user},
+				},
+			},
+		}
+	} else {
+		settings = map[string]any{
+			"servers": []any{
+				map[string]any{
+					"address":  address,
+					"port":     port,
+					"password": credential,
+				},
+			},
+		}
+	}
+
+	return &ParsedOutbound{
+		Remark:   u.Fragment,
+		Protocol: protocol,
+		Config: map[string]any{
+			"protocol":       protocol,
+			"settings":       settings,
+			"streamSettings": buildStreamSettings(params),
+		},
+	}, nil
+}
+
+// parseShadowsocksLink 解析SIP002及旧版ss://链接
+func parseShadowsocksLink(link string) (*ParsedOutbound, error) {
+	rest := link[strings.Index(link, "://")+3:]
+	remark := ""
+	if i := strings.Index(rest, "#"); i >= 0 {
+		remark, _ = url.PathUnescape(rest[i+1:])
+		rest = rest[:i]
+	}
+
+	// 旧版格式: ss://base64(method:password@host:port)
+	if !strings.Contains(rest, "@") {
+		query := ""
+		if i := strings.Index(rest, "?"); i >= 0 {
+			rest, query = rest[:i], rest[i:]
+		}
+		decoded, ok := decodeBase64(rest)
+		if !ok {
+			return nil, fmt.Errorf("invalid shadowsocks link")
+		}
+		rest = decoded + query
+	}
+
+	u, err := url.Parse("ss://" + rest)
+	if err != nil {
+		return nil, err
+	}
+	address, port, err := splitServer(u)
+	if err != nil {
+		return nil, err
+	}
+
+	method, password := u.User.Username(), ""
+	if p, ok := u.User.Password(); ok {
+		password = p
+	} else if decoded, ok := decodeBase64(method); ok {
+		method, password, _ = strings.Cut(decoded, ":")
+	}
+	if method == "" || password == "" {
+		return nil, fmt.Errorf("invalid shadowsocks credential")
+	}
+
+	params := u.Query()
+	if params.Get("type") == "" {
+		params.Set("type", "tcp")
+	}
+
+	return &ParsedOutbound{
+		Remark:   remark,
+		Protocol: "shadowsocks",
+		Config: map[string]any{
+			"protocol": "shadowsocks",
+			"settings": map[string]any{
+				"servers": []any{
+					map[string]any{
+						"address":  address,
+						"port":     port,
+						"method":   method,
+						"password": password,
+					},
+				},
+			},
+			"streamSettings": buildStreamSettings(params),
+		},
+	}, nil
+}
+
+// parseWireguardLink 解析wireguard://私钥@host:port?publickey=...格式的链接
+func parseWireguardLink(link string) (*ParsedOutbound, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	address, port, err := splitServer(u)
+	if err != nil {
+		return nil, err
+	}
+	secretKey, _ := url.PathUnescape(u.User.Username())
+	params := u.Query()
+	publicKey := firstParam(params, "publickey", "publicKey", "public_key")
+	if secretKey == "" || publicKey == "" {
+		return nil, fmt.Errorf("missing wireguard keys")
+	}
+
+	peer := map[string]any{
+		"publicKey":  publicKey,
+		"endpoint":   net.JoinHostPort(address, strconv.Itoa(port)),
+		"allowedIPs": []string{"0.0.0.0/0", "::/0"},
+	}
+	if psk := firstParam(params, "presharedkey", "preSharedKey", "psk"); psk != "" {
+		peer["preSharedKey"] = psk
+	}
+	settings := map[string]any{
+		"secretKey": secretKey,
+		"address":   splitList(firstParam(params, "address", "ip")),
+		"peers":     []any{peer},
+	}
+	if mtu, err := strconv.Atoi(params.Get("mtu")); err == nil && mtu > 0 {
+		settings["mtu"] = mtu
+	}
+	if reserved := splitList(params.Get("reserved")); len(reserved) > 0 {
+		var values []int
+		for _, r := range reserved {
+			if v, err := strconv.Atoi(r); err == nil {
+				values = append(values, v)
+			}
+		}
+		settings["reserved"] = values
+	}
+
+	return &ParsedOutbound{
+		Remark:   u.Fragment,
+		Protocol: "wireguard",
+		Config: map[string]any{
+			"protocol": "wireguard",
+			"settings": settings,
+		},
+	}, nil
+}
+
+// buildStreamSettings 根据链接参数构建streamSettings
+func buildStreamSettings(params url.Values) map[string]any {
+	network := params.Get("type")
+	if network == "" {
+		network = "tcp"
+	}
+	stream := map[string]any{
+		"network": network,
+	}
+
+	host := params.Get("host")
+	path := params.Get("path")
+	switch network {
+	case "tcp":
+		if params.Get("headerType") == "http" {
+			if path == "" {
+				path = "/"
+			}
+			request := map[string]any{
+				"path": strings.Split(path, ","),
+			}
+			if host != "" {
+				request["headers"] = map[string]any{
+					"Host": strings.Split(host, ","),
+				}
+			}
+			stream["tcpSettings"] = map[string]any{
+				"header": map[string]any{
+					"type":    "http",
+					"request": request,
+				},
+			}
+		}
+	case "kcp":
+		kcp := map[string]any{}
+		if headerType := params.Get("headerType"); headerType != "" {
+			kcp["header"] = map[string]any{"type": headerType}
+		}
+		if seed := params.Get("seed"); seed != "" {
+			kcp["seed"] = seed
+		}
+		stream["kcpSettings"] = kcp
+	case "ws":
+		stream["wsSettings"] = map[string]any{
+			"path": path,
+			"host": host,
+		}
+	case "grpc":
+		stream["grpcSettings"] = map[string]any{
+			"serviceName": params.Get("serviceName"),
+			"authority":   params.Get("authority"),
+			"multiMode":   params.Get("mode") == "multi",
+		}
+	case "httpupgrade":
+		stream["httpupgradeSettings"] = map[string]any{
+			"path": path,
+			"host": host,
+		}
+	case "xhttp":
+		xhttp := map[string]any{
+			"path": path,
+			"host": host,
+		}
+		if mode := params.Get("mode"); mode != "" {
+			xhttp["mode"] = mode
+		}
+		stream["xhttpSettings"] = xhttp
+	}
+
+	switch params.Get("security") {
+	case "tls":
+		stream["security"] = "tls"
+		tls := map[string]any{
+			"serverName":  params.Get("sni"),
+			"fingerprint": params.Get("fp"),
+		}
+		if alpn := splitList(params.Get("alpn")); len(alpn) > 0 {
+			tls["alpn"] = alpn
+		}
+		if insecure := params.Get("allowInsecure"); insecure == "1" || insecure == "true" {
+			tls["allowInsecure"] = true
+		}
+		stream["tlsSettings"] = tls
+	case "reality":
+		stream["security"] = "reality"
+		stream["realitySettings"] = map[string]any{
+			"serverName":  params.Get("sni"),
+			"fingerprint": params.Get("fp"),
+			"publicKey":   params.Get("pbk"),
+			"shortId":     params.Get("sid"),
+			"spiderX":     params.Get("spx"),
+		}
+	default:
+		stream["security"] = "none"
+	}
+	return stream
+}
+
+// parseClash 解析Clash配置中的proxies列表
+func parseClash(body string) ([]*ParsedOutbound, error) {
+	var clash struct {
+		Proxies []map[string]any `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal([]byte(body), &clash); err != nil {
+		return nil, err
+	}
+
+	var outbounds []*ParsedOutbound
+	for _, proxy := range clash.Proxies {
+		parsed, err := parseClashProxy(proxy)
+		if err != nil {
+			continue
+		}
+		outbounds = append(outbounds, parsed)
+	}
+	if len(outbounds) == 0 {
+		return nil, fmt.Errorf("no supported proxy in clash config")
+	}
+	return outbounds, nil
+}
+
+// parseClashProxy 将单个Clash代理转换为分享链接参数后复用链接解析逻辑
+func parseClashProxy(proxy map[string]any) (*ParsedOutbound, error) {
+	name := anyToString(proxy["name"])
+	server := anyToString(proxy["server"])
+	port := anyToString(proxy["port"])
+	if server == "" || port == "" {
+		return nil, fmt.Errorf("invalid clash proxy %s", name)
+	}
+	hostPort := net.JoinHostPort(server, port)
+
+	params := url.Values{}
+	network := anyToString(proxy["network"])
+	if network == "" {
+		network = "tcp"
+	}
+	if network == "h2" || network == "http" {
+		network = "tcp"
+	}
+	params.Set("type", network)
+	if tls, _ := proxy["tls"].(bool); tls {
+		params.Set("security", "tls")
+	}
+	params.Set("sni", firstString(proxy, "servername", "sni"))
+	params.Set("fp", anyToString(proxy["client-fingerprint"]))
+	if insecure, _ := proxy["skip-cert-verify"].(bool); insecure {
+		params.Set("allowInsecure", "1")
+	}
+	if alpn, ok := proxy["alpn"].([]any); ok {
+		var list []string
+		for _, a := range alpn {
+			list = append(list, anyToString(a))
+		}
+		params.Set("alpn", strings.Join(list, ","))
+	}
+	if reality, ok := proxy["reality-opts"].(map[string]any); ok {
+		params.Set("security", "reality")
+		params.Set("pbk", anyToString(reality["public-key"]))
+		params.Set("sid", anyToString(reality["short-id"]))
+	}
+	if ws, ok := proxy["ws-opts"].(map[string]any); ok {
+		params.Set("path", anyToString(ws["path"]))
+		if headers, ok := ws["headers"].(map[string]any); ok {
+			params.Set("host", firstString(headers, "Host", "host"))
+		}
+	}
+	if grpc, ok := proxy["grpc-opts"].(map[string]any); ok {
+		params.Set("serviceName", anyToString(grpc["grpc-service-name"]))
+	}
+	if flow := anyToString(proxy["flow"]); flow != "" {
+		params.Set("flow", flow)
+	}
+
+	fragment := url.PathEscape(name)
+	switch anyToString(proxy["type"]) {
+	case "vmess":
+		cipher := anyToString(proxy["cipher"])
+		obj := map[string]any{
+			"v":    "2",
+			"ps":   name,
+			"add":  server,
+			"port": port,
+			"id":   anyToString(proxy["uuid"]),
+			"aid":  anyToString(proxy["alterId"]),
+			"scy":  cipher,
+			"net":  network,
+			"tls":  params.Get("security"),
+			"sni":  params.Get("sni"),
+			"alpn": params.Get("alpn"),
+			"fp":   params.Get("fp"),
+			"host": params.Get("host"),
+			"path": params.Get("path"),
+		}
+		if network == "grpc" {
+			obj["path"] = params.Get("serviceName")
+		}
+		data, _ := json.Marshal(obj)
+		return ParseLink("vmess://" + base64.StdEncoding.EncodeToString(data))
+	case "vless":
+		return ParseLink(fmt.Sprintf("vless://%s@%s?%s#%s", url.PathEscape(anyToString(proxy["uuid"])), hostPort, params.Encode(), fragment))
+	case "trojan":
+		if params.Get("security") == "" {
+			params.Set("security", "tls")
+		}
+		return ParseLink(fmt.Sprintf("trojan://%s@%s?%s#%s", url.PathEscape(anyToString(proxy["password"])), hostPort, params.Encode(), fragment))
+	case "ss":
+		userInfo := base64.RawURLEncoding.EncodeToString([]byte(anyToString(proxy["cipher"]) + ":" + anyToString(proxy["password"])))
+		return ParseLink(fmt.Sprintf("ss://%s@%s#%s", userInfo, hostPort, fragment))
+	case "wireguard":
+		wg := url.Values{}
+		wg.Set("publickey", anyToString(proxy["public-key"]))
+		wg.Set("presharedkey", firstString(proxy, "pre-shared-key", "preshared-key"))
+		var addresses []string
+		for _, key := range []string{"ip", "ipv6"} {
+			if ip := anyToString(proxy[key]); ip != "" {
+				addresses = append(addresses, ip)
+			}
+		}
+		wg.Set("address", strings.Join(addresses, ","))
+		wg.Set("mtu", anyToString(proxy["mtu"]))
+		if reserved, ok := proxy["reserved"].([]any); ok {
+			var list []string
+			for _, r := range reserved {
+				list = append(list, anyToString(r))
+			}
+			wg.Set("reserved", strings.Join(list, ","))
+		}
+		return ParseLink(fmt.Sprintf("wireguard://%s@%s?%s#%s", url.PathEscape(anyToString(proxy["private-key"])), hostPort, wg.Encode(), fragment))
+	}
+	return nil, fmt.Errorf("unsupported clash proxy type: %v", proxy["type"])
+}
+
+// splitServer 从URL中取出服务器地址和端口
+func splitServer(u *url.URL) (string, int, error) {
+	address := u.Hostname()
+	port, err := strconv.Atoi(u.Port())
+	if address == "" || err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid server address: %s", u.Host)
+	}
+	return address, port, nil
+}
+
+// decodeBase64 依次尝试标准与URL安全的base64编码（含无填充形式）
+func decodeBase64(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.NewReplacer("\r", "", "\n", "").Replace(s)
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.RawStdEncoding,
+		base64.URLEncoding,
+		base64.RawURLEncoding,
+	} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return string(data), true
+		}
+	}
+	return "", false
+}
+
+// anyToString 将JSON/YAML中的任意标量转为字符串
+func anyToString(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// firstParam 返回第一个非空的查询参数
+func firstParam(params url.Values, keys ...string) string {
+	for _, key := range keys {
+		if v := params.Get(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// firstString 返回map中第一个非空的字符串值
+func firstString(m map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if v := anyToString(m[key]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// splitList 拆分逗号分隔的列表并去除空项
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -12,6 +12,11 @@ class DBInbound {
         this.expiryTime = 0;
         this.trafficReset = "never";
         this.lastTrafficResetTime = 0;
+        this.egressOutbound = "";
+        this.egressBalancer = "";
+        this.speedUp = 0;
+        this.speedDown = 0;
+        this.trafficMultiplier = 1;
 
         this.listen = "";
         this.port = 0;
@@ -22,6 +22,7 @@ class AllSetting {
         this.tgBotBackup = false;
         this.tgBotLoginNotify = true;
         this.tgCpu = 80;
+        this.tgOutboundDown = 5;
         this.tgLang = "en-US";
         this.twoFactorEnable = false;
         this.twoFactorToken = "";
@@ -35,6 +36,47 @@ class AllSetting {
         this.subJsonPath = "/json/";
         this.subDomain = "";
         this.externalTrafficInformEnable = false;
+        this.trafficFlushInterval = 60;
+        this.clientIpSource = "log";
+        this.ipLimitBackend = "fail2ban";
+        this.ipBanDuration = 30;
+        this.ipBanWhitelist = "";
+        this.deviceEvictPolicy = "newest";
+        this.deviceGraceWindow = 180;
+        this.connectionLogEnable = false;
+        this.connectionLogRetention = 7;
+        this.connectionLogAnonymize = false;
+        this.torrentStrikeLimit = 3;
+        this.speedLimitEnable = false;
+        this.speedLimitInterface = "";
+        this.throttleSpeedUp = 1;
+        this.throttleSpeedDown = 1;
+        this.gracePeriod = 0;
+        this.graceAction = "throttle";
+        this.graceOutbound = "blocked";
+        this.autoDeleteDays = 0;
+        this.forecastRemindDays = 3;
+        this.trialEnable = false;
+        this.trialInboundId = 0;
+        this.trialPlanId = 0;
+        this.trialHours = 24;
+        this.trialTrafficGB = 1;
+        this.trialDailyCap = 10;
+        this.trustedProxies = "";
+        this.inviteEnable = false;
+        this.inviteDailyCap = 10;
+        this.inviteClientUses = 0;
+        this.inviteTrafficGB = 10;
+        this.inviteDays = 30;
+        this.referralBonusGB = 0;
+        this.referralBonusDays = 0;
+        this.billingCurrency = "USD";
+        this.billingInvoiceDays = 0;
+        this.credentialOverlap = 60;
+        this.leakDetectEnable = false;
+        this.leakSubIps = 20;
+        this.leakConnIps = 10;
+        this.leakAction = "none";
         this.externalTrafficInformURI = "";
         this.subCertFile = "";
         this.subKeyFile = "";
@@ -14,6 +14,14 @@
     used: el.getAttribute('data-used') || '',
     total: el.getAttribute('data-total') || '',
     remained: el.getAttribute('data-remained') || '',
+    usedToday: el.getAttribute('data-used-today') || '',
+    usedMonth: el.getAttribute('data-used-month') || '',
+    dailyAverage: el.getAttribute('data-daily-average') || '',
+    depletionMs: parseInt(el.getAttribute('data-depletion') || '0', 10) || 0,
+    poolName: el.getAttribute('data-pool-name') || '',
+    poolUsed: el.getAttribute('data-pool-used') || '',
+    poolTotal: el.getAttribute('data-pool-total') || '',
+    poolMembers: el.getAttribute('data-pool-members') || '',
     expireMs: (parseInt(el.getAttribute('data-expire') || '0', 10) || 0) * 1000,
     lastOnlineMs: (parseInt(el.getAttribute('data-lastonline') || '0', 10) || 0),
     downloadByte: parseInt(el.getAttribute('data-downloadbyte') || '0', 10) || 0,
@@ -9,12 +9,19 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// APIController handles the main API routes for the 3x-ui panel, including inbounds and server management.
+// APIController handles the main API routes for the 3x-ui panel, including inbounds, outbounds and server management.
 type APIController struct {
 	BaseController
-	inboundController *InboundController
-	serverController  *ServerController
-	Tgbot             service.Tgbot
+	inboundController    *InboundController
+	outboundController   *OutboundController
+	serverController     *ServerController
+	connectionController *ConnectionController
+	routingController    *RoutingController
+	planController       *PlanController
+	poolController       *QuotaPoolController
+	inviteController     *InviteController
+	billingController    *BillingController
+	Tgbot                service.Tgbot
 }
 
 // NewAPIController creates a new APIController instance and initializes its routes.
@@ -44,10 +51,38 @@ func (a *APIController) initRouter(g *gin.RouterGroup) {
 	inbounds := api.Group("/inbounds")
 	a.inboundController = NewInboundController(inbounds)
 
+	// Outbounds API
+	outbounds := api.Group("/outbounds")
+	a.outboundController = NewOutboundController(outbounds)
+
 	// Server API
 	server := api.Group("/server")
 	a.serverController = NewServerController(server)
 
+	// Connection log API
+	connections := api.Group("/connections")
+	a.connectionController = NewConnectionController(connections)
+
+	// Routing presets API
+	routing := api.Group("/routing")
+	a.routingController = NewRoutingController(routing)
+
+	// Plans API
+	plans := api.Group("/plans")
+	a.planController = NewPlanController(plans)
+
+	// Quota pools API
+	pools := api.Group("/pools")
+	a.poolController = NewQuotaPoolController(pools)
+
+	// Invite codes API
+	invites := api.Group("/invites")
+	a.inviteController = NewInviteController(invites)
+
+	// Billing API
+	billing := api.Group("/billing")
+	a.billingController = NewBillingController(billing)
+
 	// Extra routes
 	api.GET("/backuptotgbot", a.BackuptoTgbot)
 }
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BillingController handles HTTP requests for the invoice and payment ledger and its reports.
+type BillingController struct {
+	billingService service.BillingService
+	xrayService    service.XrayService
+}
+
+// NewBillingController creates a new BillingController and sets up its routes.
+func NewBillingController(g *gin.RouterGroup) *BillingController {
+	a := &BillingController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for billing operations.
+func (a *BillingController) initRouter(g *gin.RouterGroup) {
+	g.GET("/invoices", a.getInvoices)
+	g.GET("/revenue", a.getRevenue)
+	g.GET("/overdue", a.getOverdueClients)
+
+	g.POST("/invoices/add", a.addInvoice)
+	g.POST("/invoices/pay/:id", a.payInvoice)
+	g.POST("/invoices/void/:id", a.voidInvoice)
+	g.POST("/invoices/send/:id", a.sendInvoice)
+	g.POST("/payments", a.recordPayment)
+}
+
+// getInvoices retrieves the invoices of a client, or of every client.
+func (a *BillingController) getInvoices(c *gin.Context) {
+	invoices, err := a.billingService.GetInvoices(c.Query("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, invoices, nil)
+}
+
+// getRevenue retrieves the revenue per day or month.
+func (a *BillingController) getRevenue(c *gin.Context) {
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	revenue, err := a.billingService.GetRevenue(c.DefaultQuery("period", "month"), from, to)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, revenue, nil)
+}
+
+// getOverdueClients retrieves the clients with overdue invoices.
+func (a *BillingController) getOverdueClients(c *gin.Context) {
+	clients, err := a.billingService.GetOverdueClients()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, clients, nil)
+}
+
+// addInvoice creates a new unpaid invoice.
+func (a *BillingController) addInvoice(c *gin.Context) {
+	invoice := &model.Invoice{}
+	err := c.ShouldBind(invoice)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.invoiceAddSuccess"), err)
+		return
+	}
+	invoice, err = a.billingService.AddInvoice(invoice)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.invoiceAddSuccess"), invoice, nil)
+}
+
+// payInvoice records the payment of an invoice.
+func (a *BillingController) payInvoice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.invoicePaySuccess"), err)
+		return
+	}
+	needRestart, err := a.billingService.PayInvoice(id, c.PostForm("method"), c.PostForm("reference"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.invoicePaySuccess"), id, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// recordPayment records a payment posted by an external system.
+func (a *BillingController) recordPayment(c *gin.Context) {
+	payment := &model.Invoice{}
+	err := c.ShouldBind(payment)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.invoicePaySuccess"), err)
+		return
+	}
+	invoice, needRestart, err := a.billingService.RecordPayment(payment)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.invoicePaySuccess"), invoice, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// voidInvoice cancels an unpaid invoice.
+func (a *BillingController) voidInvoice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.invoiceVoidSuccess"), err)
+		return
+	}
+	err = a.billingService.VoidInvoice(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.invoiceVoidSuccess"), id, nil)
+}
+
+// sendInvoice sends an invoice, or its receipt once paid, to the client through the bot.
+func (a *BillingController) sendInvoice(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.invoiceSendSuccess"), err)
+		return
+	}
+	err = a.billingService.SendInvoice(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.invoiceSendSuccess"), id, nil)
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConnectionController handles HTTP requests for the connection log read from the Xray access log.
+type ConnectionController struct {
+	connectionLogService service.ConnectionLogService
+}
+
+// NewConnectionController creates a new ConnectionController and sets up its routes.
+func NewConnectionController(g *gin.RouterGroup) *ConnectionController {
+	a := &ConnectionController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for connection log operations.
+func (a *ConnectionController) initRouter(g *gin.RouterGroup) {
+	g.GET("/search", a.searchConnections)
+	g.GET("/stats/:email", a.getClientDestinationStats)
+}
+
+// searchConnections filters the connection log by client, source IP, destination domain, outbound and time range.
+func (a *ConnectionController) searchConnections(c *gin.Context) {
+	filter := &service.ConnectionLogFilter{}
+	err := c.ShouldBind(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	page, err := a.connectionLogService.SearchConnectionLogs(filter)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, page, nil)
+}
+
+// getClientDestinationStats retrieves the top destinations, ports and countries of a client.
+func (a *ConnectionController) getClientDestinationStats(c *gin.Context) {
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	top, err := strconv.Atoi(c.DefaultQuery("top", "10"))
+	if err != nil {
+		top = 10
+	}
+	stats, err := a.connectionLogService.GetClientDestinationStats(c.Param("email"), from, to, top)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, stats, nil)
+}
@@ -8,14 +8,19 @@ import (
 	"github.com/agassiz/3x-ui/v2/database/model"
 	"github.com/agassiz/3x-ui/v2/web/service"
 	"github.com/agassiz/3x-ui/v2/web/session"
+	"github.com/agassiz/3x-ui/v2/xray"
 
 	"github.com/gin-gonic/gin"
 )
 
 // InboundController handles HTTP requests related to Xray inbounds management.
 type InboundController struct {
-	inboundService service.InboundService
-	xrayService    service.XrayService
+	inboundService     service.InboundService
+	xrayService        service.XrayService
+	ipBanService       service.IpBanService
+	deviceLimitService service.DeviceLimitService
+	rotationService    service.CredentialRotationService
+	leakService        service.LeakDetectionService
 }
 
 // NewInboundController creates a new InboundController and sets up its routes.
@@ -32,21 +37,36 @@ func (a *InboundController) initRouter(g *gin.RouterGroup) {
 	g.GET("/get/:id", a.getInbound)
 	g.GET("/getClientTraffics/:email", a.getClientTraffics)
 	g.GET("/getClientTrafficsById/:id", a.getClientTrafficsById)
+	g.GET("/history/client/:email", a.getClientTrafficHistory)
+	g.GET("/history/inbound/:id", a.getInboundTrafficHistory)
+	g.GET("/history/tag/:tag", a.getInboundTrafficHistoryByTag)
+	g.GET("/usage/snapshots", a.getUsageSnapshots)
+	g.GET("/usage/month", a.getMonthlyUsage)
+	g.GET("/ipBans", a.getIpBans)
+	g.GET("/sessions/:email", a.getClientSessions)
+	g.GET("/leaks/scores", a.getLeakScores)
+	g.GET("/leaks/reports", a.getLeakReports)
 
 	g.POST("/add", a.addInbound)
 	g.POST("/del/:id", a.delInbound)
 	g.POST("/update/:id", a.updateInbound)
 	g.POST("/clientIps/:email", a.getClientIps)
 	g.POST("/clearClientIps/:email", a.clearClientIps)
+	g.POST("/unbanIp/:ip", a.unbanIp)
+	g.POST("/unbanAllIps", a.unbanAllIps)
+	g.POST("/leaks/dismiss/:email", a.dismissLeakReport)
+	g.POST("/clearSessions/:email", a.clearClientSessions)
 	g.POST("/addClient", a.addInboundClient)
 	g.POST("/:id/delClient/:clientId", a.delInboundClient)
 	g.POST("/updateClient/:clientId", a.updateInboundClient)
 	g.POST("/:id/resetClientTraffic/:email", a.resetClientTraffic)
+	g.POST("/:id/rotateClient/:email", a.rotateClientCredential)
 	g.POST("/resetAllTraffics", a.resetAllTraffics)
 	g.POST("/resetAllClientTraffics/:id", a.resetAllClientTraffics)
 	g.POST("/delDepletedClients/:id", a.delDepletedClients)
 	g.POST("/import", a.importInbound)
 	g.POST("/onlines", a.onlines)
+	g.POST("/onlineIps", a.onlineIps)
 	g.POST("/lastOnline", a.lastOnline)
 	g.POST("/updateClientTraffic/:email", a.updateClientTraffic)
 	g.POST("/:id/delClientByEmail/:email", a.delInboundClientByEmail)
@@ -86,6 +106,9 @@ func (a *InboundController) getClientTraffics(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
 		return
 	}
+	if clientTraffics != nil {
+		a.inboundService.FillForecasts([]*xray.ClientTraffic{clientTraffics})
+	}
 	jsonObj(c, clientTraffics, nil)
 }
 
@@ -97,9 +120,81 @@ func (a *InboundController) getClientTrafficsById(c *gin.Context) {
 		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
 		return
 	}
+	traffics := make([]*xray.ClientTraffic, 0, len(clientTraffics))
+	for i := range clientTraffics {
+		traffics = append(traffics, &clientTraffics[i])
+	}
+	a.inboundService.FillForecasts(traffics)
 	jsonObj(c, clientTraffics, nil)
 }
 
+// getClientTrafficHistory retrieves the traffic history of a client.
+func (a *InboundController) getClientTrafficHistory(c *gin.Context) {
+	period, from, to := trafficHistoryQuery(c)
+	history, err := a.inboundService.GetClientTrafficHistory(c.Param("email"), period, from, to)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
+		return
+	}
+	jsonObj(c, history, nil)
+}
+
+// getInboundTrafficHistory retrieves the traffic history of all clients of an inbound by its ID.
+func (a *InboundController) getInboundTrafficHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	period, from, to := trafficHistoryQuery(c)
+	history, err := a.inboundService.GetInboundTrafficHistory(id, period, from, to)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
+		return
+	}
+	jsonObj(c, history, nil)
+}
+
+// getInboundTrafficHistoryByTag retrieves the traffic history of all clients of an inbound by its tag.
+func (a *InboundController) getInboundTrafficHistoryByTag(c *gin.Context) {
+	period, from, to := trafficHistoryQuery(c)
+	history, err := a.inboundService.GetInboundTrafficHistoryByTag(c.Param("tag"), period, from, to)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
+		return
+	}
+	jsonObj(c, history, nil)
+}
+
+// getUsageSnapshots retrieves the usage archived at traffic resets, filtered by email, inbound and time range.
+func (a *InboundController) getUsageSnapshots(c *gin.Context) {
+	inboundId, _ := strconv.Atoi(c.Query("inboundId"))
+	_, from, to := trafficHistoryQuery(c)
+	snapshots, err := a.inboundService.GetUsageSnapshots(c.Query("email"), inboundId, from, to)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
+		return
+	}
+	jsonObj(c, snapshots, nil)
+}
+
+// getMonthlyUsage retrieves the usage of every client over a calendar month, the previous one by default.
+func (a *InboundController) getMonthlyUsage(c *gin.Context) {
+	usage, err := a.inboundService.GetMonthlyUsage(c.Query("month"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.trafficGetError"), err)
+		return
+	}
+	jsonObj(c, usage, nil)
+}
+
+// trafficHistoryQuery reads the bucket period and the time range of a history request.
+func trafficHistoryQuery(c *gin.Context) (string, int64, int64) {
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	return c.DefaultQuery("period", "hour"), from, to
+}
+
 // addInbound creates a new inbound configuration.
 func (a *InboundController) addInbound(c *gin.Context) {
 	inbound := &model.Inbound{}
@@ -196,6 +291,86 @@ func (a *InboundController) clearClientIps(c *gin.Context) {
 	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.logCleanSuccess"), nil)
 }
 
+// getIpBans retrieves the source IPs banned by the built-in IP limit enforcement.
+func (a *InboundController) getIpBans(c *gin.Context) {
+	bans, err := a.ipBanService.GetBans()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, bans, nil)
+}
+
+// unbanIp lifts the ban of a source IP.
+func (a *InboundController) unbanIp(c *gin.Context) {
+	err := a.ipBanService.Unban(c.Param("ip"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.unbanIpSuccess"), nil)
+}
+
+// unbanAllIps lifts all IP bans.
+func (a *InboundController) unbanAllIps(c *gin.Context) {
+	err := a.ipBanService.UnbanAll()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.unbanAllIpsSuccess"), nil)
+}
+
+// getLeakScores retrieves the sharing scores of the clients showing any sharing signal.
+func (a *InboundController) getLeakScores(c *gin.Context) {
+	scores, err := a.leakService.GetLeakScores()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, scores, nil)
+}
+
+// getLeakReports retrieves the clients reported for a likely subscription leak.
+func (a *InboundController) getLeakReports(c *gin.Context) {
+	reports, err := a.leakService.GetLeakReports()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, reports, nil)
+}
+
+// dismissLeakReport removes the leak report of a client.
+func (a *InboundController) dismissLeakReport(c *gin.Context) {
+	err := a.leakService.DelLeakReport(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.dismissLeakSuccess"), nil)
+}
+
+// getClientSessions retrieves the device sessions tracked for a client's device limit.
+func (a *InboundController) getClientSessions(c *gin.Context) {
+	sessions, err := a.deviceLimitService.GetSessions(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, sessions, nil)
+}
+
+// clearClientSessions forgets the device sessions of a client, freeing its device slots.
+func (a *InboundController) clearClientSessions(c *gin.Context) {
+	err := a.deviceLimitService.ClearSessions(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.clearSessionsSuccess"), nil)
+}
+
 // addInboundClient adds a new client to an existing inbound.
 func (a *InboundController) addInboundClient(c *gin.Context) {
 	data := &model.Inbound{}
@@ -278,6 +453,26 @@ func (a *InboundController) resetClientTraffic(c *gin.Context) {
 	}
 }
 
+// rotateClientCredential generates a new UUID or password for a client, keeping the previous one valid for the overlap.
+func (a *InboundController) rotateClientCredential(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inboundUpdateSuccess"), err)
+		return
+	}
+	email := c.Param("email")
+
+	needRestart, err := a.rotationService.RotateClientCredential(id, email)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.rotateCredentialSuccess"), nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
 // resetAllTraffics resets all traffic counters across all inbounds.
 func (a *InboundController) resetAllTraffics(c *gin.Context) {
 	err := a.inboundService.ResetAllTraffics()
@@ -358,6 +553,11 @@ func (a *InboundController) onlines(c *gin.Context) {
 	jsonObj(c, a.inboundService.GetOnlineClients(), nil)
 }
 
+// onlineIps retrieves the live IPs of online clients read from the Xray stats service.
+func (a *InboundController) onlineIps(c *gin.Context) {
+	jsonObj(c, a.inboundService.GetOnlineClientIps(), nil)
+}
+
 // lastOnline retrieves the last online timestamps for clients.
 func (a *InboundController) lastOnline(c *gin.Context) {
 	data, err := a.inboundService.GetClientsLastOnline()
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InviteController handles HTTP requests for invite codes and the referrals made with them.
+type InviteController struct {
+	inviteService service.InviteService
+}
+
+// NewInviteController creates a new InviteController and sets up its routes.
+func NewInviteController(g *gin.RouterGroup) *InviteController {
+	a := &InviteController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for invite code operations.
+func (a *InviteController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getInviteCodes)
+	g.GET("/referrals", a.getReferralTree)
+
+	g.POST("/add", a.addInviteCode)
+	g.POST("/del/:id", a.delInviteCode)
+}
+
+// getInviteCodes retrieves every invite code.
+func (a *InviteController) getInviteCodes(c *gin.Context) {
+	codes, err := a.inviteService.GetInviteCodes()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, codes, nil)
+}
+
+// getReferralTree retrieves the referral trees of the clients.
+func (a *InviteController) getReferralTree(c *gin.Context) {
+	tree, err := a.inviteService.GetReferralTree()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, tree, nil)
+}
+
+// addInviteCode creates a new invite code.
+func (a *InviteController) addInviteCode(c *gin.Context) {
+	invite := &model.InviteCode{}
+	err := c.ShouldBind(invite)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inviteAddSuccess"), err)
+		return
+	}
+	invite, err = a.inviteService.AddInviteCode(invite)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inviteAddSuccess"), invite, nil)
+}
+
+// delInviteCode deletes an invite code by its ID.
+func (a *InviteController) delInviteCode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.inviteDelSuccess"), err)
+		return
+	}
+	err = a.inviteService.DelInviteCode(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.inviteDelSuccess"), id, nil)
+}
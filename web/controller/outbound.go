@@ -0,0 +1,295 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OutboundController handles HTTP requests related to panel-managed outbounds and outbound subscriptions.
+type OutboundController struct {
+	outboundService service.OutboundService
+	xrayService     service.XrayService
+}
+
+// NewOutboundController creates a new OutboundController and sets up its routes.
+func NewOutboundController(g *gin.RouterGroup) *OutboundController {
+	a := &OutboundController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for outbound-related operations.
+func (a *OutboundController) initRouter(g *gin.RouterGroup) {
+
+	g.GET("/list", a.getOutbounds)
+	g.GET("/get/:id", a.getOutbound)
+	g.GET("/subscriptions", a.getSubscriptions)
+	g.GET("/health", a.getOutboundsHealth)
+	g.GET("/balancers", a.getBalancersStatus)
+	g.GET("/traffic/history/:tag", a.getTrafficHistory)
+
+	g.POST("/add", a.addOutbound)
+	g.POST("/del/:id", a.delOutbound)
+	g.POST("/update/:id", a.updateOutbound)
+	g.POST("/parse", a.parseOutbounds)
+	g.POST("/import", a.importOutbounds)
+	g.POST("/subscriptions/add", a.addSubscription)
+	g.POST("/subscriptions/del/:id", a.delSubscription)
+	g.POST("/subscriptions/update/:id", a.updateSubscription)
+	g.POST("/subscriptions/refresh/:id", a.refreshSubscription)
+	g.POST("/traffic/update/:tag", a.updateTrafficLimit)
+}
+
+// getOutbounds retrieves the list of panel-managed outbounds.
+func (a *OutboundController) getOutbounds(c *gin.Context) {
+	outbounds, err := a.outboundService.GetOutbounds()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, outbounds, nil)
+}
+
+// getOutbound retrieves a panel-managed outbound by its ID.
+func (a *OutboundController) getOutbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	outbound, err := a.outboundService.GetOutbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, outbound, nil)
+}
+
+// getOutboundsHealth retrieves the observatory status and probe history of outbounds.
+func (a *OutboundController) getOutboundsHealth(c *gin.Context) {
+	jsonObj(c, a.outboundService.GetOutboundsHealth(), nil)
+}
+
+// getBalancersStatus retrieves the current selection of every routing balancer.
+func (a *OutboundController) getBalancersStatus(c *gin.Context) {
+	balancers, err := a.outboundService.GetBalancersStatus()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, balancers, nil)
+}
+
+// addOutbound creates a panel-managed outbound from a JSON config or a share link.
+func (a *OutboundController) addOutbound(c *gin.Context) {
+	outbound := &model.Outbound{}
+	err := c.ShouldBind(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundCreateSuccess"), err)
+		return
+	}
+	needRestart, err := a.outboundService.AddOutbound(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundCreateSuccess"), outbound, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// delOutbound deletes a panel-managed outbound by its ID.
+func (a *OutboundController) delOutbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundDeleteSuccess"), err)
+		return
+	}
+	needRestart, err := a.outboundService.DelOutbound(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundDeleteSuccess"), id, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// updateOutbound updates a panel-managed outbound.
+func (a *OutboundController) updateOutbound(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundUpdateSuccess"), err)
+		return
+	}
+	outbound := &model.Outbound{
+		Id: id,
+	}
+	err = c.ShouldBind(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundUpdateSuccess"), err)
+		return
+	}
+	outbound.Id = id
+	needRestart, err := a.outboundService.UpdateOutbound(outbound)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundUpdateSuccess"), outbound, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// parseOutbounds converts share links or a subscription body to outbounds without saving them.
+func (a *OutboundController) parseOutbounds(c *gin.Context) {
+	outbounds, err := a.outboundService.ParseOutbounds(c.PostForm("links"), c.PostForm("tagPrefix"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, outbounds, nil)
+}
+
+// importOutbounds stores outbounds parsed from share links or a subscription body.
+func (a *OutboundController) importOutbounds(c *gin.Context) {
+	outbounds, needRestart, err := a.outboundService.ImportOutbounds(c.PostForm("links"), c.PostForm("tagPrefix"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundImportSuccess"), outbounds, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// getSubscriptions retrieves the list of outbound subscriptions.
+func (a *OutboundController) getSubscriptions(c *gin.Context) {
+	subscriptions, err := a.outboundService.GetSubscriptions()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, subscriptions, nil)
+}
+
+// addSubscription creates an outbound subscription and fetches it.
+func (a *OutboundController) addSubscription(c *gin.Context) {
+	subscription := &model.OutboundSubscription{}
+	err := c.ShouldBind(subscription)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionCreateSuccess"), err)
+		return
+	}
+	needRestart, err := a.outboundService.AddSubscription(subscription)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionCreateSuccess"), subscription, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// delSubscription deletes an outbound subscription and its outbounds.
+func (a *OutboundController) delSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionDeleteSuccess"), err)
+		return
+	}
+	needRestart, err := a.outboundService.DelSubscription(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionDeleteSuccess"), id, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// updateSubscription updates the settings of an outbound subscription.
+func (a *OutboundController) updateSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionUpdateSuccess"), err)
+		return
+	}
+	subscription := &model.OutboundSubscription{}
+	err = c.ShouldBind(subscription)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionUpdateSuccess"), err)
+		return
+	}
+	subscription.Id = id
+	needRestart, err := a.outboundService.UpdateSubscription(subscription)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionUpdateSuccess"), subscription, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// refreshSubscription fetches an outbound subscription immediately.
+func (a *OutboundController) refreshSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionRefreshSuccess"), err)
+		return
+	}
+	needRestart, err := a.outboundService.RefreshSubscription(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.subscriptionRefreshSuccess"), nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// getTrafficHistory retrieves the hourly or daily traffic history of an outbound.
+func (a *OutboundController) getTrafficHistory(c *gin.Context) {
+	period := c.DefaultQuery("period", "hour")
+	from, _ := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+	history, err := a.outboundService.GetOutboundTrafficHistory(c.Param("tag"), period, from, to)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.obtain"), err)
+		return
+	}
+	jsonObj(c, history, nil)
+}
+
+// updateTrafficLimit updates the quota, monthly reset day and quota action of an outbound.
+func (a *OutboundController) updateTrafficLimit(c *gin.Context) {
+	limit := &model.OutboundTraffics{}
+	err := c.ShouldBind(limit)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundQuotaUpdateSuccess"), err)
+		return
+	}
+	limit.Tag = c.Param("tag")
+	needRestart, err := a.outboundService.UpdateOutboundQuota(limit)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.outbound.toasts.outboundQuotaUpdateSuccess"), limit, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlanController handles HTTP requests for the plans clients can be assigned to.
+type PlanController struct {
+	planService service.PlanService
+}
+
+// NewPlanController creates a new PlanController and sets up its routes.
+func NewPlanController(g *gin.RouterGroup) *PlanController {
+	a := &PlanController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for plan operations.
+func (a *PlanController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getPlans)
+	g.GET("/get/:id", a.getPlan)
+
+	g.POST("/add", a.addPlan)
+	g.POST("/update/:id", a.updatePlan)
+	g.POST("/del/:id", a.delPlan)
+}
+
+// getPlans retrieves every plan.
+func (a *PlanController) getPlans(c *gin.Context) {
+	plans, err := a.planService.GetPlans()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, plans, nil)
+}
+
+// getPlan retrieves a plan by its ID.
+func (a *PlanController) getPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	plan, err := a.planService.GetPlan(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, plan, nil)
+}
+
+// addPlan creates a new plan.
+func (a *PlanController) addPlan(c *gin.Context) {
+	plan := &model.Plan{}
+	err := c.ShouldBind(plan)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.planAddSuccess"), err)
+		return
+	}
+	plan, err = a.planService.AddPlan(plan)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.planAddSuccess"), plan, nil)
+}
+
+// updatePlan updates the limits of an existing plan.
+func (a *PlanController) updatePlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.planUpdateSuccess"), err)
+		return
+	}
+	plan := &model.Plan{}
+	err = c.ShouldBind(plan)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.planUpdateSuccess"), err)
+		return
+	}
+	plan.Id = id
+	plan, err = a.planService.UpdatePlan(plan)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.planUpdateSuccess"), plan, nil)
+}
+
+// delPlan deletes a plan by its ID.
+func (a *PlanController) delPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.planDelSuccess"), err)
+		return
+	}
+	err = a.planService.DelPlan(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.planDelSuccess"), id, nil)
+}
@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaPoolController handles HTTP requests for the traffic quotas shared by several clients.
+type QuotaPoolController struct {
+	poolService service.QuotaPoolService
+	xrayService service.XrayService
+}
+
+// NewQuotaPoolController creates a new QuotaPoolController and sets up its routes.
+func NewQuotaPoolController(g *gin.RouterGroup) *QuotaPoolController {
+	a := &QuotaPoolController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for quota pool operations.
+func (a *QuotaPoolController) initRouter(g *gin.RouterGroup) {
+	g.GET("/list", a.getPools)
+	g.GET("/get/:id", a.getPool)
+
+	g.POST("/add", a.addPool)
+	g.POST("/update/:id", a.updatePool)
+	g.POST("/del/:id", a.delPool)
+	g.POST("/reset/:id", a.resetPool)
+}
+
+// getPools retrieves every pool with the usage of its members.
+func (a *QuotaPoolController) getPools(c *gin.Context) {
+	pools, err := a.poolService.GetPools()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, pools, nil)
+}
+
+// getPool retrieves a pool with the usage of its members by its ID.
+func (a *QuotaPoolController) getPool(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "get"), err)
+		return
+	}
+	pool, err := a.poolService.GetPool(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, pool, nil)
+}
+
+// addPool creates a new pool.
+func (a *QuotaPoolController) addPool(c *gin.Context) {
+	pool := &model.QuotaPool{}
+	err := c.ShouldBind(pool)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.poolAddSuccess"), err)
+		return
+	}
+	pool, err = a.poolService.AddPool(pool)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.poolAddSuccess"), pool, nil)
+}
+
+// updatePool updates the name and quota of a pool.
+func (a *QuotaPoolController) updatePool(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.poolUpdateSuccess"), err)
+		return
+	}
+	pool := &model.QuotaPool{}
+	err = c.ShouldBind(pool)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.poolUpdateSuccess"), err)
+		return
+	}
+	pool.Id = id
+	pool, err = a.poolService.UpdatePool(pool)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.poolUpdateSuccess"), pool, nil)
+}
+
+// delPool deletes a pool without members.
+func (a *QuotaPoolController) delPool(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.poolDelSuccess"), err)
+		return
+	}
+	err = a.poolService.DelPool(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.inbounds.toasts.poolDelSuccess"), id, nil)
+}
+
+// resetPool resets the traffic of every member of a pool.
+func (a *QuotaPoolController) resetPool(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.poolResetSuccess"), err)
+		return
+	}
+	needRestart, err := a.poolService.ResetPool(id)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.inbounds.toasts.poolResetSuccess"), nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/web/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoutingController handles HTTP requests for the routing presets managed by the panel and the BitTorrent strikes.
+type RoutingController struct {
+	presetService service.RoutingPresetService
+	xrayService   service.XrayService
+}
+
+// NewRoutingController creates a new RoutingController and sets up its routes.
+func NewRoutingController(g *gin.RouterGroup) *RoutingController {
+	a := &RoutingController{}
+	a.initRouter(g)
+	return a
+}
+
+// initRouter initializes the routes for routing preset operations.
+func (a *RoutingController) initRouter(g *gin.RouterGroup) {
+	g.GET("/presets", a.getPresets)
+	g.GET("/strikes", a.getStrikes)
+
+	g.POST("/presets/update", a.updatePreset)
+	g.POST("/strikes/reset/:email", a.resetStrikes)
+}
+
+// getPresets retrieves the block presets with their state.
+func (a *RoutingController) getPresets(c *gin.Context) {
+	presets, err := a.presetService.GetPresets()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, presets, nil)
+}
+
+// updatePreset enables, disables or scopes a block preset.
+func (a *RoutingController) updatePreset(c *gin.Context) {
+	preset := &model.RoutingPreset{}
+	err := c.ShouldBind(preset)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "pages.xray.rules.toasts.presetUpdateSuccess"), err)
+		return
+	}
+	needRestart, err := a.presetService.UpdatePreset(preset)
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsgObj(c, I18nWeb(c, "pages.xray.rules.toasts.presetUpdateSuccess"), preset, nil)
+	if needRestart {
+		a.xrayService.SetToNeedRestart()
+	}
+}
+
+// getStrikes retrieves the BitTorrent strikes of clients.
+func (a *RoutingController) getStrikes(c *gin.Context) {
+	strikes, err := a.presetService.GetStrikes()
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonObj(c, strikes, nil)
+}
+
+// resetStrikes clears the BitTorrent strikes of a client.
+func (a *RoutingController) resetStrikes(c *gin.Context) {
+	err := a.presetService.ResetStrikes(c.Param("email"))
+	if err != nil {
+		jsonMsg(c, I18nWeb(c, "somethingWentWrong"), err)
+		return
+	}
+	jsonMsg(c, I18nWeb(c, "pages.xray.rules.toasts.strikesResetSuccess"), nil)
+}
@@ -111,10 +111,13 @@ func (a *XraySettingController) getOutboundsTraffic(c *gin.Context) {
 // resetOutboundsTraffic resets the traffic statistics for the specified outbound tag.
 func (a *XraySettingController) resetOutboundsTraffic(c *gin.Context) {
 	tag := c.PostForm("tag")
-	err := a.OutboundService.ResetOutboundTraffic(tag)
+	needRestart, err := a.OutboundService.ResetOutboundTraffic(tag)
 	if err != nil {
 		jsonMsg(c, I18nWeb(c, "pages.settings.toasts.resetOutboundTrafficError"), err)
 		return
 	}
 	jsonObj(c, "", nil)
+	if needRestart {
+		a.XrayService.SetToNeedRestart()
+	}
 }
@@ -46,6 +46,7 @@ type AllSetting struct {
 	TgBotBackup      bool   `json:"tgBotBackup" form:"tgBotBackup"`           // Enable database backup via Telegram
 	TgBotLoginNotify bool   `json:"tgBotLoginNotify" form:"tgBotLoginNotify"` // Send login notifications
 	TgCpu            int    `json:"tgCpu" form:"tgCpu"`                       // CPU usage threshold for alerts
+	TgOutboundDown   int    `json:"tgOutboundDown" form:"tgOutboundDown"`     // Minutes an outbound must stay dead before alerting
 	TgLang           string `json:"tgLang" form:"tgLang"`                     // Telegram bot language
 
 	// Security settings
@@ -66,6 +67,47 @@ type AllSetting struct {
 	SubUpdates                  int    `json:"subUpdates" form:"subUpdates"`                                   // Subscription update interval in minutes
 	ExternalTrafficInformEnable bool   `json:"externalTrafficInformEnable" form:"externalTrafficInformEnable"` // Enable external traffic reporting
 	ExternalTrafficInformURI    string `json:"externalTrafficInformURI" form:"externalTrafficInformURI"`       // URI for external traffic reporting
+	TrafficFlushInterval        int    `json:"trafficFlushInterval" form:"trafficFlushInterval"`               // Seconds traffic is kept in memory before it is written to the database
+	ClientIpSource              string `json:"clientIpSource" form:"clientIpSource"`                           // Where client IPs are read from: log (access log) or stats (Xray stats service)
+	IpLimitBackend              string `json:"ipLimitBackend" form:"ipLimitBackend"`                           // How client IP limits are enforced: fail2ban, xray, nftables or iptables
+	IpBanDuration               int    `json:"ipBanDuration" form:"ipBanDuration"`                             // Minutes an IP exceeding a limit stays banned, 0 for permanent
+	IpBanWhitelist              string `json:"ipBanWhitelist" form:"ipBanWhitelist"`                           // IPs and CIDRs never banned, separated by commas or new lines
+	DeviceEvictPolicy           string `json:"deviceEvictPolicy" form:"deviceEvictPolicy"`                     // Sessions evicted over a device limit: newest or oldest
+	DeviceGraceWindow           int    `json:"deviceGraceWindow" form:"deviceGraceWindow"`                     // Seconds a quiet device keeps its slot, covering IP roaming
+	ConnectionLogEnable         bool   `json:"connectionLogEnable" form:"connectionLogEnable"`                 // Ingest the Xray access log into the searchable connection log
+	ConnectionLogRetention      int    `json:"connectionLogRetention" form:"connectionLogRetention"`           // Days connection log entries are kept, 0 to keep them forever
+	ConnectionLogAnonymize      bool   `json:"connectionLogAnonymize" form:"connectionLogAnonymize"`           // Store source IPs truncated to their /24 or /48 network
+	TorrentStrikeLimit          int    `json:"torrentStrikeLimit" form:"torrentStrikeLimit"`                   // BitTorrent strikes before a client is disabled, 0 to only warn
+	SpeedLimitEnable            bool   `json:"speedLimitEnable" form:"speedLimitEnable"`                       // Shape client bandwidth with Linux tc
+	SpeedLimitInterface         string `json:"speedLimitInterface" form:"speedLimitInterface"`                 // Network interface shaped, empty to use the default route's
+	ThrottleSpeedUp             int    `json:"throttleSpeedUp" form:"throttleSpeedUp"`                         // Upload cap in Mbps of clients past their soft quota
+	ThrottleSpeedDown           int    `json:"throttleSpeedDown" form:"throttleSpeedDown"`                     // Download cap in Mbps of clients past their soft quota
+	GracePeriod                 int    `json:"gracePeriod" form:"gracePeriod"`                                 // Hours an expired or exhausted client keeps access before it is disabled, 0 to disable at once
+	GraceAction                 string `json:"graceAction" form:"graceAction"`                                 // What limits clients in their grace period: throttle or route
+	GraceOutbound               string `json:"graceOutbound" form:"graceOutbound"`                             // Outbound the traffic of clients in their grace period is routed to
+	AutoDeleteDays              int    `json:"autoDeleteDays" form:"autoDeleteDays"`                           // Days after which expired or exhausted clients are deleted, 0 to keep them
+	ForecastRemindDays          int    `json:"forecastRemindDays" form:"forecastRemindDays"`                   // Days ahead of a forecast quota depletion clients are reminded, 0 for no reminders
+	TrialEnable                 bool   `json:"trialEnable" form:"trialEnable"`                                 // Let visitors and Telegram users claim a trial account
+	TrialInboundId              int    `json:"trialInboundId" form:"trialInboundId"`                           // Inbound trial clients are created on
+	TrialPlanId                 int    `json:"trialPlanId" form:"trialPlanId"`                                 // Plan trial clients are assigned to, 0 for none
+	TrialHours                  int    `json:"trialHours" form:"trialHours"`                                   // Hours a trial account lasts before it is deleted
+	TrialTrafficGB              int    `json:"trialTrafficGB" form:"trialTrafficGB"`                           // Traffic quota of a trial account in GB, 0 for unlimited
+	TrialDailyCap               int    `json:"trialDailyCap" form:"trialDailyCap"`                             // Trial accounts handed out per day, 0 for no cap
+	TrustedProxies              string `json:"trustedProxies" form:"trustedProxies"`                           // Reverse proxies whose forwarding headers give the visitor IP of signups
+	InviteEnable                bool   `json:"inviteEnable" form:"inviteEnable"`                               // Let visitors and Telegram users redeem invite codes
+	InviteDailyCap              int    `json:"inviteDailyCap" form:"inviteDailyCap"`                           // Invite codes redeemed per day, 0 for no cap
+	InviteClientUses            int    `json:"inviteClientUses" form:"inviteClientUses"`                       // Uses of the invite code each client can share, 0 to let only admins create codes
+	InviteTrafficGB             int    `json:"inviteTrafficGB" form:"inviteTrafficGB"`                         // Traffic quota in GB of clients invited by other clients, 0 for unlimited
+	InviteDays                  int    `json:"inviteDays" form:"inviteDays"`                                   // Days clients invited by other clients last, 0 for no expiry
+	ReferralBonusGB             int    `json:"referralBonusGB" form:"referralBonusGB"`                         // Traffic in GB added to a client for each client it referred
+	ReferralBonusDays           int    `json:"referralBonusDays" form:"referralBonusDays"`                     // Days added to a client for each client it referred
+	BillingCurrency             string `json:"billingCurrency" form:"billingCurrency"`                         // Currency shown with plan prices and invoice amounts
+	BillingInvoiceDays          int    `json:"billingInvoiceDays" form:"billingInvoiceDays"`                   // Days before expiry clients on a priced plan are invoiced, 0 to invoice by hand
+	CredentialOverlap           int    `json:"credentialOverlap" form:"credentialOverlap"`                     // Minutes the previous UUID or password of a rotated client keeps working
+	LeakDetectEnable            bool   `json:"leakDetectEnable" form:"leakDetectEnable"`                       // Score clients for subscription sharing and report likely leaks
+	LeakSubIps                  int    `json:"leakSubIps" form:"leakSubIps"`                                   // Distinct subscription fetch IPs in a day that make a full signal, 0 to ignore
+	LeakConnIps                 int    `json:"leakConnIps" form:"leakConnIps"`                                 // Distinct connection IPs in a day that make a full signal, 0 to ignore
+	LeakAction                  string `json:"leakAction" form:"leakAction"`                                   // Response to a detected leak: none, rotate or limit
 	SubEncrypt                  bool   `json:"subEncrypt" form:"subEncrypt"`                                   // Encrypt subscription responses
 	SubShowInfo                 bool   `json:"subShowInfo" form:"subShowInfo"`                                 // Show client information in subscriptions
 	SubURI                      string `json:"subURI" form:"subURI"`                                           // Subscription server URI
@@ -168,5 +210,84 @@ func (s *AllSetting) CheckValid() error {
 		return common.NewError("time location not exist:", s.TimeLocation)
 	}
 
+	if s.ClientIpSource == "" {
+		s.ClientIpSource = "log"
+	}
+	if s.ClientIpSource != "log" && s.ClientIpSource != "stats" {
+		return common.NewError("client ip source is not valid:", s.ClientIpSource)
+	}
+
+	if s.IpLimitBackend == "" {
+		s.IpLimitBackend = "fail2ban"
+	}
+	switch s.IpLimitBackend {
+	case "fail2ban", "xray", "nftables", "iptables":
+	default:
+		return common.NewError("ip limit backend is not valid:", s.IpLimitBackend)
+	}
+	if s.IpBanDuration < 0 {
+		return common.NewError("ip ban duration is not valid:", s.IpBanDuration)
+	}
+	if s.DeviceEvictPolicy == "" {
+		s.DeviceEvictPolicy = "newest"
+	}
+	if s.DeviceEvictPolicy != "newest" && s.DeviceEvictPolicy != "oldest" {
+		return common.NewError("device evict policy is not valid:", s.DeviceEvictPolicy)
+	}
+	if s.DeviceGraceWindow < 0 {
+		return common.NewError("device grace window is not valid:", s.DeviceGraceWindow)
+	}
+	if s.ConnectionLogRetention < 0 {
+		return common.NewError("connection log retention is not valid:", s.ConnectionLogRetention)
+	}
+	if s.TorrentStrikeLimit < 0 {
+		return common.NewError("torrent strike limit is not valid:", s.TorrentStrikeLimit)
+	}
+	if s.ThrottleSpeedUp < 0 || s.ThrottleSpeedDown < 0 {
+		return common.NewError("throttle speed is not valid:", s.ThrottleSpeedUp, s.ThrottleSpeedDown)
+	}
+	if s.GracePeriod < 0 {
+		return common.NewError("grace period is not valid:", s.GracePeriod)
+	}
+	if s.GraceAction != "throttle" && s.GraceAction != "route" {
+		return common.NewError("grace action is not valid:", s.GraceAction)
+	}
+	if s.AutoDeleteDays < 0 {
+		return common.NewError("auto delete days is not valid:", s.AutoDeleteDays)
+	}
+	if s.ForecastRemindDays < 0 {
+		return common.NewError("forecast remind days is not valid:", s.ForecastRemindDays)
+	}
+	if s.TrialEnable && s.TrialInboundId <= 0 {
+		return common.NewError("trial inbound is not set")
+	}
+	if s.TrialPlanId < 0 || s.TrialHours <= 0 || s.TrialTrafficGB < 0 || s.TrialDailyCap < 0 {
+		return common.NewError("trial limits are not valid:", s.TrialHours, s.TrialTrafficGB, s.TrialDailyCap)
+	}
+	for _, proxy := range strings.Split(s.TrustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return common.NewError("trusted proxy is not valid:", proxy)
+		}
+	}
+	if s.InviteDailyCap < 0 || s.InviteClientUses < 0 || s.InviteTrafficGB < 0 || s.InviteDays < 0 || s.ReferralBonusGB < 0 || s.ReferralBonusDays < 0 {
+		return common.NewError("invite limits are not valid:", s.InviteDailyCap, s.InviteClientUses, s.InviteTrafficGB, s.InviteDays)
+	}
+	if s.BillingInvoiceDays < 0 {
+		return common.NewError("billing invoice days is not valid:", s.BillingInvoiceDays)
+	}
+	if s.CredentialOverlap < 0 {
+		return common.NewError("credential overlap is not valid:", s.CredentialOverlap)
+	}
+	if s.LeakSubIps < 0 || s.LeakConnIps < 0 {
+		return common.NewError("leak detection threshold is not valid:", s.LeakSubIps, s.LeakConnIps)
+	}
+	if s.LeakAction != "none" && s.LeakAction != "rotate" && s.LeakAction != "limit" {
+		return common.NewError("leak action is not valid:", s.LeakAction)
+	}
+
 	return nil
 }
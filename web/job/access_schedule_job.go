@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// AccessScheduleJob takes clients out of Xray outside their access schedule and adds them back within it.
+type AccessScheduleJob struct {
+	accessService service.AccessScheduleService
+}
+
+// NewAccessScheduleJob creates a new access schedule job instance.
+func NewAccessScheduleJob() *AccessScheduleJob {
+	return new(AccessScheduleJob)
+}
+
+// Run applies the access schedules of the clients to the running Xray.
+func (j *AccessScheduleJob) Run() {
+	added, removed, err := j.accessService.ApplyAccessSchedules()
+	if err != nil {
+		logger.Warning("Failed to apply access schedules:", err)
+		return
+	}
+	if added > 0 || removed > 0 {
+		logger.Infof("Access schedules applied: %d clients added, %d clients removed", added, removed)
+	}
+}
@@ -10,18 +10,27 @@ import (
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/agassiz/3x-ui/v2/database"
 	"github.com/agassiz/3x-ui/v2/database/model"
 	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
 	"github.com/agassiz/3x-ui/v2/xray"
 )
 
-// CheckClientIpJob monitors client IP addresses from access logs and manages IP blocking based on configured limits.
+// CheckClientIpJob monitors client IP addresses from access logs or Xray stats and manages IP blocking based on configured limits.
 type CheckClientIpJob struct {
-	lastClear     int64
-	disAllowedIps []string
+	lastClear            int64
+	logStart             int64 // Where the access log scan starts when the log is kept for the connection log
+	disAllowedIps        []string
+	inboundService       service.InboundService
+	ipBanService         service.IpBanService
+	deviceLimitService   service.DeviceLimitService
+	connectionLogService service.ConnectionLogService
+	tgbotService         service.Tgbot
 }
 
 var job *CheckClientIpJob
@@ -36,10 +45,22 @@ func (j *CheckClientIpJob) Run() {
 	if j.lastClear == 0 {
 		j.lastClear = time.Now().Unix()
 	}
+	// Bans of this run reach Xray together
+	defer j.ipBanService.FlushBans()
 
 	shouldClearAccessLog := false
 	iplimitActive := j.hasLimitIp()
-	f2bInstalled := j.checkFail2BanInstalled()
+	// Bans enforced by the panel itself do not need Fail2Ban
+	nativeBan := j.ipBanService.IsNativeEnforcement()
+	f2bInstalled := nativeBan || j.checkFail2BanInstalled()
+
+	if j.inboundService.IsStatsIpSource() {
+		if iplimitActive && runtime.GOOS != "windows" && !f2bInstalled {
+			logger.Warning("[LimitIP] Fail2Ban is not installed, Please install Fail2Ban from the x-ui bash menu.")
+		}
+		j.processOnlineStats()
+		return
+	}
 	isAccessLogAvailable := j.checkAccessLogAvailable(iplimitActive)
 
 	if isAccessLogAvailable {
@@ -66,6 +87,24 @@ func (j *CheckClientIpJob) Run() {
 }
 
 func (j *CheckClientIpJob) clearAccessLog() {
+	if j.connectionLogService.IsEnabled() {
+		// The connection log reads the access log from its own offset, so it is only truncated once
+		// every line was ingested. Until then the scan starts at the end of the log.
+		truncated, err := j.connectionLogService.RotateAccessLog()
+		j.checkError(err)
+		j.logStart = 0
+		if !truncated {
+			accessLogPath, err := xray.GetAccessLogPath()
+			j.checkError(err)
+			if info, err := os.Stat(accessLogPath); err == nil {
+				j.logStart = info.Size()
+			}
+		}
+		j.lastClear = time.Now().Unix()
+		return
+	}
+	j.logStart = 0
+
 	logAccessP, err := os.OpenFile(xray.GetAccessPersistentLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	j.checkError(err)
 	defer logAccessP.Close()
@@ -106,7 +145,7 @@ func (j *CheckClientIpJob) hasLimitIp() bool {
 
 		for _, client := range clients {
 			limitIp := client.LimitIP
-			if limitIp > 0 {
+			if limitIp > 0 || client.LimitDevice > 0 {
 				return true
 			}
 		}
@@ -124,6 +163,15 @@ func (j *CheckClientIpJob) processLogFile() bool {
 	file, _ := os.Open(accessLogPath)
 	defer file.Close()
 
+	if j.logStart > 0 {
+		if info, err := file.Stat(); err != nil || info.Size() < j.logStart {
+			// The log was truncated or replaced, start over
+			j.logStart = 0
+		} else if _, err := file.Seek(j.logStart, io.SeekStart); err != nil {
+			j.logStart = 0
+		}
+	}
+
 	inboundClientIps := make(map[string]map[string]struct{}, 100)
 
 	scanner := bufio.NewScanner(file)
@@ -174,6 +222,23 @@ func (j *CheckClientIpJob) processLogFile() bool {
 	return shouldCleanLog
 }
 
+// processOnlineStats records the live client IPs reported by the Xray stats service and applies IP limits.
+func (j *CheckClientIpJob) processOnlineStats() {
+	clientIps, err := j.inboundService.CollectOnlineClientIps()
+	if err != nil {
+		logger.Debug("collect online client ips failed:", err)
+		return
+	}
+	for email, ips := range clientIps {
+		clientIpsRecord, err := j.getInboundClientIps(email)
+		if err != nil {
+			j.addInboundClientIps(email, ips)
+			continue
+		}
+		j.updateInboundClientIps(clientIpsRecord, email, ips)
+	}
+}
+
 func (j *CheckClientIpJob) checkFail2BanInstalled() bool {
 	cmd := "fail2ban-client"
 	args := []string{"-h"}
@@ -279,7 +344,10 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 		if client.Email == clientEmail {
 			limitIp := client.LimitIP
 
-			if limitIp > 0 && inbound.Enable {
+			if client.LimitDevice > 0 && inbound.Enable {
+				shouldCleanLog = true
+				j.limitDevices(client, ips)
+			} else if limitIp > 0 && inbound.Enable {
 				shouldCleanLog = true
 
 				if limitIp < len(ips) {
@@ -287,6 +355,11 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 					for i := limitIp; i < len(ips); i++ {
 						log.Printf("[LIMIT_IP] Email = %s || SRC = %s", clientEmail, ips[i])
 					}
+					if j.ipBanService.IsNativeEnforcement() {
+						if _, err := j.ipBanService.BanIps(clientEmail, ips[limitIp:], "ipLimit"); err != nil {
+							logger.Warning("[LimitIP] Unable to ban ips:", err)
+						}
+					}
 				}
 			}
 		}
@@ -308,6 +381,42 @@ func (j *CheckClientIpJob) updateInboundClientIps(inboundClientIps *model.Inboun
 	return shouldCleanLog
 }
 
+// limitDevices tracks the device sessions of a client and drops the sessions over its device limit.
+func (j *CheckClientIpJob) limitDevices(client model.Client, ips []string) {
+	evicted, err := j.deviceLimitService.TrackSessions(client.Email, ips, client.LimitDevice)
+	if err != nil {
+		logger.Warning("[LimitDevice] Unable to track sessions of", client.Email, ":", err)
+		return
+	}
+	if len(evicted) == 0 {
+		return
+	}
+
+	j.disAllowedIps = append(j.disAllowedIps, evicted...)
+	for _, ip := range evicted {
+		log.Printf("[LIMIT_IP] Email = %s || SRC = %s", client.Email, ip)
+	}
+	if j.ipBanService.IsNativeEnforcement() {
+		if _, err := j.ipBanService.BanIps(client.Email, evicted, "deviceLimit"); err != nil {
+			logger.Warning("[LimitDevice] Unable to ban ips:", err)
+		}
+	}
+
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+	msg := j.tgbotService.I18nBot("tgbot.messages.deviceLimit",
+		"Email=="+client.Email,
+		"Limit=="+strconv.Itoa(client.LimitDevice),
+		"IPs=="+strings.Join(evicted, ", "))
+	j.tgbotService.SendMsgToTgbotAdmins(msg)
+	if client.TgID != 0 {
+		j.tgbotService.SendMsgToTgbot(client.TgID, j.tgbotService.I18nBot("tgbot.messages.deviceLimitClient",
+			"Email=="+client.Email,
+			"Limit=="+strconv.Itoa(client.LimitDevice)))
+	}
+}
+
 func (j *CheckClientIpJob) getInboundByEmail(clientEmail string) (*model.Inbound, error) {
 	db := database.GetDB()
 	inbound := &model.Inbound{}
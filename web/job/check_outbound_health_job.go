@@ -0,0 +1,51 @@
+package job
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// CheckOutboundHealthJob records observatory results of outbounds and alerts when one stays dead.
+type CheckOutboundHealthJob struct {
+	outboundService service.OutboundService
+	settingService  service.SettingService
+	tgbotService    service.Tgbot
+}
+
+// NewCheckOutboundHealthJob creates a new outbound health monitoring job instance.
+func NewCheckOutboundHealthJob() *CheckOutboundHealthJob {
+	return new(CheckOutboundHealthJob)
+}
+
+// Run polls the observatory and sends Telegram alerts for dead and recovered outbounds.
+func (j *CheckOutboundHealthJob) Run() {
+	minutes, err := j.settingService.GetTgOutboundDown()
+	if err != nil {
+		minutes = 0
+	}
+	dead, recovered, err := j.outboundService.CollectOutboundHealth(time.Duration(minutes) * time.Minute)
+	if err != nil {
+		logger.Debug("collect outbound health failed:", err)
+		return
+	}
+	if minutes <= 0 || !j.tgbotService.IsRunning() {
+		return
+	}
+
+	for _, health := range dead {
+		msg := j.tgbotService.I18nBot("tgbot.messages.outboundDown",
+			"Tag=="+health.Tag,
+			"Minutes=="+strconv.FormatInt((time.Now().Unix()-health.DeadSince)/60, 10),
+			"Error=="+health.LastError)
+		j.tgbotService.SendMsgToTgbotAdmins(msg)
+	}
+	for _, health := range recovered {
+		msg := j.tgbotService.I18nBot("tgbot.messages.outboundRecovered",
+			"Tag=="+health.Tag,
+			"Delay=="+strconv.FormatInt(health.Delay, 10))
+		j.tgbotService.SendMsgToTgbotAdmins(msg)
+	}
+}
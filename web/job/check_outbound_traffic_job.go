@@ -0,0 +1,65 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// CheckOutboundTrafficJob enforces outbound traffic quotas and monthly traffic resets.
+type CheckOutboundTrafficJob struct {
+	outboundService service.OutboundService
+	xrayService     service.XrayService
+	tgbotService    service.Tgbot
+}
+
+// NewCheckOutboundTrafficJob creates a new outbound quota checking job instance.
+func NewCheckOutboundTrafficJob() *CheckOutboundTrafficJob {
+	return new(CheckOutboundTrafficJob)
+}
+
+// Run resets outbounds whose reset day has come, applies quota actions and purges old history.
+func (j *CheckOutboundTrafficJob) Run() {
+	needRestart := false
+
+	tags, restart, err := j.outboundService.ResetDueOutboundTraffics()
+	if err != nil {
+		logger.Warning("reset outbound traffics failed:", err)
+	}
+	for _, tag := range tags {
+		logger.Infof("Outbound %s traffic has been reset", tag)
+	}
+	needRestart = needRestart || restart
+
+	exceeded, restart, err := j.outboundService.CheckOutboundQuotas()
+	if err != nil {
+		logger.Warning("check outbound quotas failed:", err)
+	}
+	needRestart = needRestart || restart
+
+	if err := j.outboundService.DelOldOutboundTrafficHistory(); err != nil {
+		logger.Warning("clear outbound traffic history failed:", err)
+	}
+
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+
+	if len(exceeded) == 0 || !j.tgbotService.IsRunning() {
+		return
+	}
+	for _, outbound := range exceeded {
+		var msg string
+		if outbound.QuotaAction == "reroute" {
+			msg = j.tgbotService.I18nBot("tgbot.messages.outboundQuotaReroute",
+				"Tag=="+outbound.Tag,
+				"Quota=="+common.FormatTraffic(outbound.Quota),
+				"Fallback=="+outbound.FallbackTag)
+		} else {
+			msg = j.tgbotService.I18nBot("tgbot.messages.outboundQuota",
+				"Tag=="+outbound.Tag,
+				"Quota=="+common.FormatTraffic(outbound.Quota))
+		}
+		j.tgbotService.SendMsgToTgbotAdmins(msg)
+	}
+}
@@ -0,0 +1,23 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// ClearTrafficHistoryJob removes client traffic history past its retention period.
+type ClearTrafficHistoryJob struct {
+	inboundService service.InboundService
+}
+
+// NewClearTrafficHistoryJob creates a new traffic history cleanup job instance.
+func NewClearTrafficHistoryJob() *ClearTrafficHistoryJob {
+	return new(ClearTrafficHistoryJob)
+}
+
+// Run deletes expired 5 minute, hourly and daily client traffic buckets.
+func (j *ClearTrafficHistoryJob) Run() {
+	if err := j.inboundService.DelOldClientTrafficHistory(); err != nil {
+		logger.Warning("clear client traffic history failed:", err)
+	}
+}
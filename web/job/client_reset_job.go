@@ -0,0 +1,31 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// ClientResetJob resets the traffic of clients on their own reset schedules.
+type ClientResetJob struct {
+	inboundService service.InboundService
+	xrayService    service.XrayService
+}
+
+// NewClientResetJob creates a new client reset schedule job instance.
+func NewClientResetJob() *ClientResetJob {
+	return new(ClientResetJob)
+}
+
+// Run resets the clients whose reset is due.
+func (j *ClientResetJob) Run() {
+	needRestart, count, err := j.inboundService.ResetScheduledClients()
+	if err != nil {
+		logger.Warning("Failed to reset scheduled clients:", err)
+	}
+	if count > 0 {
+		logger.Infof("Scheduled traffic reset completed: %d clients reset", count)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
@@ -0,0 +1,78 @@
+package job
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// ClientStageJob moves clients through the enforcement stages of their expiry and traffic limits
+// and notifies the admins and the clients of each new stage.
+type ClientStageJob struct {
+	lastRun        int64
+	inboundService service.InboundService
+	settingService service.SettingService
+	xrayService    service.XrayService
+	tgbotService   service.Tgbot
+}
+
+// NewClientStageJob creates a new client stage job instance.
+func NewClientStageJob() *ClientStageJob {
+	return new(ClientStageJob)
+}
+
+// Run updates the stages of the clients and sends the notifications.
+func (j *ClientStageJob) Run() {
+	now := time.Now().UnixMilli()
+	if j.lastRun == 0 {
+		// Clients disabled before the panel started were reported by the previous run
+		j.lastRun = now
+	}
+	changes, needRestart, err := j.inboundService.UpdateClientStages(j.lastRun)
+	j.lastRun = now
+	if err != nil {
+		logger.Warning("Failed to update client stages:", err)
+		return
+	}
+	for _, change := range changes {
+		logger.Infof("[Stage] %s entered stage %s", change.Email, change.Stage)
+		j.notify(change)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
+
+func (j *ClientStageJob) notify(change *service.ClientStageChange) {
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+	params := []string{"Email==" + change.Email}
+	var adminKey, clientKey string
+	switch change.Stage {
+	case service.ClientStageWarning:
+		adminKey, clientKey = "tgbot.messages.stageWarning", "tgbot.messages.stageWarningClient"
+	case service.ClientStageGrace:
+		adminKey, clientKey = "tgbot.messages.stageGrace", "tgbot.messages.stageGraceClient"
+		params = append(params, "Time=="+time.UnixMilli(change.Until).Format("2006-01-02 15:04:05"))
+	case service.ClientStageDisabled:
+		adminKey, clientKey = "tgbot.messages.stageDisabled", "tgbot.messages.stageDisabledClient"
+	case service.ClientStageDeleted:
+		days, _ := j.settingService.GetAutoDeleteDays()
+		params = append(params, "Days=="+strconv.Itoa(days))
+		// The client is gone, only the admins are told
+		j.tgbotService.SendMsgToTgbotAdmins(j.tgbotService.I18nBot("tgbot.messages.stageDeleted", params...))
+		return
+	default:
+		return
+	}
+	j.tgbotService.SendMsgToTgbotAdmins(j.tgbotService.I18nBot(adminKey, params...))
+
+	_, client, err := j.inboundService.GetClientByEmail(change.Email)
+	if err != nil || client == nil || client.TgID == 0 {
+		return
+	}
+	j.tgbotService.SendMsgToTgbot(client.TgID, j.tgbotService.I18nBot(clientKey, params...))
+}
@@ -0,0 +1,35 @@
+package job
+
+import (
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// ConnectionLogJob tails the Xray access log into the connection log and applies its retention.
+type ConnectionLogJob struct {
+	lastCleanup          time.Time
+	connectionLogService service.ConnectionLogService
+}
+
+// NewConnectionLogJob creates a new connection log ingestion job instance.
+func NewConnectionLogJob() *ConnectionLogJob {
+	return new(ConnectionLogJob)
+}
+
+// Run ingests the new access log lines, and drops entries past the retention period once an hour.
+func (j *ConnectionLogJob) Run() {
+	if !j.connectionLogService.IsEnabled() {
+		return
+	}
+	if _, err := j.connectionLogService.IngestAccessLog(); err != nil {
+		logger.Warning("ingest access log failed:", err)
+	}
+	if time.Since(j.lastCleanup) >= time.Hour {
+		if err := j.connectionLogService.DelOldConnectionLogs(); err != nil {
+			logger.Warning("clear connection log failed:", err)
+		}
+		j.lastCleanup = time.Now()
+	}
+}
@@ -0,0 +1,32 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// CredentialRotationJob rotates the credentials of clients with a rotation policy and retires
+// the previous credentials once their overlap ends.
+type CredentialRotationJob struct {
+	rotationService service.CredentialRotationService
+	xrayService     service.XrayService
+}
+
+// NewCredentialRotationJob creates a new credential rotation job instance.
+func NewCredentialRotationJob() *CredentialRotationJob {
+	return new(CredentialRotationJob)
+}
+
+// Run rotates the credentials that are due.
+func (j *CredentialRotationJob) Run() {
+	count, needRestart, err := j.rotationService.RotateDueCredentials()
+	if err != nil {
+		logger.Warning("Failed to rotate client credentials:", err)
+	}
+	if count > 0 {
+		logger.Infof("Credentials of %d clients rotated", count)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
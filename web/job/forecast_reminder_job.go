@@ -0,0 +1,59 @@
+package job
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// ForecastReminderJob reminds clients bound to Telegram that their quota is about to run out
+// at their recent usage.
+type ForecastReminderJob struct {
+	inboundService service.InboundService
+	settingService service.SettingService
+	tgbotService   service.Tgbot
+}
+
+// NewForecastReminderJob creates a new forecast reminder job instance.
+func NewForecastReminderJob() *ForecastReminderJob {
+	return new(ForecastReminderJob)
+}
+
+// Run sends the depletion reminders that are due.
+func (j *ForecastReminderJob) Run() {
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+	traffics, err := j.inboundService.GetForecastReminders()
+	if err != nil {
+		logger.Warning("Failed to forecast client usage:", err)
+		return
+	}
+	loc, err := j.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	for _, traffic := range traffics {
+		_, client, err := j.inboundService.GetClientByEmail(traffic.Email)
+		if err != nil || client == nil || client.TgID == 0 {
+			continue
+		}
+		days := int(math.Ceil(float64(traffic.DepletionTime-time.Now().UnixMilli()) / 86400000))
+		err = j.tgbotService.TrySendMsgToTgbot(client.TgID, j.tgbotService.I18nBot("tgbot.messages.forecastReminder",
+			"Email=="+traffic.Email,
+			"UpDown=="+common.FormatTraffic(traffic.DailyAverage),
+			"Days=="+strconv.Itoa(max(days, 1)),
+			"Time=="+time.UnixMilli(traffic.DepletionTime).In(loc).Format("2006-01-02 15:04")))
+		if err != nil {
+			// Reminded again on the next run
+			continue
+		}
+		if err := j.inboundService.MarkForecastReminded(traffic.Email); err != nil {
+			logger.Warning("Unable to record the forecast reminder of", traffic.Email, ":", err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// InvoiceJob invoices the clients on a priced plan ahead of their expiry.
+type InvoiceJob struct {
+	billingService service.BillingService
+}
+
+// NewInvoiceJob creates a new invoice job instance.
+func NewInvoiceJob() *InvoiceJob {
+	return new(InvoiceJob)
+}
+
+// Run issues the invoices that are due.
+func (j *InvoiceJob) Run() {
+	count, err := j.billingService.IssueDueInvoices()
+	if err != nil {
+		logger.Warning("Failed to issue invoices:", err)
+	}
+	if count > 0 {
+		logger.Infof("Issued %d invoices", count)
+	}
+}
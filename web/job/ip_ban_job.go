@@ -0,0 +1,35 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// IpBanJob lifts expired IP bans and restores active ones in the firewall after a restart.
+type IpBanJob struct {
+	restored     bool
+	ipBanService service.IpBanService
+}
+
+// NewIpBanJob creates a new IP ban maintenance job instance.
+func NewIpBanJob() *IpBanJob {
+	return new(IpBanJob)
+}
+
+// Run restores the active bans on its first run, then lifts the bans whose duration has elapsed.
+func (j *IpBanJob) Run() {
+	if !j.restored {
+		if err := j.ipBanService.RestoreBans(); err != nil {
+			logger.Warning("restore ip bans failed:", err)
+		}
+		j.restored = true
+	}
+	count, err := j.ipBanService.UnbanExpired()
+	if err != nil {
+		logger.Warning("unban expired ips failed:", err)
+		return
+	}
+	if count > 0 {
+		logger.Infof("[LimitIP] Lifted %d expired ip bans", count)
+	}
+}
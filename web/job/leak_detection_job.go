@@ -0,0 +1,56 @@
+package job
+
+import (
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// LeakDetectionJob reports the clients likely sharing or reselling their subscription and
+// applies the configured response to them.
+type LeakDetectionJob struct {
+	leakService  service.LeakDetectionService
+	xrayService  service.XrayService
+	tgbotService service.Tgbot
+}
+
+// NewLeakDetectionJob creates a new leak detection job instance.
+func NewLeakDetectionJob() *LeakDetectionJob {
+	return new(LeakDetectionJob)
+}
+
+// Run scores the clients and alerts the admins of the new leaks.
+func (j *LeakDetectionJob) Run() {
+	reports, needRestart, err := j.leakService.DetectLeaks()
+	if err != nil {
+		logger.Warning("Failed to detect subscription leaks:", err)
+	}
+	for _, report := range reports {
+		logger.Infof("[Leak] %s scored %d, response: %s", report.Email, report.Score, report.Action)
+		j.notify(report)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
+
+func (j *LeakDetectionJob) notify(report *model.LeakReport) {
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+	msg := j.tgbotService.I18nBot("tgbot.messages.leakDetected",
+		"Email=="+report.Email,
+		"Score=="+strconv.Itoa(report.Score),
+		"SubIps=="+strconv.Itoa(report.SubIps),
+		"ConnIps=="+strconv.Itoa(report.ConnIps),
+		"Ratio=="+strconv.FormatFloat(report.TrafficRatio, 'f', 1, 64))
+	switch report.Action {
+	case "rotate":
+		msg += "\r\n" + j.tgbotService.I18nBot("tgbot.messages.leakRotated", "SubId=="+report.SubId)
+	case "limit":
+		msg += "\r\n" + j.tgbotService.I18nBot("tgbot.messages.leakLimited")
+	}
+	j.tgbotService.SendMsgToTgbotAdmins(msg)
+}
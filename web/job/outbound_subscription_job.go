@@ -0,0 +1,29 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// OutboundSubscriptionJob refreshes remote outbound subscriptions whose interval has elapsed.
+type OutboundSubscriptionJob struct {
+	outboundService service.OutboundService
+	xrayService     service.XrayService
+}
+
+// NewOutboundSubscriptionJob creates a new outbound subscription refresh job instance.
+func NewOutboundSubscriptionJob() *OutboundSubscriptionJob {
+	return new(OutboundSubscriptionJob)
+}
+
+// Run refreshes due subscriptions and requests an Xray restart when outbounds changed.
+func (j *OutboundSubscriptionJob) Run() {
+	needRestart, err := j.outboundService.RefreshDueSubscriptions()
+	if err != nil {
+		logger.Warning("refresh outbound subscriptions failed:", err)
+		return
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
@@ -37,19 +37,12 @@ func (j *PeriodicTrafficResetJob) Run() {
 	resetCount := 0
 
 	for _, inbound := range inbounds {
-		resetInboundErr := j.inboundService.ResetAllTraffics()
-		if resetInboundErr != nil {
-			logger.Warning("Failed to reset traffic for inbound", inbound.Id, ":", resetInboundErr)
-		}
-
-		resetClientErr := j.inboundService.ResetAllClientTraffics(inbound.Id)
-		if resetClientErr != nil {
-			logger.Warning("Failed to reset traffic for all users of inbound", inbound.Id, ":", resetClientErr)
-		}
-
-		if resetInboundErr == nil && resetClientErr == nil {
-			resetCount++
+		err := j.inboundService.ResetInboundTraffic(inbound.Id)
+		if err != nil {
+			logger.Warning("Failed to reset traffic for inbound", inbound.Id, ":", err)
+			continue
 		}
+		resetCount++
 	}
 
 	if resetCount > 0 {
@@ -0,0 +1,35 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// SpeedLimitJob keeps the tc shaping of client speed caps in line with the online client IPs.
+type SpeedLimitJob struct {
+	lastError         string
+	speedLimitService service.SpeedLimitService
+}
+
+// NewSpeedLimitJob creates a new speed limit job instance.
+func NewSpeedLimitJob() *SpeedLimitJob {
+	return new(SpeedLimitJob)
+}
+
+// Run applies the speed caps, or removes the shaping once speed limits are turned off.
+func (j *SpeedLimitJob) Run() {
+	if !j.speedLimitService.IsEnabled() {
+		j.speedLimitService.ClearSpeedLimits()
+		return
+	}
+	err := j.speedLimitService.ApplySpeedLimits()
+	if err == nil {
+		j.lastError = ""
+		return
+	}
+	// The job runs every few seconds, only report a failure when it changes
+	if err.Error() != j.lastError {
+		logger.Warning("apply speed limits failed:", err)
+		j.lastError = err.Error()
+	}
+}
@@ -0,0 +1,83 @@
+package job
+
+import (
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// TorrentStrikeJob warns clients caught by the BitTorrent block preset and applies the strike policy.
+// Hits are read from the connection log, so the connection log has to be enabled.
+type TorrentStrikeJob struct {
+	started              bool
+	lastId               int64
+	connectionLogService service.ConnectionLogService
+	presetService        service.RoutingPresetService
+	inboundService       service.InboundService
+	xrayService          service.XrayService
+	tgbotService         service.Tgbot
+}
+
+// NewTorrentStrikeJob creates a new BitTorrent strike job instance.
+func NewTorrentStrikeJob() *TorrentStrikeJob {
+	return new(TorrentStrikeJob)
+}
+
+// Run counts the BitTorrent block hits logged since the last run and strikes the clients that hit it.
+func (j *TorrentStrikeJob) Run() {
+	if !j.connectionLogService.IsEnabled() {
+		return
+	}
+	hits, lastId, err := j.presetService.GetTorrentHits(j.lastId)
+	if err != nil {
+		logger.Warning("get torrent hits failed:", err)
+		return
+	}
+	j.lastId = lastId
+	if !j.started {
+		// Hits logged before the panel started were handled by the previous run
+		j.started = true
+		return
+	}
+
+	needRestart := false
+	for email, count := range hits {
+		strike, counted, suspended, err := j.presetService.AddTorrentStrike(email)
+		if err != nil {
+			logger.Warning("add torrent strike failed:", err)
+			continue
+		}
+		if !counted {
+			continue
+		}
+		logger.Infof("[Torrent] %s hit the BitTorrent block %d times, strike %d", email, count, strike.Strikes)
+		needRestart = needRestart || suspended
+		j.notify(email, strike.Strikes, suspended)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
+
+func (j *TorrentStrikeJob) notify(email string, strikes int, suspended bool) {
+	if !j.tgbotService.IsRunning() {
+		return
+	}
+	params := []string{"Email==" + email, "Strikes==" + strconv.Itoa(strikes)}
+	if suspended {
+		j.tgbotService.SendMsgToTgbotAdmins(j.tgbotService.I18nBot("tgbot.messages.torrentSuspended", params...))
+	} else {
+		j.tgbotService.SendMsgToTgbotAdmins(j.tgbotService.I18nBot("tgbot.messages.torrentStrike", params...))
+	}
+
+	_, client, err := j.inboundService.GetClientByEmail(email)
+	if err != nil || client == nil || client.TgID == 0 {
+		return
+	}
+	if suspended {
+		j.tgbotService.SendMsgToTgbot(client.TgID, j.tgbotService.I18nBot("tgbot.messages.torrentSuspendedClient", params...))
+	} else {
+		j.tgbotService.SendMsgToTgbot(client.TgID, j.tgbotService.I18nBot("tgbot.messages.torrentWarningClient", params...))
+	}
+}
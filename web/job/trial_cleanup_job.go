@@ -0,0 +1,28 @@
+package job
+
+import (
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/web/service"
+)
+
+// TrialCleanupJob deletes the trial clients that expired.
+type TrialCleanupJob struct {
+	trialService service.TrialService
+	xrayService  service.XrayService
+}
+
+// NewTrialCleanupJob creates a new trial cleanup job instance.
+func NewTrialCleanupJob() *TrialCleanupJob {
+	return new(TrialCleanupJob)
+}
+
+// Run deletes the expired trial clients.
+func (j *TrialCleanupJob) Run() {
+	needRestart, err := j.trialService.DelExpiredTrials()
+	if err != nil {
+		logger.Warning("Failed to delete expired trial clients:", err)
+	}
+	if needRestart {
+		j.xrayService.SetToNeedRestart()
+	}
+}
@@ -23,7 +23,8 @@ func NewXrayTrafficJob() *XrayTrafficJob {
 	return new(XrayTrafficJob)
 }
 
-// Run collects traffic statistics from Xray and updates the database, triggering restart if needed.
+// Run collects traffic statistics from Xray into memory and writes them to the database
+// when the flush interval has elapsed or a client or inbound reached its limit.
 func (j *XrayTrafficJob) Run() {
 	if !j.xrayService.IsXrayRunning() {
 		return
@@ -32,19 +33,42 @@ func (j *XrayTrafficJob) Run() {
 	if err != nil {
 		return
 	}
-	err, needRestart0 := j.inboundService.AddTraffic(traffics, clientTraffics)
-	if err != nil {
-		logger.Warning("add inbound traffic failed:", err)
-	}
-	err, needRestart1 := j.outboundService.AddTraffic(traffics, clientTraffics)
-	if err != nil {
-		logger.Warning("add outbound traffic failed:", err)
-	}
+	needFlush := j.inboundService.AccumulateTraffic(traffics, clientTraffics)
 	if ExternalTrafficInformEnable, err := j.settingService.GetExternalTrafficInformEnable(); ExternalTrafficInformEnable {
 		j.informTrafficToExternalAPI(traffics, clientTraffics)
 	} else if err != nil {
 		logger.Warning("get ExternalTrafficInformEnable failed:", err)
 	}
+	if needFlush {
+		j.Flush()
+	}
+}
+
+// Flush writes the traffic held in memory to the database and applies limits, triggering restart if needed.
+func (j *XrayTrafficJob) Flush() {
+	traffics, clientTraffics := j.inboundService.TakePendingTraffic()
+	inboundErr, needRestart0 := j.inboundService.AddTraffic(traffics, clientTraffics)
+	if inboundErr != nil {
+		logger.Warning("add inbound traffic failed:", inboundErr)
+	}
+	outboundErr, needRestart1 := j.outboundService.AddTraffic(traffics, clientTraffics)
+	if outboundErr != nil {
+		logger.Warning("add outbound traffic failed:", outboundErr)
+	}
+	if inboundErr != nil || outboundErr != nil {
+		// Keep the traffic that was not written for the next flush
+		var failed []*xray.Traffic
+		for _, traffic := range traffics {
+			if (traffic.IsInbound && inboundErr != nil) || (traffic.IsOutbound && outboundErr != nil) {
+				failed = append(failed, traffic)
+			}
+		}
+		var failedClients []*xray.ClientTraffic
+		if inboundErr != nil {
+			failedClients = clientTraffics
+		}
+		j.inboundService.RestorePendingTraffic(failed, failedClients)
+	}
 	if needRestart0 || needRestart1 {
 		j.xrayService.SetToNeedRestart()
 	}
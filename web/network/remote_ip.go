@@ -0,0 +1,68 @@
+package network
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RemoteIp returns the IP address of the peer that sent the request. Forwarding headers are
+// only followed when the peer is one of the trusted proxies, a comma separated list of IPs and CIDRs,
+// so that visitors cannot pick the address themselves.
+func RemoteIp(r *http.Request, trustedProxies string) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	var trusted []*net.IPNet
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			trusted = append(trusted, network)
+		}
+	}
+	isTrusted := func(ip string) bool {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return false
+		}
+		for _, network := range trusted {
+			if network.Contains(parsed) {
+				return true
+			}
+		}
+		return false
+	}
+	if !isTrusted(ip) {
+		return ip
+	}
+	// Walk the chain back from the nearest hop, the first untrusted hop is the visitor
+	if value := r.Header.Get("X-Forwarded-For"); value != "" {
+		hops := strings.Split(value, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !isTrusted(hop) {
+				return hop
+			}
+		}
+		return ip
+	}
+	if value := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(value) != nil {
+		return value
+	}
+	return ip
+}
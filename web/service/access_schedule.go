@@ -0,0 +1,295 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// accessScheduleState tracks the clients taken out of Xray because they are outside their access schedule.
+var accessScheduleState struct {
+	lock    sync.Mutex
+	blocked map[string]bool
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// accessWindow is a weekly window in which a client may connect.
+type accessWindow struct {
+	days  [7]bool // Indexed by time.Weekday
+	start int     // Minutes since midnight
+	end   int     // Minutes since midnight, below start for a window running past midnight
+}
+
+// contains reports whether t falls in the window. The part of a window past midnight
+// belongs to the day the window started on.
+func (w accessWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	return (w.days[day] && minute >= w.start) || (w.days[(day+6)%7] && minute < w.end)
+}
+
+// parseAccessSchedule parses windows separated by semicolons. A window is a list of weekdays and
+// day ranges followed by a time range, such as "Mon-Fri 09:00-18:00" or "Sat,Sun". Days default to
+// every day and the time range to the whole day; a range ending before it starts runs past midnight.
+func parseAccessSchedule(schedule string) ([]accessWindow, error) {
+	var windows []accessWindow
+	for _, part := range strings.Split(schedule, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		window := accessWindow{start: 0, end: 24 * 60}
+		hasDays := false
+		for _, field := range fields {
+			var err error
+			if strings.Contains(field, ":") {
+				window.start, window.end, err = parseTimeRange(field)
+			} else {
+				err = parseDays(field, &window.days)
+				hasDays = true
+			}
+			if err != nil {
+				return nil, common.NewErrorf("invalid access schedule %q: %v", schedule, err)
+			}
+		}
+		if !hasDays {
+			for day := range window.days {
+				window.days[day] = true
+			}
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// parseDays marks the weekdays of a comma separated list of days and day ranges such as "Mon-Fri,Sun".
+func parseDays(value string, days *[7]bool) error {
+	for _, item := range strings.Split(value, ",") {
+		if item == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(item, "-")
+		from, ok := weekdayNames[strings.ToLower(first)]
+		if !ok {
+			return fmt.Errorf("unknown day %s", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdayNames[strings.ToLower(last)]; !ok {
+				return fmt.Errorf("unknown day %s", last)
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimeRange parses a range such as "09:00-18:00" into minutes since midnight.
+func parseTimeRange(value string) (int, int, error) {
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("time range %s has no end", value)
+	}
+	start, err := parseClock(first)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(last)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("time range %s is empty", value)
+	}
+	return start, end, nil
+}
+
+// parseClock parses a time of day such as "09:30" into minutes since midnight, allowing "24:00".
+func parseClock(value string) (int, error) {
+	hour, minute, ok := strings.Cut(value, ":")
+	h, err1 := strconv.Atoi(hour)
+	m, err2 := strconv.Atoi(minute)
+	if !ok || err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %s", value)
+	}
+	return h*60 + m, nil
+}
+
+// isAccessAllowed reports whether a client with the given schedule may connect at t.
+// An empty or invalid schedule allows any time.
+func isAccessAllowed(schedule string, t time.Time) bool {
+	windows, err := parseAccessSchedule(schedule)
+	if err != nil || len(windows) == 0 {
+		return true
+	}
+	for _, window := range windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAccessSchedule returns the access schedule of a client, falling back to its plan's.
+func clientAccessSchedule(schedule string, planId int, plans map[int]*model.Plan) string {
+	if strings.TrimSpace(schedule) != "" {
+		return schedule
+	}
+	if plan := plans[planId]; plan != nil {
+		return plan.AccessSchedule
+	}
+	return ""
+}
+
+// markAccessBlocked records the clients left out of a generated Xray config. They are only ever
+// added, as the config may not be applied; the job adds back clients that are actually there.
+func markAccessBlocked(emails []string) {
+	accessScheduleState.lock.Lock()
+	defer accessScheduleState.lock.Unlock()
+	if accessScheduleState.blocked == nil {
+		accessScheduleState.blocked = map[string]bool{}
+	}
+	for _, email := range emails {
+		accessScheduleState.blocked[email] = true
+	}
+}
+
+// AccessScheduleService lets clients connect only within the weekly windows of their access schedule.
+// Clients are removed from and added back to the running Xray through its API, so their
+// settings stay untouched and Xray is not restarted.
+type AccessScheduleService struct {
+	inboundService InboundService
+	planService    PlanService
+	xrayApi        xray.XrayAPI
+}
+
+// ApplyAccessSchedules removes the clients outside their access schedule from Xray and adds back
+// the ones whose window opened. Returns how many clients were added and removed.
+func (s *AccessScheduleService) ApplyAccessSchedules() (int, int, error) {
+	if p == nil || !p.IsRunning() {
+		return 0, 0, nil
+	}
+	plans, err := s.planService.getPlanMap()
+	if err != nil {
+		return 0, 0, err
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return 0, 0, err
+	}
+	now := time.Now().In(s.inboundService.getTimeLocation())
+
+	accessScheduleState.lock.Lock()
+	defer accessScheduleState.lock.Unlock()
+	if accessScheduleState.blocked == nil {
+		accessScheduleState.blocked = map[string]bool{}
+	}
+	blocked := accessScheduleState.blocked
+
+	if err := s.xrayApi.Init(p.GetAPIPort()); err != nil {
+		return 0, 0, err
+	}
+	defer s.xrayApi.Close()
+
+	added, removed := 0, 0
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		enabled := make(map[string]bool, len(inbound.ClientStats))
+		for _, traffic := range inbound.ClientStats {
+			enabled[traffic.Email] = traffic.Enable
+		}
+		cipher := ""
+		if inbound.Protocol == model.Shadowsocks {
+			var settings map[string]any
+			if err := json.Unmarshal([]byte(inbound.Settings), &settings); err == nil {
+				cipher, _ = settings["method"].(string)
+			}
+		}
+		for _, client := range clients {
+			if client.Email == "" {
+				continue
+			}
+			if !client.Enable || !enabled[client.Email] {
+				// Not in Xray anyway, enabling it again goes through the usual paths
+				delete(blocked, client.Email)
+				continue
+			}
+			schedule := clientAccessSchedule(client.AccessSchedule, client.PlanId, plans)
+			if schedule == "" && !blocked[client.Email] {
+				continue
+			}
+			if !isAccessAllowed(schedule, now) {
+				// Removed on every run, as editing the client adds it back to Xray
+				err := s.xrayApi.RemoveUser(inbound.Tag, client.Email)
+				if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", client.Email)) {
+					logger.Debug("Error in removing client outside its access schedule:", err)
+					continue
+				}
+				// The previous credential of a rotation goes with it
+				s.xrayApi.RemoveUser(inbound.Tag, client.Email+rotatedEmailSuffix)
+				if !blocked[client.Email] {
+					blocked[client.Email] = true
+					removed++
+				}
+				continue
+			}
+			if blocked[client.Email] {
+				user := map[string]any{
+					"email":    client.Email,
+					"id":       client.ID,
+					"security": client.Security,
+					"flow":     client.Flow,
+					"password": client.Password,
+					"cipher":   cipher,
+				}
+				err := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, user)
+				if err != nil && !strings.Contains(err.Error(), "already exists") {
+					logger.Debug("Error in adding client back within its access schedule:", err)
+					continue
+				}
+				if client.OldCredential != "" && client.OldCredentialUntil > now.UnixMilli() {
+					user["email"] = client.Email + rotatedEmailSuffix
+					user[credentialField(inbound.Protocol)] = client.OldCredential
+					err := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, user)
+					if err != nil && !strings.Contains(err.Error(), "already exists") {
+						logger.Debug("Error in adding previous credential back within its access schedule:", err)
+					}
+				}
+				delete(blocked, client.Email)
+				added++
+			}
+		}
+	}
+	return added, removed, nil
+}
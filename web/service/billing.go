@@ -0,0 +1,577 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// Statuses of an invoice.
+const (
+	InvoiceUnpaid = "unpaid"
+	InvoicePaid   = "paid"
+	InvoiceVoid   = "void"
+)
+
+// ErrPaymentRecorded is returned when a payment reference was already recorded with another invoice.
+var ErrPaymentRecorded = common.NewError("payment reference already recorded")
+
+// RevenuePoint is the revenue of one day or month.
+type RevenuePoint struct {
+	Period string `json:"period"` // 2006-01-02 for days or 2006-01 for months, in the panel's time zone
+	Amount int64  `json:"amount"` // In cents of the billing currency
+	Count  int    `json:"count"`  // Invoices paid in the period
+}
+
+// OverdueClient is a client with unpaid invoices past their deadline.
+type OverdueClient struct {
+	Email    string `json:"email"`
+	Invoices int    `json:"invoices"`
+	Amount   int64  `json:"amount"`  // In cents of the billing currency
+	DueTime  int64  `json:"dueTime"` // Oldest missed deadline in milliseconds
+}
+
+// BillingService keeps the ledger of invoices and payments. Paying an invoice for a plan renews the
+// client with the plan: the expiry moves by its renewal days, the quota is set and the traffic reset.
+type BillingService struct {
+	inboundService InboundService
+	planService    PlanService
+	settingService SettingService
+	tgbotService   Tgbot
+}
+
+// GetInvoices returns the invoices of a client, or of every client when email is empty, latest first.
+func (s *BillingService) GetInvoices(email string) ([]*model.Invoice, error) {
+	db := database.GetDB()
+	query := db.Model(model.Invoice{})
+	if email != "" {
+		query = query.Where("email = ?", email)
+	}
+	invoices := make([]*model.Invoice, 0)
+	if err := query.Order("id desc").Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// GetInvoice returns the invoice with the given id.
+func (s *BillingService) GetInvoice(id int) (*model.Invoice, error) {
+	db := database.GetDB()
+	invoice := &model.Invoice{}
+	if err := db.Model(model.Invoice{}).First(invoice, id).Error; err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// AddInvoice stores a new unpaid invoice and sends it to the client through the bot.
+// The amount defaults to the plan price and the deadline to the client's expiry.
+func (s *BillingService) AddInvoice(invoice *model.Invoice) (*model.Invoice, error) {
+	if err := s.checkInvoice(invoice); err != nil {
+		return nil, err
+	}
+	invoice.Id = 0
+	invoice.Status = InvoiceUnpaid
+	invoice.PaidAt = 0
+	invoice.Method = ""
+	invoice.Reference = ""
+	invoice.CreatedAt = time.Now().UnixMilli()
+	db := database.GetDB()
+	if err := db.Create(invoice).Error; err != nil {
+		return nil, err
+	}
+	s.sendInvoice(invoice)
+	return invoice, nil
+}
+
+func (s *BillingService) checkInvoice(invoice *model.Invoice) error {
+	invoice.Email = strings.TrimSpace(invoice.Email)
+	traffic, _, err := s.inboundService.GetClientByEmail(invoice.Email)
+	if err != nil {
+		return err
+	}
+	if invoice.PlanId > 0 {
+		plan, err := s.planService.GetPlan(invoice.PlanId)
+		if err != nil {
+			return err
+		}
+		if invoice.Amount == 0 {
+			invoice.Amount = plan.Price
+		}
+	}
+	if invoice.Amount < 0 || invoice.DueTime < 0 {
+		return common.NewError("invoice amount is not valid:", invoice.Amount)
+	}
+	if invoice.DueTime == 0 && traffic.ExpiryTime > 0 {
+		invoice.DueTime = traffic.ExpiryTime
+	}
+	return nil
+}
+
+// PayInvoice records the payment of an unpaid invoice, renews the plan it is for and sends the
+// receipt to the client. Returns whether Xray needs a restart.
+func (s *BillingService) PayInvoice(id int, method string, reference string) (bool, error) {
+	invoice := &model.Invoice{Id: id}
+	needRestart, err := s.settleInvoice(invoice, method, reference)
+	if errors.Is(err, ErrPaymentRecorded) {
+		return needRestart, common.NewError("payment reference already recorded:", strings.TrimSpace(reference))
+	}
+	return needRestart, err
+}
+
+// RecordPayment records a payment posted by an external system. It pays the given invoice, or a new
+// one created from the payment when no id is set. A reference already recorded returns its invoice
+// untouched, so that the system can post the same payment again safely.
+// Returns the paid invoice and whether Xray needs a restart.
+func (s *BillingService) RecordPayment(payment *model.Invoice) (*model.Invoice, bool, error) {
+	method, reference := payment.Method, strings.TrimSpace(payment.Reference)
+	if recorded, err := s.getInvoiceByReference(reference); err != nil || recorded != nil {
+		return recorded, false, err
+	}
+	invoice := &model.Invoice{Id: payment.Id}
+	if payment.Id == 0 {
+		if err := s.checkInvoice(payment); err != nil {
+			return nil, false, err
+		}
+		invoice = payment
+	}
+	needRestart, err := s.settleInvoice(invoice, method, reference)
+	if errors.Is(err, ErrPaymentRecorded) {
+		// The same payment was posted concurrently
+		recorded, err := s.getInvoiceByReference(reference)
+		return recorded, needRestart, err
+	}
+	if err != nil {
+		return nil, needRestart, err
+	}
+	return invoice, needRestart, nil
+}
+
+// getInvoiceByReference returns the invoice paid with a payment reference, nil when there is none.
+func (s *BillingService) getInvoiceByReference(reference string) (*model.Invoice, error) {
+	if reference == "" {
+		return nil, nil
+	}
+	db := database.GetDB()
+	invoice := &model.Invoice{}
+	err := db.Model(model.Invoice{}).Where("reference = ?", reference).First(invoice).Error
+	if database.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// settleInvoice marks an invoice paid and renews the plan it is for in one transaction, so that a
+// payment is recorded once and always renews the client. An invoice without id is created paid,
+// otherwise it must still be unpaid. Fills in the invoice, sends the receipt and returns whether Xray
+// needs a restart, or ErrPaymentRecorded when the reference belongs to another payment.
+func (s *BillingService) settleInvoice(invoice *model.Invoice, method string, reference string) (bool, error) {
+	paid := map[string]any{
+		"status":    InvoicePaid,
+		"paid_at":   time.Now().UnixMilli(),
+		"method":    strings.TrimSpace(method),
+		"reference": strings.TrimSpace(reference),
+	}
+	var renewed *renewedUser
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if invoice.Id == 0 {
+			invoice.Status = paid["status"].(string)
+			invoice.PaidAt = paid["paid_at"].(int64)
+			invoice.Method = paid["method"].(string)
+			invoice.Reference = paid["reference"].(string)
+			invoice.CreatedAt = invoice.PaidAt
+			if err := tx.Create(invoice).Error; err != nil {
+				return err
+			}
+		} else {
+			result := tx.Model(model.Invoice{}).Where("id = ? AND status = ?", invoice.Id, InvoiceUnpaid).Updates(paid)
+			if result.Error != nil {
+				return result.Error
+			}
+			if err := tx.Model(model.Invoice{}).First(invoice, invoice.Id).Error; err != nil {
+				return err
+			}
+			if result.RowsAffected == 0 {
+				return common.NewErrorf("invoice %d is %s", invoice.Id, invoice.Status)
+			}
+		}
+		if invoice.PlanId == 0 {
+			return nil
+		}
+		var err error
+		renewed, err = s.renewClient(tx, invoice.Email, invoice.PlanId)
+		return err
+	})
+	if database.IsUniqueViolation(err) {
+		return false, ErrPaymentRecorded
+	}
+	if err != nil {
+		return false, err
+	}
+	// Xray only learns about the renewal once it is committed
+	needRestart := s.addRenewedUser(renewed)
+	if invoice.PlanId > 0 {
+		s.inboundService.DiscardPendingClientTraffic(invoice.Email)
+	}
+	s.sendReceipt(invoice)
+	return needRestart, nil
+}
+
+// VoidInvoice cancels an unpaid invoice.
+func (s *BillingService) VoidInvoice(id int) error {
+	invoice, err := s.GetInvoice(id)
+	if err != nil {
+		return err
+	}
+	if invoice.Status != InvoiceUnpaid {
+		return common.NewErrorf("invoice %d is %s", invoice.Id, invoice.Status)
+	}
+	db := database.GetDB()
+	return db.Model(invoice).Update("status", InvoiceVoid).Error
+}
+
+// renewedUser is a client that was disabled before its renewal and has to be added back to Xray.
+type renewedUser struct {
+	protocol string
+	tag      string
+	user     map[string]any
+}
+
+// renewClient applies a paid plan to a client: it is assigned to the plan and enabled, its expiry moves
+// by the renewal days from the later of now and its current expiry, its quota is set to the renewal
+// quota and its traffic starts over. Returns the user to add to Xray when the client was disabled.
+func (s *BillingService) renewClient(tx *gorm.DB, email string, planId int) (*renewedUser, error) {
+	plan := &model.Plan{}
+	if err := tx.Model(model.Plan{}).First(plan, planId).Error; err != nil {
+		return nil, err
+	}
+	traffic := &xray.ClientTraffic{}
+	if err := tx.Model(xray.ClientTraffic{}).Where("email = ?", email).First(traffic).Error; err != nil {
+		return nil, err
+	}
+	inbound := &model.Inbound{}
+	if err := tx.Model(model.Inbound{}).First(inbound, traffic.InboundId).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	expiry := traffic.ExpiryTime
+	if plan.RenewDays > 0 {
+		renewal := int64(plan.RenewDays) * 86400000
+		expiry = max(traffic.ExpiryTime, now) + renewal
+		if traffic.ExpiryTime < 0 {
+			// The expiry starts on first use and holds a negative duration
+			expiry = traffic.ExpiryTime - renewal
+		}
+	}
+	total := traffic.Total
+	if plan.RenewGB > 0 {
+		total = plan.RenewGB * 1024 * 1024 * 1024
+	}
+
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return nil, err
+	}
+	var renewed map[string]any
+	wasEnabled := traffic.Enable
+	clients, _ := settings["clients"].([]any)
+	for _, client := range clients {
+		if c, ok := client.(map[string]any); ok && c["email"] == email {
+			wasEnabled = wasEnabled && c["enable"] == true
+			c["planId"] = plan.Id
+			c["enable"] = true
+			c["expiryTime"] = expiry
+			c["totalGB"] = total
+			c["updated_at"] = now
+			renewed = c
+		}
+	}
+	if renewed == nil {
+		return nil, common.NewError("Client Not Found For Email:", email)
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", string(data)).Error; err != nil {
+		return nil, err
+	}
+	if err := s.inboundService.archiveClientUsage(tx, resetReasonPayment, "email = ?", email); err != nil {
+		return nil, err
+	}
+	err = tx.Model(xray.ClientTraffic{}).Where("email = ?", email).Updates(map[string]any{
+		"enable":      true,
+		"expiry_time": expiry,
+		"total":       total,
+		"up":          0,
+		"down":        0,
+		"raw_up":      0,
+		"raw_down":    0,
+		"stage":       "",
+		"stage_time":  0,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Client %s renewed with plan %s", email, plan.Name)
+	if wasEnabled {
+		return nil, nil
+	}
+	cipher := ""
+	if inbound.Protocol == model.Shadowsocks {
+		cipher, _ = settings["method"].(string)
+	}
+	user := map[string]any{"cipher": cipher}
+	for _, key := range []string{"email", "id", "security", "flow", "password"} {
+		value, _ := renewed[key].(string)
+		user[key] = value
+	}
+	return &renewedUser{protocol: string(inbound.Protocol), tag: inbound.Tag, user: user}, nil
+}
+
+// addRenewedUser adds a renewed client back to the running Xray. Returns whether Xray needs a restart.
+func (s *BillingService) addRenewedUser(renewed *renewedUser) bool {
+	if renewed == nil || p == nil {
+		return false
+	}
+	api := &s.inboundService.xrayApi
+	if err := api.Init(p.GetAPIPort()); err != nil {
+		return true
+	}
+	defer api.Close()
+	err := api.AddUser(renewed.protocol, renewed.tag, renewed.user)
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		logger.Debug("Error in enabling renewed client by api:", err)
+		return true
+	}
+	return false
+}
+
+// GetRevenue returns the revenue of the paid invoices between two times in milliseconds, per day or
+// per month in the panel's time zone, oldest first. Without times it covers the last 30 days or year.
+func (s *BillingService) GetRevenue(period string, from int64, to int64) ([]*RevenuePoint, error) {
+	layout, span := "2006-01", int64(365)
+	switch period {
+	case "day":
+		layout, span = "2006-01-02", 30
+	case "month":
+	default:
+		return nil, common.NewError("invalid revenue period:", period)
+	}
+	if to <= 0 {
+		to = time.Now().UnixMilli()
+	}
+	if from <= 0 {
+		from = to - span*86400000
+	}
+	db := database.GetDB()
+	var invoices []*model.Invoice
+	err := db.Model(model.Invoice{}).
+		Where("status = ? AND paid_at >= ? AND paid_at < ?", InvoicePaid, from, to).
+		Order("paid_at asc").Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+	loc := s.inboundService.getTimeLocation()
+	points := make([]*RevenuePoint, 0)
+	for _, invoice := range invoices {
+		key := time.UnixMilli(invoice.PaidAt).In(loc).Format(layout)
+		if len(points) == 0 || points[len(points)-1].Period != key {
+			points = append(points, &RevenuePoint{Period: key})
+		}
+		point := points[len(points)-1]
+		point.Amount += invoice.Amount
+		point.Count++
+	}
+	return points, nil
+}
+
+// GetOverdueClients returns the clients with unpaid invoices past their deadline, most owed first.
+func (s *BillingService) GetOverdueClients() ([]*OverdueClient, error) {
+	db := database.GetDB()
+	var invoices []*model.Invoice
+	err := db.Model(model.Invoice{}).
+		Where("status = ? AND due_time > 0 AND due_time < ?", InvoiceUnpaid, time.Now().UnixMilli()).
+		Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+	overdue := map[string]*OverdueClient{}
+	for _, invoice := range invoices {
+		client, ok := overdue[invoice.Email]
+		if !ok {
+			client = &OverdueClient{Email: invoice.Email, DueTime: invoice.DueTime}
+			overdue[invoice.Email] = client
+		}
+		client.Invoices++
+		client.Amount += invoice.Amount
+		client.DueTime = min(client.DueTime, invoice.DueTime)
+	}
+	result := make([]*OverdueClient, 0, len(overdue))
+	for _, client := range overdue {
+		result = append(result, client)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Amount != result[j].Amount {
+			return result[i].Amount > result[j].Amount
+		}
+		return result[i].Email < result[j].Email
+	})
+	return result, nil
+}
+
+// IssueDueInvoices creates an invoice for the clients on a priced plan that expire within the invoice
+// days, or expired as recently, and were not invoiced for their current period yet. Returns the invoices created.
+func (s *BillingService) IssueDueInvoices() (int, error) {
+	days, err := s.settingService.GetBillingInvoiceDays()
+	if err != nil || days <= 0 {
+		return 0, err
+	}
+	plans, err := s.planService.getPlanMap()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UnixMilli()
+	window := int64(days) * 86400000
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).Where("expiry_time > ? AND expiry_time <= ?", now-window, now+window).Find(&traffics).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, traffic := range traffics {
+		_, client, err := s.inboundService.GetClientByEmail(traffic.Email)
+		if err != nil || client == nil {
+			continue
+		}
+		plan := plans[client.PlanId]
+		if plan == nil || plan.Price <= 0 || plan.RenewDays <= 0 {
+			continue
+		}
+		var invoiced int64
+		err = db.Model(model.Invoice{}).
+			Where("email = ? AND status != ? AND created_at >= ?", traffic.Email, InvoiceVoid, traffic.ExpiryTime-window).
+			Count(&invoiced).Error
+		if err != nil {
+			return count, err
+		}
+		if invoiced > 0 {
+			continue
+		}
+		if _, err := s.AddInvoice(&model.Invoice{Email: traffic.Email, PlanId: plan.Id}); err != nil {
+			logger.Warning("Unable to invoice", traffic.Email, ":", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// SendInvoice sends an invoice to its client through the bot, or its receipt once paid.
+func (s *BillingService) SendInvoice(id int) error {
+	invoice, err := s.GetInvoice(id)
+	if err != nil {
+		return err
+	}
+	if !s.tgbotService.IsRunning() {
+		return common.NewError("Telegram bot is not running")
+	}
+	_, client, err := s.inboundService.GetClientByEmail(invoice.Email)
+	if err != nil {
+		return err
+	}
+	if client.TgID == 0 {
+		return common.NewError("client has no Telegram user:", invoice.Email)
+	}
+	switch invoice.Status {
+	case InvoiceUnpaid:
+		s.sendInvoice(invoice)
+	case InvoicePaid:
+		s.sendReceipt(invoice)
+	default:
+		return common.NewErrorf("invoice %d is %s", invoice.Id, invoice.Status)
+	}
+	return nil
+}
+
+// formatAmount formats an amount in cents in the billing currency.
+func (s *BillingService) formatAmount(amount int64) string {
+	currency, _ := s.settingService.GetBillingCurrency()
+	return strings.TrimSpace(fmt.Sprintf("%d.%02d %s", amount/100, amount%100, currency))
+}
+
+// getClientTgId returns the Telegram user of a client, 0 when it has none or the bot is not running.
+func (s *BillingService) getClientTgId(email string) int64 {
+	if !s.tgbotService.IsRunning() {
+		return 0
+	}
+	_, client, err := s.inboundService.GetClientByEmail(email)
+	if err != nil || client == nil {
+		return 0
+	}
+	return client.TgID
+}
+
+// sendInvoice sends an unpaid invoice to the Telegram user of its client.
+func (s *BillingService) sendInvoice(invoice *model.Invoice) {
+	tgId := s.getClientTgId(invoice.Email)
+	if tgId == 0 {
+		return
+	}
+	t := &s.tgbotService
+	loc := s.inboundService.getTimeLocation()
+	msg := t.I18nBot("tgbot.messages.invoice",
+		"Id=="+strconv.Itoa(invoice.Id),
+		"Email=="+invoice.Email,
+		"Amount=="+s.formatAmount(invoice.Amount))
+	if invoice.PlanId > 0 {
+		if plan, err := s.planService.GetPlan(invoice.PlanId); err == nil {
+			msg += t.I18nBot("tgbot.messages.invoicePlan", "Plan=="+plan.Name)
+		}
+	}
+	if invoice.DueTime > 0 {
+		msg += t.I18nBot("tgbot.messages.invoiceDue", "Time=="+time.UnixMilli(invoice.DueTime).In(loc).Format("2006-01-02 15:04"))
+	}
+	if invoice.Note != "" {
+		msg += t.I18nBot("tgbot.messages.invoiceNote", "Note=="+invoice.Note)
+	}
+	t.SendMsgToTgbot(tgId, msg)
+}
+
+// sendReceipt sends the receipt of a paid invoice to the Telegram user of its client.
+func (s *BillingService) sendReceipt(invoice *model.Invoice) {
+	tgId := s.getClientTgId(invoice.Email)
+	if tgId == 0 {
+		return
+	}
+	t := &s.tgbotService
+	loc := s.inboundService.getTimeLocation()
+	msg := t.I18nBot("tgbot.messages.receipt",
+		"Id=="+strconv.Itoa(invoice.Id),
+		"Email=="+invoice.Email,
+		"Amount=="+s.formatAmount(invoice.Amount),
+		"Time=="+time.UnixMilli(invoice.PaidAt).In(loc).Format("2006-01-02 15:04"))
+	if traffic, err := s.inboundService.GetClientTrafficByEmail(invoice.Email); err == nil && traffic != nil && traffic.ExpiryTime > 0 {
+		msg += t.I18nBot("tgbot.messages.receiptExpiry", "Time=="+time.UnixMilli(traffic.ExpiryTime).In(loc).Format("2006-01-02 15:04"))
+	}
+	t.SendMsgToTgbot(tgId, msg)
+}
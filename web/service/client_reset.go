@@ -0,0 +1,191 @@
+package service
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// anniversarySchedule resets a client every month on the day and time of its billing date.
+const anniversarySchedule = "anniversary"
+
+// checkClientResetSchedule validates the reset schedule of a client.
+func checkClientResetSchedule(client *model.Client) error {
+	schedule := strings.TrimSpace(client.ResetSchedule)
+	if schedule == "" || schedule == anniversarySchedule {
+		return nil
+	}
+	if _, err := cron.ParseStandard(schedule); err != nil {
+		return common.NewErrorf("invalid reset schedule of %s: %v", client.Email, err)
+	}
+	return nil
+}
+
+// nextClientReset returns the first reset of a client's schedule after the given time.
+func nextClientReset(client *model.Client, after time.Time, loc *time.Location) (time.Time, error) {
+	schedule := strings.TrimSpace(client.ResetSchedule)
+	if schedule != anniversarySchedule {
+		parsed, err := cron.ParseStandard(schedule)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return parsed.Next(after.In(loc)), nil
+	}
+
+	anchorMs := client.ResetAnchor
+	if anchorMs == 0 {
+		anchorMs = client.CreatedAt
+	}
+	if anchorMs == 0 {
+		return time.Time{}, common.NewError("no billing date for", client.Email)
+	}
+	anchor := time.UnixMilli(anchorMs).In(loc)
+	after = after.In(loc)
+	months := (after.Year()-anchor.Year())*12 + int(after.Month()-anchor.Month())
+	if months < 1 {
+		months = 1
+	}
+	for {
+		next := addMonthsClamped(anchor, months)
+		if next.After(after) {
+			return next, nil
+		}
+		months++
+	}
+}
+
+// addMonthsClamped adds months to t, using the last day of the month when t's day does not exist in it.
+func addMonthsClamped(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	return firstOfMonth.AddDate(0, 0, min(t.Day(), lastDay)-1)
+}
+
+// ResetScheduledClients resets the traffic of clients whose reset schedule is due, recording a
+// usage snapshot before each reset. It returns whether Xray needs a restart and how many clients were reset.
+func (s *InboundService) ResetScheduledClients() (bool, int, error) {
+	db := database.GetDB()
+	var inbounds []*model.Inbound
+	err := db.Model(model.Inbound{}).Where("settings LIKE ?", "%resetSchedule%").Find(&inbounds).Error
+	if err != nil {
+		return false, 0, err
+	}
+
+	loc := s.getTimeLocation()
+	now := time.Now()
+	needRestart := false
+	count := 0
+	for _, inbound := range inbounds {
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for i := range clients {
+			client := &clients[i]
+			if client.ResetSchedule == "" || client.Email == "" {
+				continue
+			}
+			traffic, err := s.GetClientTrafficByEmail(client.Email)
+			if err != nil || traffic == nil {
+				continue
+			}
+			if traffic.LastReset == 0 {
+				// The first cycle starts when the schedule is first seen
+				err = db.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).Update("last_reset", now.UnixMilli()).Error
+				if err != nil {
+					return needRestart, count, err
+				}
+				continue
+			}
+			due, err := nextClientReset(client, time.UnixMilli(traffic.LastReset), loc)
+			if err != nil {
+				logger.Warning("Unable to schedule traffic reset of", client.Email, ":", err)
+				continue
+			}
+			if due.After(now) {
+				continue
+			}
+			// A panel down for several cycles resets only once
+			for {
+				next, err := nextClientReset(client, due, loc)
+				if err != nil || next.After(now) {
+					break
+				}
+				due = next
+			}
+
+			restart, err := s.resetClientCycle(inbound.Id, client, traffic, due.UnixMilli(), resetReasonSchedule)
+			if err != nil {
+				return needRestart, count, err
+			}
+			needRestart = needRestart || restart
+			count++
+		}
+	}
+	return needRestart, count, nil
+}
+
+// resetClientCycle archives the usage of a client, resets its traffic and starts a new cycle at cycleStart
+// with its full quota.
+func (s *InboundService) resetClientCycle(inboundId int, client *model.Client, traffic *xray.ClientTraffic, cycleStart int64, reason string) (bool, error) {
+	db := database.GetDB()
+	err := s.archiveClientUsage(db, reason, "email = ?", client.Email)
+	if err != nil {
+		return false, err
+	}
+
+	needRestart := false
+	nowMs := time.Now().UnixMilli()
+	if traffic.ExpiryTime > 0 && traffic.ExpiryTime <= nowMs {
+		// An expired client stays disabled, only its usage starts over
+		err = db.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).
+			Updates(map[string]any{"up": 0, "down": 0, "raw_up": 0, "raw_down": 0}).Error
+		s.DiscardPendingClientTraffic(client.Email)
+	} else {
+		needRestart, err = s.resetClientTraffic(inboundId, client.Email)
+	}
+	if err != nil {
+		return needRestart, err
+	}
+	err = db.Model(xray.ClientTraffic{}).Where("email = ?", client.Email).
+		Updates(map[string]any{"total": client.TotalGB, "last_reset": cycleStart}).Error
+	return needRestart, err
+}
+
+// getCycleTotal returns the quota to store for a client being edited. A scheduled client keeps the
+// quota of its current cycle unless its quota or plan changes, in which case the quota is prorated:
+// the old quota counts for the elapsed part of the cycle and the new one for the remaining part.
+func (s *InboundService) getCycleTotal(tx *gorm.DB, email string, oldClient *model.Client, client *model.Client) int64 {
+	if client.ResetSchedule == "" {
+		return client.TotalGB
+	}
+	traffic := &xray.ClientTraffic{}
+	err := tx.Model(xray.ClientTraffic{}).Where("email = ?", email).First(traffic).Error
+	if err != nil || traffic.LastReset == 0 {
+		return client.TotalGB
+	}
+	if oldClient.TotalGB == client.TotalGB && oldClient.PlanId == client.PlanId {
+		return traffic.Total
+	}
+	if traffic.Total == 0 || client.TotalGB == 0 {
+		// An unlimited quota cannot be prorated
+		return client.TotalGB
+	}
+	start := time.UnixMilli(traffic.LastReset)
+	end, err := nextClientReset(client, start, s.getTimeLocation())
+	if err != nil || !end.After(start) {
+		return client.TotalGB
+	}
+	remaining := float64(time.Until(end)) / float64(end.Sub(start))
+	remaining = math.Max(0, math.Min(1, remaining))
+	return int64(math.Round(float64(traffic.Total)*(1-remaining) + float64(client.TotalGB)*remaining))
+}
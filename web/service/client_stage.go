@@ -0,0 +1,187 @@
+package service
+
+import (
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// Enforcement stages a client goes through as it reaches its expiry or traffic limit.
+const (
+	ClientStageWarning  = "warning"  // Within the expiry or traffic warning threshold
+	ClientStageGrace    = "grace"    // Expired or exhausted, still connected with a limited access
+	ClientStageDisabled = "disabled" // Disabled for its expiry or traffic limit
+	ClientStageDeleted  = "deleted"  // Deleted after being disabled, only reported in stage changes
+)
+
+// ClientStageChange is a client entering a new enforcement stage.
+type ClientStageChange struct {
+	Email string
+	Stage string
+	Until int64 // End of the grace period in milliseconds
+}
+
+// getGracePeriod returns how long an expired or exhausted client keeps a limited access, 0 when it is disabled at once.
+func (s *InboundService) getGracePeriod() time.Duration {
+	hours, err := s.settingService.GetGracePeriod()
+	if err != nil || hours <= 0 {
+		return 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// getGraceRoute returns the outbound clients in their grace period are routed to,
+// or an empty route when they are throttled instead.
+func (s *InboundService) getGraceRoute() egressRoute {
+	if s.getGracePeriod() == 0 {
+		return egressRoute{}
+	}
+	action, err := s.settingService.GetGraceAction()
+	if err != nil || action != "route" {
+		return egressRoute{}
+	}
+	outbound, err := s.settingService.GetGraceOutbound()
+	if err != nil {
+		return egressRoute{}
+	}
+	return egressRoute{OutboundTag: outbound}
+}
+
+// isGraceThrottled reports whether clients in their grace period are held to the throttle speeds.
+func (s *InboundService) isGraceThrottled() bool {
+	if s.getGracePeriod() == 0 {
+		return false
+	}
+	action, err := s.settingService.GetGraceAction()
+	return err == nil && action == "throttle"
+}
+
+// UpdateClientStages moves clients between enforcement stages. Clients close to their limits get a
+// warning, expired or exhausted clients enter their grace period when one is set, and clients
+// disabled for longer than the auto delete days are deleted. Clients disabled by the traffic job
+// since the given time are reported too. Returns the stage changes and whether Xray needs a restart.
+func (s *InboundService) UpdateClientStages(since int64) ([]*ClientStageChange, bool, error) {
+	db := database.GetDB()
+	now := time.Now().UnixMilli()
+	grace := s.getGracePeriod().Milliseconds()
+	warnTraffic, warnExpiry := int64(0), int64(0)
+	if diff, err := s.settingService.GetTrafficDiff(); err == nil && diff > 0 {
+		warnTraffic = int64(diff) * 1073741824
+	}
+	if diff, err := s.settingService.GetExpireDiff(); err == nil && diff > 0 {
+		warnExpiry = int64(diff) * 86400000
+	}
+
+	poolIds, err := s.getExhaustedPoolIds(db)
+	if err != nil {
+		return nil, false, err
+	}
+	exhaustedPools := make(map[int]bool, len(poolIds))
+	for _, id := range poolIds {
+		exhaustedPools[id] = true
+	}
+
+	var traffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).Where("enable = ?", true).Find(&traffics).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	var changes []*ClientStageChange
+	graceChanged := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, traffic := range traffics {
+			used := traffic.Up + traffic.Down
+			violated := (traffic.Total > 0 && used >= traffic.Total) ||
+				(traffic.ExpiryTime > 0 && traffic.ExpiryTime <= now) ||
+				exhaustedPools[traffic.PoolId]
+			stage := ""
+			switch {
+			case violated && grace == 0:
+				// Disabled by the traffic job, a stale disabled stage is still cleared
+				if traffic.Stage != ClientStageDisabled {
+					continue
+				}
+			case violated:
+				stage = ClientStageGrace
+			case (warnTraffic > 0 && traffic.Total > 0 && traffic.Total-used < warnTraffic) ||
+				(warnExpiry > 0 && traffic.ExpiryTime > 0 && traffic.ExpiryTime-now < warnExpiry):
+				stage = ClientStageWarning
+			}
+			if traffic.Stage == stage {
+				continue
+			}
+			// The traffic job may have disabled the client meanwhile, its stage is left to it
+			err := tx.Model(xray.ClientTraffic{}).Where("id = ? AND enable = ?", traffic.Id, true).
+				Updates(map[string]any{"stage": stage, "stage_time": now}).Error
+			if err != nil {
+				return err
+			}
+			graceChanged = graceChanged || stage == ClientStageGrace || traffic.Stage == ClientStageGrace
+			if stage != "" {
+				changes = append(changes, &ClientStageChange{Email: traffic.Email, Stage: stage, Until: now + grace})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	var disabled []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).
+		Where("enable = ? AND stage = ? AND stage_time > ?", false, ClientStageDisabled, since).
+		Find(&disabled).Error
+	if err != nil {
+		return nil, false, err
+	}
+	for _, traffic := range disabled {
+		changes = append(changes, &ClientStageChange{Email: traffic.Email, Stage: ClientStageDisabled})
+	}
+
+	needRestart := graceChanged && !s.getGraceRoute().isEmpty()
+	deleted, restart := s.delDisabledClients(now)
+	changes = append(changes, deleted...)
+	return changes, needRestart || restart, nil
+}
+
+// clearDisabledStage takes the matching clients out of the disabled stage once they are enabled again,
+// so that a later manual disable is not taken for an enforcement one.
+func clearDisabledStage(tx *gorm.DB, query string, args ...any) error {
+	return tx.Model(xray.ClientTraffic{}).Where(query, args...).Where("stage = ?", ClientStageDisabled).
+		Updates(map[string]any{"stage": "", "stage_time": 0}).Error
+}
+
+// delDisabledClients deletes the clients disabled for their expiry or traffic limit for longer than the auto delete days.
+// Only clients still in the disabled stage are deleted, the stage is cleared whenever a client is enabled or reset.
+func (s *InboundService) delDisabledClients(now int64) ([]*ClientStageChange, bool) {
+	days, err := s.settingService.GetAutoDeleteDays()
+	if err != nil || days <= 0 {
+		return nil, false
+	}
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).
+		Where("enable = ? AND stage = ? AND stage_time <= ?", false, ClientStageDisabled, now-int64(days)*86400000).
+		Find(&traffics).Error
+	if err != nil {
+		logger.Warning("Unable to load disabled clients:", err)
+		return nil, false
+	}
+	var changes []*ClientStageChange
+	needRestart := false
+	for _, traffic := range traffics {
+		restart, err := s.DelInboundClientByEmail(traffic.InboundId, traffic.Email)
+		if err != nil {
+			logger.Warning("Unable to delete disabled client", traffic.Email, ":", err)
+			continue
+		}
+		needRestart = needRestart || restart
+		changes = append(changes, &ClientStageChange{Email: traffic.Email, Stage: ClientStageDeleted})
+	}
+	return changes, needRestart
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Retention of client traffic history buckets per period.
+var clientHistoryRetention = map[string]time.Duration{
+	"5m":   2 * 24 * time.Hour,
+	"hour": 30 * 24 * time.Hour,
+	"day":  400 * 24 * time.Hour,
+}
+
+// TrafficHistoryPoint is the traffic of one time bucket, summed over the selected clients.
+type TrafficHistoryPoint struct {
+	Time int64 `json:"time"`
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+// addClientTrafficHistory adds traffic deltas of known clients to their 5 minute, hourly and daily buckets.
+func (s *InboundService) addClientTrafficHistory(tx *gorm.DB, traffics []*xray.ClientTraffic, dbClientTraffics []*xray.ClientTraffic) error {
+	inboundIds := make(map[string]int, len(dbClientTraffics))
+	for _, traffic := range dbClientTraffics {
+		inboundIds[traffic.Email] = traffic.InboundId
+	}
+
+	now := time.Now().In(s.getTimeLocation())
+	buckets := map[string]int64{
+		"5m":   now.Truncate(5 * time.Minute).Unix(),
+		"hour": time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location()).Unix(),
+		"day":  time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix(),
+	}
+
+	var history []*model.ClientTrafficHistory
+	for _, traffic := range traffics {
+		inboundId, ok := inboundIds[traffic.Email]
+		if !ok || traffic.Up+traffic.Down == 0 {
+			continue
+		}
+		for period, bucket := range buckets {
+			history = append(history, &model.ClientTrafficHistory{
+				InboundId: inboundId,
+				Email:     traffic.Email,
+				Period:    period,
+				Time:      bucket,
+				Up:        traffic.Up,
+				Down:      traffic.Down,
+			})
+		}
+	}
+	if len(history) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "email"}, {Name: "period"}, {Name: "time"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"up":   gorm.Expr("client_traffic_histories.up + excluded.up"),
+			"down": gorm.Expr("client_traffic_histories.down + excluded.down"),
+		}),
+	}).CreateInBatches(history, 100).Error
+}
+
+// GetClientTrafficHistory returns the traffic buckets of a client between from and to (Unix seconds, 0 for no bound).
+func (s *InboundService) GetClientTrafficHistory(email string, period string, from int64, to int64) ([]*TrafficHistoryPoint, error) {
+	return s.getTrafficHistory("email = ?", email, period, from, to)
+}
+
+// GetInboundTrafficHistory returns the traffic buckets of all clients of an inbound.
+func (s *InboundService) GetInboundTrafficHistory(inboundId int, period string, from int64, to int64) ([]*TrafficHistoryPoint, error) {
+	return s.getTrafficHistory("inbound_id = ?", inboundId, period, from, to)
+}
+
+// GetInboundTrafficHistoryByTag returns the traffic buckets of all clients of the inbound with the given tag.
+func (s *InboundService) GetInboundTrafficHistoryByTag(tag string, period string, from int64, to int64) ([]*TrafficHistoryPoint, error) {
+	db := database.GetDB()
+	inbound := &model.Inbound{}
+	err := db.Model(model.Inbound{}).Where("tag = ?", tag).First(inbound).Error
+	if err != nil {
+		return nil, err
+	}
+	return s.GetInboundTrafficHistory(inbound.Id, period, from, to)
+}
+
+func (s *InboundService) getTrafficHistory(where string, arg any, period string, from int64, to int64) ([]*TrafficHistoryPoint, error) {
+	if _, ok := clientHistoryRetention[period]; !ok {
+		return nil, common.NewError("invalid history period:", period)
+	}
+	db := database.GetDB()
+	query := db.Model(model.ClientTrafficHistory{}).Where(where, arg).Where("period = ?", period)
+	if from > 0 {
+		query = query.Where("time >= ?", from)
+	}
+	if to > 0 {
+		query = query.Where("time <= ?", to)
+	}
+	points := make([]*TrafficHistoryPoint, 0)
+	err := query.Select("time, SUM(up) AS up, SUM(down) AS down").
+		Group("time").Order("time asc").Scan(&points).Error
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// GetClientsUsage returns the traffic used by the given clients over the last days calendar days,
+// today included, in the panel time zone.
+func (s *InboundService) GetClientsUsage(emails []string, days int) (int64, error) {
+	if len(emails) == 0 || days <= 0 {
+		return 0, nil
+	}
+	now := time.Now().In(s.getTimeLocation())
+	since := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location()).Unix()
+
+	db := database.GetDB()
+	var usage int64
+	err := db.Model(model.ClientTrafficHistory{}).
+		Where("email IN ? AND period = ? AND time >= ?", emails, "day", since).
+		Select("COALESCE(SUM(up + down), 0)").Scan(&usage).Error
+	if err != nil {
+		return 0, err
+	}
+	return usage, nil
+}
+
+// UpdateClientTrafficHistory moves the traffic history of a client to its new email.
+func (s *InboundService) UpdateClientTrafficHistory(tx *gorm.DB, oldEmail string, newEmail string) error {
+	if oldEmail == newEmail {
+		return nil
+	}
+	err := tx.Where("email = ?", newEmail).Delete(model.ClientTrafficHistory{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(model.ClientTrafficHistory{}).Where("email = ?", oldEmail).Update("email", newEmail).Error
+}
+
+// DelClientTrafficHistory removes the traffic history of a deleted client, so that a new client
+// with the same email starts with an empty history.
+func (s *InboundService) DelClientTrafficHistory(tx *gorm.DB, email string) error {
+	return tx.Where("email = ?", email).Delete(model.ClientTrafficHistory{}).Error
+}
+
+// DelOldClientTrafficHistory removes history buckets past their retention period.
+func (s *InboundService) DelOldClientTrafficHistory() error {
+	db := database.GetDB()
+	now := time.Now()
+	for period, retention := range clientHistoryRetention {
+		err := db.Where("period = ? AND time < ?", period, now.Add(-retention).Unix()).
+			Delete(model.ClientTrafficHistory{}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *InboundService) getTimeLocation() *time.Location {
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
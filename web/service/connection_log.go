@@ -0,0 +1,310 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// connectionLogBatchSize is the number of parsed lines written to the database at once.
+const connectionLogBatchSize = 500
+
+var (
+	// accessLogLock serializes ingestions so that no line is stored twice.
+	accessLogLock sync.Mutex
+
+	accessLogLineRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) from (?:tcp:|udp:)?/?(\S+) (accepted|rejected)\s+(.*)$`)
+	accessLogTagsRegex = regexp.MustCompile(`\s\[([^\]]*)\]\s*$`)
+	accessLogMailRegex = regexp.MustCompile(`\s*email: (\S+)\s*$`)
+)
+
+// ConnectionLogService ingests the Xray access log into a searchable connection log.
+type ConnectionLogService struct {
+	settingService SettingService
+}
+
+// ConnectionLogFilter selects connection log entries. Empty fields match everything.
+type ConnectionLogFilter struct {
+	Email    string `json:"email" form:"email"`
+	Ip       string `json:"ip" form:"ip"`
+	Domain   string `json:"domain" form:"domain"`     // Substring of the destination
+	Outbound string `json:"outbound" form:"outbound"` // Outbound tag
+	From     int64  `json:"from" form:"from"`         // Start timestamp, inclusive
+	To       int64  `json:"to" form:"to"`             // End timestamp, exclusive
+	Limit    int    `json:"limit" form:"limit"`
+	Offset   int    `json:"offset" form:"offset"`
+}
+
+// ConnectionLogPage is a page of connection log search results.
+type ConnectionLogPage struct {
+	Total int64                  `json:"total"`
+	Logs  []*model.ConnectionLog `json:"logs"`
+}
+
+// IsEnabled reports whether the access log is ingested into the connection log.
+func (s *ConnectionLogService) IsEnabled() bool {
+	enable, err := s.settingService.GetConnectionLogEnable()
+	return err == nil && enable
+}
+
+// IngestAccessLog stores the access log lines written since the last ingestion
+// and returns how many connections were stored.
+func (s *ConnectionLogService) IngestAccessLog() (int, error) {
+	accessLogLock.Lock()
+	defer accessLogLock.Unlock()
+	return s.ingestAccessLog()
+}
+
+func (s *ConnectionLogService) ingestAccessLog() (int, error) {
+	accessLogPath, err := xray.GetAccessLogPath()
+	if err != nil || accessLogPath == "" || accessLogPath == "none" {
+		return 0, err
+	}
+	file, err := os.Open(accessLogPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offset, err := s.settingService.GetAccessLogOffset()
+	if err != nil || offset > info.Size() {
+		// The log was truncated or replaced, start over
+		offset = 0
+	}
+	if offset == info.Size() {
+		return 0, nil
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	anonymize, err := s.settingService.GetConnectionLogAnonymize()
+	if err != nil {
+		anonymize = false
+	}
+
+	db := database.GetDB()
+	count := 0
+	batch := make([]*model.ConnectionLog, 0, connectionLogBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := db.CreateInBatches(batch, connectionLogBatchSize).Error; err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return s.settingService.SetAccessLogOffset(offset)
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A line without its new line is still being written, read it next time
+			break
+		}
+		offset += int64(len(line))
+		entry := parseAccessLogLine(strings.TrimSpace(line))
+		if entry == nil {
+			continue
+		}
+		if anonymize {
+			entry.Ip = anonymizeIp(entry.Ip)
+		}
+		batch = append(batch, entry)
+		if len(batch) == connectionLogBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+	// Lines skipped at the end of the read still move the offset forward
+	return count, s.settingService.SetAccessLogOffset(offset)
+}
+
+// RotateAccessLog stores the access log lines written since the last ingestion, then appends the access
+// log to the persistent access log and truncates it, unless a line is still being written.
+// Returns whether the access log was truncated.
+func (s *ConnectionLogService) RotateAccessLog() (bool, error) {
+	accessLogLock.Lock()
+	defer accessLogLock.Unlock()
+	if _, err := s.ingestAccessLog(); err != nil {
+		return false, err
+	}
+	accessLogPath, err := xray.GetAccessLogPath()
+	if err != nil || accessLogPath == "" || accessLogPath == "none" {
+		return false, err
+	}
+	file, err := os.Open(accessLogPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	offset, err := s.settingService.GetAccessLogOffset()
+	if err != nil || offset < info.Size() {
+		return false, err
+	}
+
+	persistent, err := os.OpenFile(xray.GetAccessPersistentLogPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return false, err
+	}
+	defer persistent.Close()
+	if _, err := io.Copy(persistent, file); err != nil {
+		return false, err
+	}
+	if err := os.Truncate(accessLogPath, 0); err != nil {
+		return false, err
+	}
+	return true, s.settingService.SetAccessLogOffset(0)
+}
+
+// parseAccessLogLine parses an Xray access log line, returning nil for lines that are not
+// connections or that belong to the panel's own API calls.
+func parseAccessLogLine(line string) *model.ConnectionLog {
+	matches := accessLogLineRegex.FindStringSubmatch(line)
+	if len(matches) < 5 {
+		return nil
+	}
+	dateTime, err := time.ParseInLocation("2006/01/02 15:04:05", matches[1], time.Local)
+	if err != nil {
+		return nil
+	}
+
+	entry := &model.ConnectionLog{
+		Time:     dateTime.Unix(),
+		Ip:       matches[2],
+		Accepted: matches[3] == "accepted",
+	}
+	if host, _, err := net.SplitHostPort(entry.Ip); err == nil {
+		entry.Ip = host
+	}
+
+	rest := matches[4]
+	if mail := accessLogMailRegex.FindStringSubmatch(rest); len(mail) > 1 {
+		entry.Email = mail[1]
+		rest = rest[:len(rest)-len(mail[0])]
+	}
+	if !entry.Accepted {
+		entry.Reason = strings.TrimSpace(rest)
+		return entry
+	}
+
+	if tags := accessLogTagsRegex.FindStringSubmatch(rest); len(tags) > 1 {
+		inbound, outbound, found := strings.Cut(tags[1], " >> ")
+		if !found {
+			inbound, outbound, _ = strings.Cut(tags[1], " -> ")
+		}
+		entry.InboundTag = strings.TrimSpace(inbound)
+		entry.OutboundTag = strings.TrimSpace(outbound)
+		rest = rest[:len(rest)-len(tags[0])]
+	}
+	if entry.InboundTag == "api" {
+		return nil
+	}
+
+	destination := strings.TrimLeft(strings.TrimSpace(rest), "/")
+	if network, address, found := strings.Cut(destination, ":"); found && (network == "tcp" || network == "udp") {
+		entry.Network = network
+		destination = address
+	}
+	if host, port, err := net.SplitHostPort(destination); err == nil {
+		entry.Destination = host
+		entry.Port, _ = strconv.Atoi(port)
+	} else {
+		entry.Destination = destination
+	}
+	return entry
+}
+
+// anonymizeIp truncates an IPv4 address to its /24 network and an IPv6 address to its /48 network.
+func anonymizeIp(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// SearchConnectionLogs returns the connection log entries matching the filter, newest first.
+func (s *ConnectionLogService) SearchConnectionLogs(filter *ConnectionLogFilter) (*ConnectionLogPage, error) {
+	db := database.GetDB()
+	query := db.Model(model.ConnectionLog{})
+	if filter.Email != "" {
+		query = query.Where("email = ?", filter.Email)
+	}
+	if filter.Ip != "" {
+		query = query.Where("ip = ?", filter.Ip)
+	}
+	if filter.Domain != "" {
+		query = query.Where("destination LIKE ?", "%"+filter.Domain+"%")
+	}
+	if filter.Outbound != "" {
+		query = query.Where("outbound_tag = ?", filter.Outbound)
+	}
+	if filter.From > 0 {
+		query = query.Where("time >= ?", filter.From)
+	}
+	if filter.To > 0 {
+		query = query.Where("time < ?", filter.To)
+	}
+
+	page := &ConnectionLogPage{}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	err := query.Order("time desc, id desc").Limit(limit).Offset(filter.Offset).Find(&page.Logs).Error
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// DelOldConnectionLogs removes connection log entries older than the retention period.
+func (s *ConnectionLogService) DelOldConnectionLogs() error {
+	days, err := s.settingService.GetConnectionLogRetention()
+	if err != nil || days <= 0 {
+		return err
+	}
+	before := time.Now().AddDate(0, 0, -days).Unix()
+	db := database.GetDB()
+	result := db.Where("time < ?", before).Delete(model.ConnectionLog{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Debugf("Deleted %d old connection log entries", result.RowsAffected)
+	}
+	return nil
+}
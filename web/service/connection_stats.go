@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// DestinationCount is the number of connections to a domain, port or country.
+type DestinationCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// ClientDestinationStats summarizes what a client connected to, built from the connection log.
+type ClientDestinationStats struct {
+	Email       string             `json:"email"`
+	Connections int64              `json:"connections"`
+	Udp         int64              `json:"udp"`     // Connections over UDP
+	Torrent     int64              `json:"torrent"` // Connections routed by BitTorrent sniffing rules
+	Domains     []DestinationCount `json:"domains"`
+	Ports       []DestinationCount `json:"ports"`
+	Countries   []DestinationCount `json:"countries"` // Countries of IP destinations, from geoip.dat
+}
+
+// GetClientDestinationStats returns the top destinations, ports and countries of a client
+// between from and to (0 for no bound), keeping the top entries of each list.
+func (s *ConnectionLogService) GetClientDestinationStats(email string, from int64, to int64, top int) (*ClientDestinationStats, error) {
+	db := database.GetDB()
+	query := db.Model(model.ConnectionLog{}).Where("email = ? AND accepted = ?", email, true)
+	if from > 0 {
+		query = query.Where("time >= ?", from)
+	}
+	if to > 0 {
+		query = query.Where("time < ?", to)
+	}
+
+	var rows []struct {
+		Destination string
+		Port        int
+		Network     string
+		OutboundTag string
+		Count       int64
+	}
+	err := query.Select("destination, port, network, outbound_tag, COUNT(*) AS count").
+		Group("destination, port, network, outbound_tag").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	torrentTags := s.getTorrentOutboundTags()
+	stats := &ClientDestinationStats{Email: email}
+	domains := map[string]int64{}
+	ports := map[string]int64{}
+	countries := map[string]int64{}
+	for _, row := range rows {
+		stats.Connections += row.Count
+		if row.Network == "udp" {
+			stats.Udp += row.Count
+		}
+		if slices.Contains(torrentTags, row.OutboundTag) {
+			stats.Torrent += row.Count
+		}
+		if row.Port > 0 {
+			ports[strconv.Itoa(row.Port)] += row.Count
+		}
+		if net.ParseIP(row.Destination) != nil {
+			// Domains would need a DNS lookup, only IP destinations get a country
+			if country := countryTable.lookup(row.Destination); country != "" {
+				countries[country] += row.Count
+			}
+		} else if row.Destination != "" {
+			domains[row.Destination] += row.Count
+		}
+	}
+	stats.Domains = topDestinations(domains, top)
+	stats.Ports = topDestinations(ports, top)
+	stats.Countries = topDestinations(countries, top)
+	return stats, nil
+}
+
+// topDestinations returns the top entries of counts, the most connected first.
+func topDestinations(counts map[string]int64, top int) []DestinationCount {
+	result := make([]DestinationCount, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, DestinationCount{Name: name, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+	if top > 0 && len(result) > top {
+		result = result[:top]
+	}
+	return result
+}
+
+// getTorrentOutboundTags returns the outbounds that routing rules send sniffed BitTorrent traffic to,
+// read from the running config or else the template, along with the outbound of the BitTorrent preset.
+func (s *ConnectionLogService) getTorrentOutboundTags() []string {
+	tags := []string{torrentBlockOutboundTag}
+	var routerConfig []byte
+	if p != nil && p.GetConfig() != nil {
+		routerConfig = p.GetConfig().RouterConfig
+	} else {
+		template, err := s.settingService.GetXrayConfigTemplate()
+		if err != nil {
+			return tags
+		}
+		xrayConfig := &xray.Config{}
+		if err := json.Unmarshal([]byte(template), xrayConfig); err != nil {
+			return tags
+		}
+		routerConfig = xrayConfig.RouterConfig
+	}
+
+	var routing struct {
+		Rules []struct {
+			Protocol    []string `json:"protocol"`
+			OutboundTag string   `json:"outboundTag"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal(routerConfig, &routing); err != nil {
+		return tags
+	}
+	for _, rule := range routing.Rules {
+		if rule.OutboundTag != "" && slices.Contains(rule.Protocol, "bittorrent") && !slices.Contains(tags, rule.OutboundTag) {
+			tags = append(tags, rule.OutboundTag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/util/random"
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"github.com/google/uuid"
+)
+
+// rotatedEmailSuffix marks the Xray user holding the previous credential of a client during the overlap.
+// Its traffic is counted toward the client.
+const rotatedEmailSuffix = ".rotated"
+
+// credentialField returns the client setting holding the credential of a protocol.
+func credentialField(protocol model.Protocol) string {
+	switch protocol {
+	case model.VMESS, model.VLESS:
+		return "id"
+	case model.Trojan, model.Shadowsocks:
+		return "password"
+	}
+	return ""
+}
+
+// newCredential generates a UUID, or a password fitting the Shadowsocks method of the inbound.
+func newCredential(protocol model.Protocol, method string) string {
+	switch protocol {
+	case model.VMESS, model.VLESS:
+		return uuid.NewString()
+	case model.Shadowsocks:
+		// 2022 methods need a base64 key of the cipher's size
+		size := 32
+		if method == "2022-blake3-aes-128-gcm" {
+			size = 16
+		}
+		key := make([]byte, size)
+		rand.Read(key)
+		return base64.StdEncoding.EncodeToString(key)
+	}
+	return random.Seq(10)
+}
+
+// CredentialRotationService regenerates the UUIDs and passwords of clients. The previous credential
+// stays valid for the configured overlap through a second Xray user, so that apps can refresh their
+// subscription, which keeps its subId, before it is removed.
+type CredentialRotationService struct {
+	inboundService InboundService
+	planService    PlanService
+	settingService SettingService
+	tgbotService   Tgbot
+	xrayApi        xray.XrayAPI
+}
+
+// RotateClientCredential rotates the credential of a client now. Returns whether Xray needs a restart.
+func (s *CredentialRotationService) RotateClientCredential(inboundId int, email string) (bool, error) {
+	inbound, err := s.inboundService.GetInbound(inboundId)
+	if err != nil {
+		return false, err
+	}
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return false, err
+	}
+	clients, _ := settings["clients"].([]any)
+	for _, client := range clients {
+		c, ok := client.(map[string]any)
+		if !ok || c["email"] != email {
+			continue
+		}
+		oldCredential, err := s.rotate(inbound, settings, c, time.Now(), s.getOverlap())
+		if err != nil {
+			return false, err
+		}
+		if err := s.saveSettings(inbound, settings); err != nil {
+			return false, err
+		}
+		needRestart := s.pushRotation(inbound, settings, c, oldCredential)
+		s.notify(c)
+		return needRestart, nil
+	}
+	return false, common.NewErrorf("client with email %s not found", email)
+}
+
+// RotateDueCredentials rotates the clients whose rotation period elapsed and removes the previous
+// credentials whose overlap ended. Returns how many clients were rotated and whether Xray needs a restart.
+func (s *CredentialRotationService) RotateDueCredentials() (int, bool, error) {
+	plans, err := s.planService.getPlanMap()
+	if err != nil {
+		return 0, false, err
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return 0, false, err
+	}
+	now := time.Now()
+	nowMs := now.UnixMilli()
+	overlap := s.getOverlap()
+	count := 0
+	needRestart := false
+	for _, inbound := range inbounds {
+		if credentialField(inbound.Protocol) == "" {
+			continue
+		}
+		var settings map[string]any
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			continue
+		}
+		enabled := make(map[string]bool, len(inbound.ClientStats))
+		for _, traffic := range inbound.ClientStats {
+			enabled[traffic.Email] = traffic.Enable
+		}
+		clients, _ := settings["clients"].([]any)
+		changed := false
+		var expired []string
+		rotated := map[string]string{}
+		for _, client := range clients {
+			c, ok := client.(map[string]any)
+			if !ok {
+				continue
+			}
+			email, _ := c["email"].(string)
+			if until, _ := c["oldCredentialUntil"].(float64); until > 0 && int64(until) <= nowMs {
+				delete(c, "oldCredential")
+				delete(c, "oldCredentialUntil")
+				expired = append(expired, email)
+				changed = true
+			}
+
+			days, _ := c["rotateDays"].(float64)
+			if days <= 0 {
+				planId, _ := c["planId"].(float64)
+				if plan := plans[int(planId)]; plan != nil {
+					days = float64(plan.RotateDays)
+				}
+			}
+			if days <= 0 || email == "" {
+				continue
+			}
+			last, _ := c["rotatedAt"].(float64)
+			if last == 0 {
+				last, _ = c["created_at"].(float64)
+			}
+			if last == 0 {
+				// The first period starts when the policy is first seen
+				c["rotatedAt"] = nowMs
+				changed = true
+				continue
+			}
+			if int64(last)+int64(days)*86400000 > nowMs {
+				continue
+			}
+			if enable, _ := c["enable"].(bool); !enable || !enabled[email] {
+				continue
+			}
+			oldCredential, err := s.rotate(inbound, settings, c, now, overlap)
+			if err != nil {
+				logger.Warning("Unable to rotate the credential of", email, ":", err)
+				continue
+			}
+			rotated[email] = oldCredential
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := s.saveSettings(inbound, settings); err != nil {
+			return count, needRestart, err
+		}
+		s.removeOldCredentials(inbound, expired)
+		for _, client := range clients {
+			c, _ := client.(map[string]any)
+			email, _ := c["email"].(string)
+			if oldCredential, ok := rotated[email]; ok {
+				needRestart = s.pushRotation(inbound, settings, c, oldCredential) || needRestart
+				s.notify(c)
+				count++
+			}
+		}
+	}
+	return count, needRestart, nil
+}
+
+// RotateSubscription moves the clients of a subscription to a new subId and rotates their credentials
+// without an overlap, cutting off everyone holding the old links. Returns the new subId and whether
+// Xray needs a restart.
+func (s *CredentialRotationService) RotateSubscription(subId string) (string, bool, error) {
+	if subId == "" {
+		return "", false, common.NewError("empty subId")
+	}
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return "", false, err
+	}
+	newSubId := random.Seq(16)
+	now := time.Now()
+	found := false
+	needRestart := false
+	for _, inbound := range inbounds {
+		var settings map[string]any
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+			continue
+		}
+		clients, _ := settings["clients"].([]any)
+		changed := false
+		var rotated []map[string]any
+		var oldCredentials []string
+		for _, client := range clients {
+			c, ok := client.(map[string]any)
+			if !ok || c["subId"] != subId {
+				continue
+			}
+			changed = true
+			c["subId"] = newSubId
+			c["updated_at"] = now.UnixMilli()
+			if credentialField(inbound.Protocol) != "" {
+				oldCredential, err := s.rotate(inbound, settings, c, now, 0)
+				if err != nil {
+					return "", needRestart, err
+				}
+				rotated = append(rotated, c)
+				oldCredentials = append(oldCredentials, oldCredential)
+			}
+		}
+		if !changed {
+			continue
+		}
+		found = true
+		if err := s.saveSettings(inbound, settings); err != nil {
+			return "", needRestart, err
+		}
+		for i, c := range rotated {
+			needRestart = s.pushRotation(inbound, settings, c, oldCredentials[i]) || needRestart
+		}
+	}
+	if !found {
+		return "", false, common.NewErrorf("no client with subId %s", subId)
+	}
+	return newSubId, needRestart, nil
+}
+
+// rotate replaces the credential of a raw client entry and keeps the previous one for the overlap.
+// Returns the previous credential.
+func (s *CredentialRotationService) rotate(inbound *model.Inbound, settings map[string]any, c map[string]any, now time.Time, overlap time.Duration) (string, error) {
+	field := credentialField(inbound.Protocol)
+	if field == "" {
+		return "", common.NewErrorf("%s clients have no credential to rotate", inbound.Protocol)
+	}
+	oldCredential, _ := c[field].(string)
+	method, _ := settings["method"].(string)
+	c[field] = newCredential(inbound.Protocol, method)
+	if overlap > 0 && oldCredential != "" {
+		c["oldCredential"] = oldCredential
+		c["oldCredentialUntil"] = now.Add(overlap).UnixMilli()
+	} else {
+		delete(c, "oldCredential")
+		delete(c, "oldCredentialUntil")
+	}
+	c["rotatedAt"] = now.UnixMilli()
+	c["updated_at"] = now.UnixMilli()
+	return oldCredential, nil
+}
+
+func (s *CredentialRotationService) getOverlap() time.Duration {
+	minutes, err := s.settingService.GetCredentialOverlap()
+	if err != nil || minutes <= 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func (s *CredentialRotationService) saveSettings(inbound *model.Inbound, settings map[string]any) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	inbound.Settings = string(data)
+	db := database.GetDB()
+	return db.Model(model.Inbound{}).Where("id = ?", inbound.Id).Update("settings", inbound.Settings).Error
+}
+
+// pushRotation swaps the credential of an enabled client in the running Xray and adds the previous one
+// under a second user for the overlap, replacing the one of an earlier rotation. Returns whether Xray
+// needs a restart instead.
+func (s *CredentialRotationService) pushRotation(inbound *model.Inbound, settings map[string]any, c map[string]any, oldCredential string) bool {
+	if enable, _ := c["enable"].(bool); !enable || p == nil || !p.IsRunning() {
+		return false
+	}
+	email, _ := c["email"].(string)
+	if traffic, err := s.inboundService.GetClientTrafficByEmail(email); err != nil || traffic == nil || !traffic.Enable {
+		return false
+	}
+	if err := s.xrayApi.Init(p.GetAPIPort()); err != nil {
+		return true
+	}
+	defer s.xrayApi.Close()
+
+	cipher := ""
+	if inbound.Protocol == model.Shadowsocks {
+		cipher, _ = settings["method"].(string)
+	}
+	user := map[string]any{"cipher": cipher}
+	for _, key := range []string{"email", "id", "security", "flow", "password"} {
+		value, _ := c[key].(string)
+		user[key] = value
+	}
+	needRestart := false
+	err := s.xrayApi.RemoveUser(inbound.Tag, email)
+	if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", email)) {
+		logger.Debug("Error in removing rotated client by api:", err)
+		needRestart = true
+	}
+	if err := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, user); err != nil {
+		logger.Debug("Error in adding rotated client by api:", err)
+		needRestart = true
+	}
+	// A credential from an earlier rotation may still be in its overlap, it is replaced or dropped
+	alias := email + rotatedEmailSuffix
+	err = s.xrayApi.RemoveUser(inbound.Tag, alias)
+	if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("User %s not found.", alias)) {
+		logger.Debug("Error in removing previous credential by api:", err)
+		needRestart = true
+	}
+	if _, ok := c["oldCredential"]; ok {
+		user["email"] = alias
+		user[credentialField(inbound.Protocol)] = oldCredential
+		if err := s.xrayApi.AddUser(string(inbound.Protocol), inbound.Tag, user); err != nil {
+			logger.Debug("Error in adding previous credential by api:", err)
+			needRestart = true
+		}
+	}
+	return needRestart
+}
+
+// removeOldCredentials removes the users holding previous credentials whose overlap ended.
+func (s *CredentialRotationService) removeOldCredentials(inbound *model.Inbound, emails []string) {
+	if len(emails) == 0 || p == nil || !p.IsRunning() {
+		return
+	}
+	if err := s.xrayApi.Init(p.GetAPIPort()); err != nil {
+		return
+	}
+	defer s.xrayApi.Close()
+	for _, email := range emails {
+		if err := s.xrayApi.RemoveUser(inbound.Tag, email+rotatedEmailSuffix); err != nil {
+			logger.Debug("Error in removing previous credential by api:", err)
+		}
+	}
+}
+
+// notify tells a client bound to Telegram that its credentials changed.
+func (s *CredentialRotationService) notify(c map[string]any) {
+	tgId, _ := c["tgId"].(float64)
+	if tgId == 0 || !s.tgbotService.IsRunning() {
+		return
+	}
+	email, _ := c["email"].(string)
+	until := time.Now()
+	if value, ok := c["oldCredentialUntil"].(int64); ok {
+		until = time.UnixMilli(value)
+	}
+	until = until.In(s.inboundService.getTimeLocation())
+	s.tgbotService.SendMsgToTgbot(int64(tgId), s.tgbotService.I18nBot("tgbot.messages.credentialRotated",
+		"Email=="+email, "Time=="+until.Format("2006-01-02 15:04")))
+}
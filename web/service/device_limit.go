@@ -0,0 +1,138 @@
+package service
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+)
+
+// DeviceLimitService limits the number of concurrent devices of a client.
+// Devices are tracked as sessions keyed by source IP, with a grace window that lets
+// a device change its IP without taking another slot.
+type DeviceLimitService struct {
+	settingService SettingService
+}
+
+// TrackSessions records the source IPs a client was just seen with and enforces its device limit.
+// It returns the IPs of the sessions evicted because the limit was exceeded.
+func (s *DeviceLimitService) TrackSessions(email string, ips []string, limit int) ([]string, error) {
+	grace, err := s.settingService.GetDeviceGraceWindow()
+	if err != nil {
+		grace = 180
+	}
+	policy, err := s.settingService.GetDeviceEvictPolicy()
+	if err != nil {
+		policy = "newest"
+	}
+	now := time.Now().Unix()
+	graceStart := now - int64(grace)
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	var sessions []*model.ClientSession
+	err = tx.Model(model.ClientSession{}).Where("email = ?", email).Order("first_seen asc, id asc").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Devices not seen now but still within the grace window may have moved to a new IP
+	var quiet []*model.ClientSession
+	for _, session := range sessions {
+		if slices.Contains(ips, session.Ip) {
+			session.LastSeen = now
+		} else if session.LastSeen >= graceStart {
+			quiet = append(quiet, session)
+		}
+	}
+	sort.SliceStable(quiet, func(i, j int) bool {
+		return quiet[i].LastSeen > quiet[j].LastSeen
+	})
+
+	for _, ip := range ips {
+		if slices.ContainsFunc(sessions, func(session *model.ClientSession) bool { return session.Ip == ip }) {
+			continue
+		}
+		if len(quiet) > 0 {
+			// Roaming: the most recently seen quiet device takes the new IP
+			quiet[0].Ip = ip
+			quiet[0].LastSeen = now
+			quiet = quiet[1:]
+			continue
+		}
+		sessions = append(sessions, &model.ClientSession{
+			Email:     email,
+			Ip:        ip,
+			FirstSeen: now,
+			LastSeen:  now,
+		})
+	}
+
+	active := make([]*model.ClientSession, 0, len(sessions))
+	var stale []*model.ClientSession
+	for _, session := range sessions {
+		if session.LastSeen >= graceStart {
+			active = append(active, session)
+		} else {
+			stale = append(stale, session)
+		}
+	}
+
+	var evicted []*model.ClientSession
+	if limit > 0 && len(active) > limit {
+		if policy == "oldest" {
+			evicted = active[:len(active)-limit]
+			active = active[len(active)-limit:]
+		} else {
+			evicted = active[limit:]
+			active = active[:limit]
+		}
+	}
+
+	for _, session := range append(stale, evicted...) {
+		if session.Id == 0 {
+			continue
+		}
+		if err = tx.Delete(model.ClientSession{}, session.Id).Error; err != nil {
+			return nil, err
+		}
+	}
+	for _, session := range active {
+		if err = tx.Save(session).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	evictedIps := make([]string, 0, len(evicted))
+	for _, session := range evicted {
+		evictedIps = append(evictedIps, session.Ip)
+	}
+	return evictedIps, nil
+}
+
+// GetSessions returns the tracked device sessions of a client, oldest first.
+func (s *DeviceLimitService) GetSessions(email string) ([]*model.ClientSession, error) {
+	db := database.GetDB()
+	var sessions []*model.ClientSession
+	err := db.Model(model.ClientSession{}).Where("email = ?", email).Order("first_seen asc, id asc").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// ClearSessions forgets the tracked device sessions of a client.
+func (s *DeviceLimitService) ClearSessions(email string) error {
+	db := database.GetDB()
+	return db.Where("email = ?", email).Delete(model.ClientSession{}).Error
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// FirewallBackend blocks and unblocks source IPs for the built-in IP limit enforcement.
+type FirewallBackend interface {
+	// Name returns the backend name stored with each ban.
+	Name() string
+	// Block drops traffic from ip. A positive duration lets the backend expire the block by itself.
+	Block(ip string, duration time.Duration) error
+	// Unblock lifts a block added by Block.
+	Unblock(ip string) error
+}
+
+// batchFirewall is a backend that applies blocks in batches: blocks only take effect once Flush is called.
+type batchFirewall interface {
+	// Flush applies the blocks added since the last call.
+	Flush() error
+}
+
+// commandRunner runs a system command and returns its combined output.
+type commandRunner func(name string, args ...string) ([]byte, error)
+
+func runCommand(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// newFirewallBackend returns the backend for a setting value.
+// It is a variable so that a fake backend can be swapped in.
+var newFirewallBackend = func(name string) (FirewallBackend, error) {
+	switch name {
+	case "xray":
+		return &xrayFirewall{}, nil
+	case "nftables":
+		return &nftFirewall{run: runCommand}, nil
+	case "iptables":
+		return &ipsetFirewall{run: runCommand}, nil
+	default:
+		return nil, common.NewError("unknown firewall backend:", name)
+	}
+}
+
+// ipBanRuleTag returns the routing rule tag used to block an IP through Xray.
+func ipBanRuleTag(ip string) string {
+	return "ipban-" + ip
+}
+
+// xrayFirewall blocks IPs with routing rules pushed through the Xray routing API.
+// Rules for active bans are also part of the generated config, so they survive restarts.
+// Blocks are batched, since every push rebuilds the whole router.
+type xrayFirewall struct {
+	xrayApi xray.XrayAPI
+	lock    sync.Mutex
+	pending bool
+}
+
+func (f *xrayFirewall) Name() string {
+	return "xray"
+}
+
+func (f *xrayFirewall) Block(ip string, duration time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pending = true
+	return nil
+}
+
+// Flush pushes the rules of every active Xray ban in one go.
+func (f *xrayFirewall) Flush() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.pending || p == nil || !p.IsRunning() {
+		f.pending = false
+		return nil
+	}
+	routing, err := ipBanRouting(p.GetConfig())
+	if err != nil {
+		return err
+	}
+	if err := f.xrayApi.Init(p.GetAPIPort()); err != nil {
+		return err
+	}
+	defer f.xrayApi.Close()
+	// An appended rule would only match after the rules of the config, so the whole rule set is replaced
+	if err := f.xrayApi.SetRoutingRules(routing); err != nil {
+		return err
+	}
+	f.pending = false
+	return nil
+}
+
+func (f *xrayFirewall) Unblock(ip string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if p == nil || !p.IsRunning() {
+		return nil
+	}
+	if err := f.xrayApi.Init(p.GetAPIPort()); err != nil {
+		return err
+	}
+	defer f.xrayApi.Close()
+	return f.xrayApi.RemoveRoutingRule(ipBanRuleTag(ip))
+}
+
+// ipBanRouting returns the routing of the running config with the rules of the active Xray bans ahead
+// of all other rules. Bans lifted since the start are left out, they were removed from the router.
+func ipBanRouting(config *xray.Config) ([]byte, error) {
+	routing := map[string]any{}
+	if len(config.RouterConfig) > 0 {
+		if err := json.Unmarshal(config.RouterConfig, &routing); err != nil {
+			return nil, err
+		}
+	}
+	db := database.GetDB()
+	var ips []string
+	err := db.Model(model.IpBan{}).Where("backend = ?", "xray").Order("id asc").Pluck("ip", &ips).Error
+	if err != nil {
+		return nil, err
+	}
+
+	blockTag := getBlockOutboundTag(config)
+	rules := make([]any, 0, len(ips))
+	for _, banned := range ips {
+		rules = append(rules, ipBanRule(banned, blockTag))
+	}
+	existing, _ := routing["rules"].([]any)
+	for _, rule := range existing {
+		if r, ok := rule.(map[string]any); ok {
+			if tag, _ := r["ruleTag"].(string); strings.HasPrefix(tag, ipBanRuleTag("")) {
+				continue
+			}
+		}
+		rules = append(rules, rule)
+	}
+	routing["rules"] = rules
+	return json.Marshal(routing)
+}
+
+// ipBanRule returns the routing rule sending traffic from ip to the block outbound.
+func ipBanRule(ip string, blockTag string) map[string]any {
+	return map[string]any{
+		"type":        "field",
+		"ruleTag":     ipBanRuleTag(ip),
+		"source":      []string{ip},
+		"outboundTag": blockTag,
+	}
+}
+
+// nftTable is the nftables table holding the banned IP sets.
+const nftTable = "x_ui_ipban"
+
+// nftFirewall blocks IPs through nftables sets with per-element timeouts.
+type nftFirewall struct {
+	run   commandRunner
+	ready bool
+}
+
+func (f *nftFirewall) Name() string {
+	return "nftables"
+}
+
+func (f *nftFirewall) ensure() error {
+	if f.ready {
+		return nil
+	}
+	if _, err := f.run("nft", "list", "table", "inet", nftTable); err != nil {
+		script := fmt.Sprintf("table inet %s { "+
+			"set banned_v4 { type ipv4_addr; flags timeout; }; "+
+			"set banned_v6 { type ipv6_addr; flags timeout; }; "+
+			"chain input { type filter hook input priority -1; policy accept; "+
+			"ip saddr @banned_v4 drop; ip6 saddr @banned_v6 drop; } }", nftTable)
+		if out, err := f.run("nft", script); err != nil {
+			return common.NewErrorf("nft setup failed: %v %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	f.ready = true
+	return nil
+}
+
+func (f *nftFirewall) Block(ip string, duration time.Duration) error {
+	if err := f.ensure(); err != nil {
+		return err
+	}
+	element := ip
+	if duration > 0 {
+		element += fmt.Sprintf(" timeout %ds", int64(duration.Seconds()))
+	}
+	out, err := f.run("nft", "add", "element", "inet", nftTable, nftSet(ip), "{ "+element+" }")
+	if err != nil {
+		return common.NewErrorf("nft block %s failed: %v %s", ip, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (f *nftFirewall) Unblock(ip string) error {
+	if err := f.ensure(); err != nil {
+		return err
+	}
+	out, err := f.run("nft", "delete", "element", "inet", nftTable, nftSet(ip), "{ "+ip+" }")
+	if err != nil && !strings.Contains(string(out), "No such file") {
+		return common.NewErrorf("nft unblock %s failed: %v %s", ip, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func nftSet(ip string) string {
+	if isIPv6(ip) {
+		return "banned_v6"
+	}
+	return "banned_v4"
+}
+
+// Names of the ipset sets used by the iptables backend.
+const (
+	ipsetName  = "x-ui-ipban"
+	ipset6Name = "x-ui-ipban6"
+)
+
+// ipsetFirewall blocks IPs through ipset sets referenced by iptables and ip6tables DROP rules.
+type ipsetFirewall struct {
+	run   commandRunner
+	ready bool
+}
+
+func (f *ipsetFirewall) Name() string {
+	return "iptables"
+}
+
+func (f *ipsetFirewall) ensure() error {
+	if f.ready {
+		return nil
+	}
+	sets := []struct {
+		name     string
+		family   string
+		iptables string
+	}{
+		{ipsetName, "inet", "iptables"},
+		{ipset6Name, "inet6", "ip6tables"},
+	}
+	for _, set := range sets {
+		out, err := f.run("ipset", "create", set.name, "hash:ip", "family", set.family, "timeout", "0", "-exist")
+		if err != nil {
+			return common.NewErrorf("ipset setup failed: %v %s", err, strings.TrimSpace(string(out)))
+		}
+		rule := []string{"INPUT", "-m", "set", "--match-set", set.name, "src", "-j", "DROP"}
+		if _, err := f.run(set.iptables, append([]string{"-C"}, rule...)...); err != nil {
+			out, err := f.run(set.iptables, append([]string{"-I"}, rule...)...)
+			if err != nil {
+				return common.NewErrorf("%s setup failed: %v %s", set.iptables, err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	f.ready = true
+	return nil
+}
+
+func (f *ipsetFirewall) Block(ip string, duration time.Duration) error {
+	if err := f.ensure(); err != nil {
+		return err
+	}
+	timeout := "0"
+	if duration > 0 {
+		timeout = fmt.Sprint(int64(duration.Seconds()))
+	}
+	out, err := f.run("ipset", "add", ipsetFor(ip), ip, "timeout", timeout, "-exist")
+	if err != nil {
+		return common.NewErrorf("ipset block %s failed: %v %s", ip, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (f *ipsetFirewall) Unblock(ip string) error {
+	if err := f.ensure(); err != nil {
+		return err
+	}
+	out, err := f.run("ipset", "del", ipsetFor(ip), ip, "-exist")
+	if err != nil {
+		return common.NewErrorf("ipset unblock %s failed: %v %s", ip, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func ipsetFor(ip string) string {
+	if isIPv6(ip) {
+		return ipset6Name
+	}
+	return ipsetName
+}
+
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() == nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"github.com/xtls/xray-core/app/router"
+	"google.golang.org/protobuf/proto"
+)
+
+// geoipRange is a range of addresses belonging to a country.
+type geoipRange struct {
+	first   netip.Addr
+	last    netip.Addr
+	country string
+}
+
+// geoipTable looks up the country of an IP in the geoip.dat file downloaded for Xray.
+// The file is read once and read again only when it changes on disk.
+type geoipTable struct {
+	lock    sync.Mutex
+	modTime time.Time
+	ranges  []geoipRange
+}
+
+var countryTable = &geoipTable{}
+
+// lookup returns the upper case country code of ip, or an empty string when it is unknown.
+func (t *geoipTable) lookup(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if err := t.load(); err != nil {
+		return ""
+	}
+	i := sort.Search(len(t.ranges), func(i int) bool {
+		return t.ranges[i].first.Compare(addr) > 0
+	})
+	if i == 0 {
+		return ""
+	}
+	r := t.ranges[i-1]
+	if r.last.Compare(addr) < 0 {
+		return ""
+	}
+	return r.country
+}
+
+func (t *geoipTable) load() error {
+	path := xray.GetGeoipPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if t.ranges != nil && info.ModTime().Equal(t.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	list := &router.GeoIPList{}
+	if err := proto.Unmarshal(data, list); err != nil {
+		return err
+	}
+
+	var ranges []geoipRange
+	for _, entry := range list.GetEntry() {
+		// Besides countries the file holds lists such as private or cloudflare, skip them
+		country := entry.GetCountryCode()
+		if len(country) != 2 || entry.GetReverseMatch() {
+			continue
+		}
+		for _, cidr := range entry.GetCidr() {
+			addr, ok := netip.AddrFromSlice(cidr.GetIp())
+			if !ok {
+				continue
+			}
+			prefix, err := addr.Prefix(int(cidr.GetPrefix()))
+			if err != nil {
+				continue
+			}
+			ranges = append(ranges, geoipRange{
+				first:   prefix.Addr(),
+				last:    lastAddr(prefix),
+				country: country,
+			})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].first.Compare(ranges[j].first) < 0
+	})
+	t.ranges = ranges
+	t.modTime = info.ModTime()
+	return nil
+}
+
+// lastAddr returns the last address of a masked prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 1 << (7 - bit%8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
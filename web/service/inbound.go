@@ -5,6 +5,7 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,13 +18,15 @@ import (
 	"github.com/agassiz/3x-ui/v2/xray"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // InboundService provides business logic for managing Xray inbound configurations.
 // It handles CRUD operations for inbounds, client management, traffic monitoring,
 // and integration with the Xray API for real-time updates.
 type InboundService struct {
-	xrayApi xray.XrayAPI
+	xrayApi        xray.XrayAPI
+	settingService SettingService
 }
 
 // GetInbounds retrieves all inbounds for a specific user.
@@ -54,6 +57,15 @@ func (s *InboundService) GetInbounds(userId int) ([]*model.Inbound, error) {
 			}
 		}
 	}
+	var traffics []*xray.ClientTraffic
+	for _, inbound := range inbounds {
+		for i := range inbound.ClientStats {
+			traffics = append(traffics, &inbound.ClientStats[i])
+		}
+	}
+	if err := s.FillForecasts(traffics); err != nil {
+		logger.Warning("Unable to forecast client usage:", err)
+	}
 	return inbounds, nil
 }
 
@@ -141,6 +153,40 @@ func (s *InboundService) GetClients(inbound *model.Inbound) ([]model.Client, err
 	return clients, nil
 }
 
+// clientExtraKeys lists client settings managed outside the inbound form.
+// The panel rebuilds clients from a fixed field list, so these keys are
+// carried over from the stored client when an update omits them.
+var clientExtraKeys = []string{
+	"egressOutbound",
+	"egressBalancer",
+	"limitDevice",
+	"planId",
+	"speedUp",
+	"speedDown",
+	"softQuotaGB",
+	"trafficMultiplier",
+	"poolId",
+	"resetSchedule",
+	"resetAnchor",
+	"accessSchedule",
+	"rotateDays",
+	"rotatedAt",
+	"oldCredential",
+	"oldCredentialUntil",
+}
+
+// preserveClientExtraKeys copies extra keys missing from newClient over from oldClient.
+func preserveClientExtraKeys(oldClient map[string]any, newClient map[string]any) {
+	for _, key := range clientExtraKeys {
+		if _, ok := newClient[key]; ok {
+			continue
+		}
+		if v, ok := oldClient[key]; ok {
+			newClient[key] = v
+		}
+	}
+}
+
 func (s *InboundService) getAllEmails() ([]string, error) {
 	db := database.GetDB()
 	var emails []string
@@ -314,6 +360,9 @@ func (s *InboundService) AddInbound(inbound *model.Inbound) (*model.Inbound, boo
 			needRestart = true
 		}
 		s.xrayApi.Close()
+		if egressSignature(inbound) != "" {
+			needRestart = true
+		}
 	}
 
 	return inbound, needRestart, err
@@ -360,6 +409,13 @@ func (s *InboundService) DelInbound(id int) (bool, error) {
 		if err != nil {
 			return false, err
 		}
+		err = s.DelClientTrafficHistory(db, client.Email)
+		if err != nil {
+			return false, err
+		}
+	}
+	if egressSignature(inbound) != "" {
+		needRestart = true
 	}
 
 	return needRestart, db.Delete(model.Inbound{}, id).Error
@@ -393,6 +449,7 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	}
 
 	tag := oldInbound.Tag
+	oldEgress := egressSignature(oldInbound)
 
 	db := database.GetDB()
 	tx := db.Begin()
@@ -416,11 +473,13 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 		_ = json.Unmarshal([]byte(oldInbound.Settings), &oldSettings)
 		emailToCreated := map[string]int64{}
 		emailToUpdated := map[string]int64{}
+		emailToClient := map[string]map[string]any{}
 		if oldSettings != nil {
 			if oc, ok := oldSettings["clients"].([]any); ok {
 				for _, it := range oc {
 					if m, ok2 := it.(map[string]any); ok2 {
 						if email, ok3 := m["email"].(string); ok3 {
+							emailToClient[email] = m
 							switch v := m["created_at"].(type) {
 							case float64:
 								emailToCreated[email] = int64(v)
@@ -458,6 +517,9 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 								m["updated_at"] = v
 							}
 						}
+						if old, ok4 := emailToClient[email]; ok4 {
+							preserveClientExtraKeys(old, m)
+						}
 						nSlice[i] = m
 					}
 				}
@@ -482,13 +544,19 @@ func (s *InboundService) UpdateInbound(inbound *model.Inbound) (*model.Inbound,
 	oldInbound.Settings = inbound.Settings
 	oldInbound.StreamSettings = inbound.StreamSettings
 	oldInbound.Sniffing = inbound.Sniffing
+	oldInbound.EgressOutbound = inbound.EgressOutbound
+	oldInbound.EgressBalancer = inbound.EgressBalancer
+	oldInbound.SpeedUp = inbound.SpeedUp
+	oldInbound.SpeedDown = inbound.SpeedDown
+	oldInbound.TrafficMultiplier = inbound.TrafficMultiplier
 	if inbound.Listen == "" || inbound.Listen == "0.0.0.0" || inbound.Listen == "::" || inbound.Listen == "::0" {
 		oldInbound.Tag = fmt.Sprintf("inbound-%v", inbound.Port)
 	} else {
 		oldInbound.Tag = fmt.Sprintf("inbound-%v:%v", inbound.Listen, inbound.Port)
 	}
 
-	needRestart := false
+	// Egress routing rules are only applied on restart
+	needRestart := oldEgress != egressSignature(oldInbound)
 	s.xrayApi.Init(p.GetAPIPort())
 	if s.xrayApi.DelInbound(tag) == nil {
 		logger.Debug("Old inbound deleted by api:", tag)
@@ -538,6 +606,10 @@ func (s *InboundService) updateClientTraffics(tx *gorm.DB, oldInbound *model.Inb
 			if err != nil {
 				return err
 			}
+			err = s.DelClientTrafficHistory(tx, oldClient.Email)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	for _, newClient := range newClients {
@@ -597,6 +669,12 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 
 	// Secure client ID
 	for _, client := range clients {
+		if err := checkClientResetSchedule(&client); err != nil {
+			return false, err
+		}
+		if _, err := parseAccessSchedule(client.AccessSchedule); err != nil {
+			return false, err
+		}
 		switch oldInbound.Protocol {
 		case "trojan":
 			if client.Password == "" {
@@ -667,6 +745,9 @@ func (s *InboundService) AddInboundClient(data *model.Inbound) (bool, error) {
 					needRestart = true
 				}
 			}
+			if clientHasEgress(client) {
+				needRestart = true
+			}
 		} else {
 			needRestart = true
 		}
@@ -700,12 +781,14 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 	interfaceClients := settings["clients"].([]any)
 	var newClients []any
 	needApiDel := false
+	hasEgress := false
 	for _, client := range interfaceClients {
 		c := client.(map[string]any)
 		c_id := c[client_key].(string)
 		if c_id == clientId {
 			email, _ = c["email"].(string)
 			needApiDel, _ = c["enable"].(bool)
+			hasEgress = !clientEgressFromMap(c).isEmpty()
 		} else {
 			newClients = append(newClients, client)
 		}
@@ -744,6 +827,10 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 			logger.Error("Delete stats Data Error")
 			return false, err
 		}
+		err = s.DelClientTrafficHistory(db, email)
+		if err != nil {
+			return false, err
+		}
 		if needApiDel && notDepleted {
 			s.xrayApi.Init(p.GetAPIPort())
 			err1 := s.xrayApi.RemoveUser(oldInbound.Tag, email)
@@ -760,6 +847,9 @@ func (s *InboundService) DelInboundClient(inboundId int, clientId string) (bool,
 			}
 			s.xrayApi.Close()
 		}
+		if hasEgress {
+			needRestart = true
+		}
 	}
 	return needRestart, db.Save(oldInbound).Error
 }
@@ -816,6 +906,12 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	if newClientId == "" || clientIndex == -1 {
 		return false, common.NewError("empty client ID")
 	}
+	if err := checkClientResetSchedule(&clients[0]); err != nil {
+		return false, err
+	}
+	if _, err := parseAccessSchedule(clients[0].AccessSchedule); err != nil {
+		return false, err
+	}
 
 	if len(clients[0].Email) > 0 && clients[0].Email != oldEmail {
 		existEmail, err := s.checkEmailsExistForClients(clients)
@@ -835,13 +931,16 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 	settingsClients := oldSettings["clients"].([]any)
 	// Preserve created_at and set updated_at for the replacing client
 	var preservedCreated any
+	oldMap := map[string]any{}
 	if clientIndex >= 0 && clientIndex < len(settingsClients) {
-		if oldMap, ok := settingsClients[clientIndex].(map[string]any); ok {
+		if m, ok := settingsClients[clientIndex].(map[string]any); ok {
+			oldMap = m
 			if v, ok2 := oldMap["created_at"]; ok2 {
 				preservedCreated = v
 			}
 		}
 	}
+	egressChanged := false
 	if len(interfaceClients) > 0 {
 		if newMap, ok := interfaceClients[0].(map[string]any); ok {
 			if preservedCreated == nil {
@@ -849,6 +948,13 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			}
 			newMap["created_at"] = preservedCreated
 			newMap["updated_at"] = time.Now().Unix() * 1000
+			preserveClientExtraKeys(oldMap, newMap)
+			// Keys kept from the old client must reach the client stats too
+			if data, err := json.Marshal(newMap); err == nil {
+				json.Unmarshal(data, &clients[0])
+			}
+			oldRoute, newRoute := clientEgressFromMap(oldMap), clientEgressFromMap(newMap)
+			egressChanged = oldRoute != newRoute || !newRoute.isEmpty() && clients[0].Email != oldEmail
 			interfaceClients[0] = newMap
 		}
 	}
@@ -874,7 +980,9 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 
 	if len(clients[0].Email) > 0 {
 		if len(oldEmail) > 0 {
-			err = s.UpdateClientStat(tx, oldEmail, &clients[0])
+			stat := clients[0]
+			stat.TotalGB = s.getCycleTotal(tx, oldEmail, &oldClients[clientIndex], &clients[0])
+			err = s.UpdateClientStat(tx, oldEmail, &stat)
 			if err != nil {
 				return false, err
 			}
@@ -882,6 +990,10 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 			if err != nil {
 				return false, err
 			}
+			err = s.UpdateClientTrafficHistory(tx, oldEmail, clients[0].Email)
+			if err != nil {
+				return false, err
+			}
 		} else {
 			s.AddClientStat(tx, data.Id, &clients[0])
 		}
@@ -936,6 +1048,9 @@ func (s *InboundService) UpdateInboundClient(data *model.Inbound, clientId strin
 		logger.Debug("Client old email not found")
 		needRestart = true
 	}
+	if egressChanged {
+		needRestart = true
+	}
 	return needRestart, tx.Save(oldInbound).Error
 }
 
@@ -980,7 +1095,8 @@ func (s *InboundService) AddTraffic(inboundTraffics []*xray.Traffic, clientTraff
 	} else if count > 0 {
 		logger.Debugf("%v inbounds disabled", count)
 	}
-	return nil, (needRestart0 || needRestart1 || needRestart2)
+	// A failed step rolls the transaction back, report it so that the traffic is kept for the next flush
+	return err, (needRestart0 || needRestart1 || needRestart2)
 }
 
 func (s *InboundService) addInboundTraffic(tx *gorm.DB, traffics []*xray.Traffic) error {
@@ -1008,15 +1124,9 @@ func (s *InboundService) addInboundTraffic(tx *gorm.DB, traffics []*xray.Traffic
 
 func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTraffic) (err error) {
 	if len(traffics) == 0 {
-		// Empty onlineUsers
-		if p != nil {
-			p.SetOnlineClients(nil)
-		}
 		return nil
 	}
 
-	var onlineClients []string
-
 	emails := make([]string, 0, len(traffics))
 	for _, traffic := range traffics {
 		emails = append(emails, traffic.Email)
@@ -1037,34 +1147,117 @@ func (s *InboundService) addClientTraffic(tx *gorm.DB, traffics []*xray.ClientTr
 		return err
 	}
 
-	for dbTraffic_index := range dbClientTraffics {
-		for traffic_index := range traffics {
-			if dbClientTraffics[dbTraffic_index].Email == traffics[traffic_index].Email {
-				dbClientTraffics[dbTraffic_index].Up += traffics[traffic_index].Up
-				dbClientTraffics[dbTraffic_index].Down += traffics[traffic_index].Down
-				dbClientTraffics[dbTraffic_index].AllTime += (traffics[traffic_index].Up + traffics[traffic_index].Down)
+	inboundIds := make([]int, 0, len(dbClientTraffics))
+	for _, dbClientTraffic := range dbClientTraffics {
+		inboundIds = append(inboundIds, dbClientTraffic.InboundId)
+	}
+	multipliers, err := s.getTrafficMultipliers(tx, inboundIds)
+	if err != nil {
+		return err
+	}
 
-				// Add user in onlineUsers array on traffic
-				if traffics[traffic_index].Up+traffics[traffic_index].Down > 0 {
-					onlineClients = append(onlineClients, traffics[traffic_index].Email)
-					dbClientTraffics[dbTraffic_index].LastOnline = time.Now().UnixMilli()
-				}
-				break
+	pending := make(map[string]*xray.ClientTraffic, len(traffics))
+	for _, traffic := range traffics {
+		pending[traffic.Email] = traffic
+	}
+	deltas := make([]*xray.ClientTraffic, 0, len(dbClientTraffics))
+	for _, dbClientTraffic := range dbClientTraffics {
+		traffic, ok := pending[dbClientTraffic.Email]
+		if !ok {
+			continue
+		}
+		// Quotas count multiplied traffic, raw bytes are kept for reporting
+		multiplier := multipliers[traffic.Email]
+		delta := &xray.ClientTraffic{
+			InboundId:  dbClientTraffic.InboundId,
+			Enable:     dbClientTraffic.Enable,
+			Email:      dbClientTraffic.Email,
+			Up:         applyTrafficMultiplier(traffic.Up, multiplier),
+			Down:       applyTrafficMultiplier(traffic.Down, multiplier),
+			RawUp:      traffic.Up,
+			RawDown:    traffic.Down,
+			AllTime:    traffic.Up + traffic.Down,
+			ExpiryTime: dbClientTraffic.ExpiryTime,
+			Total:      dbClientTraffic.Total,
+		}
+		// Online clients are tracked when traffic is accumulated, keep the time it was seen
+		if traffic.Up+traffic.Down > 0 {
+			delta.LastOnline = traffic.LastOnline
+			if delta.LastOnline == 0 {
+				delta.LastOnline = time.Now().UnixMilli()
 			}
 		}
+		deltas = append(deltas, delta)
 	}
 
-	// Set onlineUsers
-	p.SetOnlineClients(onlineClients)
-
-	err = tx.Save(dbClientTraffics).Error
+	// Increment in SQL so that limits and states saved meanwhile are kept. Expiry times that start
+	// on first use were fixed by adjustTraffics and are the only ones taken from the deltas.
+	err = tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "email"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"up":          gorm.Expr("client_traffics.up + excluded.up"),
+			"down":        gorm.Expr("client_traffics.down + excluded.down"),
+			"raw_up":      gorm.Expr("client_traffics.raw_up + excluded.raw_up"),
+			"raw_down":    gorm.Expr("client_traffics.raw_down + excluded.raw_down"),
+			"all_time":    gorm.Expr("COALESCE(client_traffics.all_time, 0) + excluded.all_time"),
+			"last_online": gorm.Expr("MAX(COALESCE(client_traffics.last_online, 0), excluded.last_online)"),
+			"expiry_time": gorm.Expr("CASE WHEN client_traffics.expiry_time < 0 THEN excluded.expiry_time ELSE client_traffics.expiry_time END"),
+		}),
+	}).CreateInBatches(deltas, 100).Error
 	if err != nil {
 		logger.Warning("AddClientTraffic update data ", err)
+		return err
+	}
+
+	err = s.addClientTrafficHistory(tx, traffics, dbClientTraffics)
+	if err != nil {
+		logger.Warning("AddClientTraffic update history ", err)
+		return err
 	}
 
 	return nil
 }
 
+// getTrafficMultipliers returns the effective traffic multiplier of the clients of the given inbounds.
+// A client's own multiplier wins over its inbound's. Clients counted as is are left out.
+func (s *InboundService) getTrafficMultipliers(tx *gorm.DB, inboundIds []int) (map[string]float64, error) {
+	multipliers := map[string]float64{}
+	if len(inboundIds) == 0 {
+		return multipliers, nil
+	}
+	var inbounds []*model.Inbound
+	err := tx.Model(model.Inbound{}).
+		Where("id IN (?) AND (traffic_multiplier != 1 OR settings LIKE ?)", inboundIds, "%trafficMultiplier%").
+		Find(&inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, inbound := range inbounds {
+		clients, err := s.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			multiplier := client.TrafficMultiplier
+			if multiplier <= 0 {
+				multiplier = inbound.TrafficMultiplier
+			}
+			if multiplier > 0 && multiplier != 1 {
+				multipliers[client.Email] = multiplier
+			}
+		}
+	}
+	return multipliers, nil
+}
+
+// applyTrafficMultiplier returns bytes scaled by multiplier. A multiplier of 0 counts bytes as is.
+func applyTrafficMultiplier(bytes int64, multiplier float64) int64 {
+	if multiplier <= 0 || multiplier == 1 {
+		return bytes
+	}
+	return int64(math.Round(float64(bytes) * multiplier))
+}
+
 func (s *InboundService) adjustTraffics(tx *gorm.DB, dbClientTraffics []*xray.ClientTraffic) ([]*xray.ClientTraffic, error) {
 	inboundIds := make([]int, 0, len(dbClientTraffics))
 	for _, dbClientTraffic := range dbClientTraffics {
@@ -1154,6 +1347,14 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 	if err != nil {
 		return false, 0, err
 	}
+	var emails []string
+	for _, traffic := range traffics {
+		emails = append(emails, traffic.Email)
+	}
+	err = s.archiveClientUsage(tx, resetReasonRenew, "email IN ?", emails)
+	if err != nil {
+		return false, 0, err
+	}
 	for inbound_index := range inbounds {
 		settings := map[string]any{}
 		json.Unmarshal([]byte(inbounds[inbound_index].Settings), &settings)
@@ -1170,6 +1371,11 @@ func (s *InboundService) autoRenewClients(tx *gorm.DB) (bool, int64, error) {
 					traffics[traffic_index].ExpiryTime = newExpiryTime
 					traffics[traffic_index].Down = 0
 					traffics[traffic_index].Up = 0
+					traffics[traffic_index].RawDown = 0
+					traffics[traffic_index].RawUp = 0
+					traffics[traffic_index].LastReset = now
+					traffics[traffic_index].Stage = ""
+					traffics[traffic_index].StageTime = 0
 					if !traffic.Enable {
 						traffics[traffic_index].Enable = true
 						clientsToAdd = append(clientsToAdd,
@@ -1257,6 +1463,26 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 	now := time.Now().Unix() * 1000
 	needRestart := false
 
+	// Members of an exhausted quota pool are disabled together
+	poolIds, err := s.getExhaustedPoolIds(tx)
+	if err != nil {
+		return false, 0, err
+	}
+	poolCondition := ""
+	if len(poolIds) > 0 {
+		poolCondition = " OR client_traffics.pool_id IN (?)"
+	}
+	condition := "((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)" + poolCondition + ") AND client_traffics.enable = ?"
+	args := []any{now, true}
+	if len(poolIds) > 0 {
+		args = []any{now, poolIds, true}
+	}
+	// With a grace period, clients are only disabled once it is over
+	if grace := s.getGracePeriod(); grace > 0 {
+		condition += " AND client_traffics.stage = ? AND client_traffics.stage_time <= ?"
+		args = append(args, ClientStageGrace, now-grace.Milliseconds())
+	}
+
 	if p != nil {
 		var results []struct {
 			Tag   string
@@ -1266,7 +1492,7 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 		err := tx.Table("inbounds").
 			Select("inbounds.tag, client_traffics.email").
 			Joins("JOIN client_traffics ON inbounds.id = client_traffics.inbound_id").
-			Where("((client_traffics.total > 0 AND client_traffics.up + client_traffics.down >= client_traffics.total) OR (client_traffics.expiry_time > 0 AND client_traffics.expiry_time <= ?)) AND client_traffics.enable = ?", now, true).
+			Where(condition, args...).
 			Scan(&results).Error
 		if err != nil {
 			return false, 0, err
@@ -1292,9 +1518,9 @@ func (s *InboundService) disableInvalidClients(tx *gorm.DB) (bool, int64, error)
 		s.xrayApi.Close()
 	}
 	result := tx.Model(xray.ClientTraffic{}).
-		Where("((total > 0 and up + down >= total) or (expiry_time > 0 and expiry_time <= ?)) and enable = ?", now, true).
-		Update("enable", false)
-	err := result.Error
+		Where(condition, args...).
+		Updates(map[string]any{"enable": false, "stage": ClientStageDisabled, "stage_time": now})
+	err = result.Error
 	count := result.RowsAffected
 	return needRestart, count, err
 }
@@ -1332,6 +1558,7 @@ func (s *InboundService) AddClientStat(tx *gorm.DB, inboundId int, client *model
 	clientTraffic.Up = 0
 	clientTraffic.Down = 0
 	clientTraffic.Reset = client.Reset
+	clientTraffic.PoolId = client.PoolId
 	result := tx.Create(&clientTraffic)
 	err := result.Error
 	return err
@@ -1346,8 +1573,12 @@ func (s *InboundService) UpdateClientStat(tx *gorm.DB, email string, client *mod
 			"total":       client.TotalGB,
 			"expiry_time": client.ExpiryTime,
 			"reset":       client.Reset,
+			"pool_id":     client.PoolId,
 		})
 	err := result.Error
+	if err == nil && client.Enable {
+		err = clearDisabledStage(tx, "email = ?", client.Email)
+	}
 	return err
 }
 
@@ -1768,20 +1999,35 @@ func (s *InboundService) ResetClientTrafficLimitByEmail(clientEmail string, tota
 func (s *InboundService) ResetClientTrafficByEmail(clientEmail string) error {
 	db := database.GetDB()
 
-	// Reset traffic stats in ClientTraffic table
-	result := db.Model(xray.ClientTraffic{}).
-		Where("email = ?", clientEmail).
-		Updates(map[string]any{"enable": true, "up": 0, "down": 0})
-
-	err := result.Error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := s.archiveClientUsage(tx, resetReasonManual, "email = ?", clientEmail)
+		if err != nil {
+			return err
+		}
+		// Reset traffic stats in ClientTraffic table
+		return tx.Model(xray.ClientTraffic{}).
+			Where("email = ?", clientEmail).
+			Updates(map[string]any{"enable": true, "up": 0, "down": 0, "raw_up": 0, "raw_down": 0, "stage": "", "stage_time": 0}).Error
+	})
 	if err != nil {
 		return err
 	}
+	s.DiscardPendingClientTraffic(clientEmail)
 
 	return nil
 }
 
+// ResetClientTraffic archives the usage of a client, resets its traffic and enables it again.
 func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, error) {
+	err := s.archiveClientUsage(database.GetDB(), resetReasonManual, "email = ?", clientEmail)
+	if err != nil {
+		return false, err
+	}
+	return s.resetClientTraffic(id, clientEmail)
+}
+
+// resetClientTraffic resets the traffic of a client and enables it again without archiving its usage.
+func (s *InboundService) resetClientTraffic(id int, clientEmail string) (bool, error) {
 	needRestart := false
 
 	traffic, err := s.GetClientTrafficByEmail(clientEmail)
@@ -1832,18 +2078,29 @@ func (s *InboundService) ResetClientTraffic(id int, clientEmail string) (bool, e
 
 	traffic.Up = 0
 	traffic.Down = 0
+	traffic.RawUp = 0
+	traffic.RawDown = 0
 	traffic.Enable = true
+	traffic.Stage = ""
+	traffic.StageTime = 0
 
 	db := database.GetDB()
 	err = db.Save(traffic).Error
 	if err != nil {
 		return false, err
 	}
+	s.DiscardPendingClientTraffic(clientEmail)
 
 	return needRestart, nil
 }
 
+// ResetAllClientTraffics archives the usage of the clients of an inbound, or of all inbounds when id is -1,
+// and resets their traffic.
 func (s *InboundService) ResetAllClientTraffics(id int) error {
+	return s.resetAllClientTraffics(id, resetReasonManual)
+}
+
+func (s *InboundService) resetAllClientTraffics(id int, reason string) error {
 	db := database.GetDB()
 	now := time.Now().Unix() * 1000
 
@@ -1855,14 +2112,25 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 			whereText += " = ?"
 		}
 
+		var emails []string
+		err := tx.Model(xray.ClientTraffic{}).Where(whereText, id).Pluck("email", &emails).Error
+		if err != nil {
+			return err
+		}
+		err = s.archiveClientUsage(tx, reason, whereText, id)
+		if err != nil {
+			return err
+		}
+
 		// Reset client traffics
 		result := tx.Model(xray.ClientTraffic{}).
 			Where(whereText, id).
-			Updates(map[string]any{"enable": true, "up": 0, "down": 0})
+			Updates(map[string]any{"enable": true, "up": 0, "down": 0, "raw_up": 0, "raw_down": 0, "stage": "", "stage_time": 0})
 
 		if result.Error != nil {
 			return result.Error
 		}
+		s.DiscardPendingClientTraffic(emails...)
 
 		// Update lastTrafficResetTime for the inbound(s)
 		inboundWhereText := "id "
@@ -1880,14 +2148,23 @@ func (s *InboundService) ResetAllClientTraffics(id int) error {
 	})
 }
 
+// ResetAllTraffics archives the usage of every inbound and resets their traffic.
 func (s *InboundService) ResetAllTraffics() error {
 	db := database.GetDB()
+	now := time.Now().UnixMilli()
 
-	result := db.Model(model.Inbound{}).
-		Where("user_id > ?", 0).
-		Updates(map[string]any{"up": 0, "down": 0})
-
-	err := result.Error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := s.archiveInboundUsage(tx, resetReasonManual, "user_id > ?", 0)
+		if err != nil {
+			return err
+		}
+		return tx.Model(model.Inbound{}).
+			Where("user_id > ?", 0).
+			Updates(map[string]any{"up": 0, "down": 0, "last_traffic_reset_time": now}).Error
+	})
+	if err == nil {
+		s.DiscardPendingInboundTraffic()
+	}
 	return err
 }
 
@@ -1923,6 +2200,11 @@ func (s *InboundService) DelDepletedClients(id int) (err error) {
 
 	for _, depletedClient := range depletedClients {
 		emails := strings.Split(depletedClient.Email, ",")
+		for _, email := range emails {
+			if err = s.DelClientTrafficHistory(tx, email); err != nil {
+				return err
+			}
+		}
 		oldInbound, err := s.GetInbound(depletedClient.InboundId)
 		if err != nil {
 			return err
@@ -2196,6 +2478,15 @@ func (s *InboundService) MigrationRequirements() {
 		SET all_time = IFNULL(up, 0) + IFNULL(down, 0)
 		WHERE IFNULL(all_time, 0) = 0 AND (IFNULL(up, 0) + IFNULL(down, 0)) > 0
 	`).Error
+	if err != nil {
+		return
+	}
+	// Traffic stored before multipliers existed was counted as is
+	err = tx.Exec(`
+		UPDATE client_traffics
+		SET raw_up = IFNULL(up, 0), raw_down = IFNULL(down, 0)
+		WHERE IFNULL(raw_up, 0) = 0 AND IFNULL(raw_down, 0) = 0 AND (IFNULL(up, 0) + IFNULL(down, 0)) > 0
+	`).Error
 
 	if err != nil {
 		return
@@ -2398,6 +2689,7 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 
 	var newClients []any
 	needApiDel := false
+	hasEgress := false
 	found := false
 
 	for _, client := range interfaceClients {
@@ -2409,6 +2701,7 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 			// matched client, drop it
 			found = true
 			needApiDel, _ = c["enable"].(bool)
+			hasEgress = !clientEgressFromMap(c).isEmpty()
 		} else {
 			newClients = append(newClients, client)
 		}
@@ -2451,6 +2744,9 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 				return false, err
 			}
 		}
+		if err := s.DelClientTrafficHistory(db, email); err != nil {
+			return false, err
+		}
 
 		if needApiDel {
 			s.xrayApi.Init(p.GetAPIPort())
@@ -2468,6 +2764,9 @@ func (s *InboundService) DelInboundClientByEmail(inboundId int, email string) (b
 			s.xrayApi.Close()
 		}
 	}
+	if hasEgress {
+		needRestart = true
+	}
 
 	return needRestart, db.Save(oldInbound).Error
 }
@@ -0,0 +1,410 @@
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/util/random"
+
+	"gorm.io/gorm"
+)
+
+// inviteEmailPrefix starts the email of every client created from an invite code.
+const inviteEmailPrefix = "invite-"
+
+var (
+	// inviteLock serializes redemptions so that the use limits hold under concurrent requests.
+	inviteLock sync.Mutex
+
+	ErrInviteInvalid  = common.NewError("the invite code is not valid")
+	ErrInviteRedeemed = common.NewError("an invite code was already redeemed")
+	ErrInviteDisabled = common.NewError("clients cannot create invite codes")
+	ErrInviteClosed   = common.NewError("invite codes cannot be redeemed")
+	ErrInviteCapped   = common.NewError("the daily invite limit is reached")
+)
+
+// ReferralNode is a client in the referral tree with the clients that signed up with its codes.
+type ReferralNode struct {
+	Email     string          `json:"email"`
+	Code      string          `json:"code,omitempty"`      // Code the client signed up with, empty for clients added by an admin
+	CreatedAt int64           `json:"createdAt,omitempty"` // Signup timestamp
+	Total     int             `json:"total"`               // Clients referred directly or indirectly
+	Referrals []*ReferralNode `json:"referrals"`
+}
+
+// InviteService manages invite codes. Redeeming a code creates a client linked to the client that
+// shared it, who gets the referral bonus set in the settings.
+type InviteService struct {
+	inboundService InboundService
+	settingService SettingService
+	planService    PlanService
+}
+
+// GetInviteCodes returns every invite code, latest first.
+func (s *InviteService) GetInviteCodes() ([]*model.InviteCode, error) {
+	db := database.GetDB()
+	codes := make([]*model.InviteCode, 0)
+	if err := db.Model(model.InviteCode{}).Order("id desc").Find(&codes).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// AddInviteCode stores a new invite code, generating the code itself when it is empty.
+func (s *InviteService) AddInviteCode(invite *model.InviteCode) (*model.InviteCode, error) {
+	if err := s.checkInviteCode(invite); err != nil {
+		return nil, err
+	}
+	invite.Id = 0
+	invite.Uses = 0
+	invite.CreatedAt = time.Now().Unix()
+	db := database.GetDB()
+	if err := db.Create(invite).Error; err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// DelInviteCode deletes an invite code. Clients that redeemed it keep their referral.
+func (s *InviteService) DelInviteCode(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.InviteCode{}, id).Error
+}
+
+func (s *InviteService) checkInviteCode(invite *model.InviteCode) error {
+	invite.Code = strings.TrimSpace(invite.Code)
+	if invite.Code == "" {
+		invite.Code = strings.ToUpper(random.Seq(8))
+	}
+	if invite.TotalGB < 0 || invite.Days < 0 || invite.MaxUses < 0 || invite.ExpiryTime < 0 {
+		return common.NewError("invite code limits are not valid:", invite.Code)
+	}
+	inbound, err := s.inboundService.GetInbound(invite.InboundId)
+	if err != nil {
+		return err
+	}
+	if credentialField(inbound.Protocol) == "" {
+		return common.NewError("inbound does not support clients:", inbound.Protocol)
+	}
+	if invite.PlanId > 0 {
+		if _, err := s.planService.GetPlan(invite.PlanId); err != nil {
+			return err
+		}
+	}
+	if invite.Referrer != "" {
+		if _, _, err := s.inboundService.GetClientByEmail(invite.Referrer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateClientInvite creates an invite code shared by a client. Redeemed clients are created on the
+// client's inbound with its plan, and the number of uses comes from the settings.
+func (s *InviteService) CreateClientInvite(email string) (*model.InviteCode, error) {
+	maxUses, err := s.settingService.GetInviteClientUses()
+	if err != nil {
+		return nil, err
+	}
+	if maxUses <= 0 {
+		return nil, ErrInviteDisabled
+	}
+	traffic, client, err := s.inboundService.GetClientByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	// A client keeps a single code, topped up once it is used up
+	invite := &model.InviteCode{}
+	db := database.GetDB()
+	err = db.Model(model.InviteCode{}).Where("referrer = ?", email).Order("id desc").First(invite).Error
+	if err == nil {
+		if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+			err = db.Model(invite).Update("max_uses", invite.Uses+maxUses).Error
+			invite.MaxUses = invite.Uses + maxUses
+		}
+		return invite, err
+	}
+	if !database.IsNotFound(err) {
+		return nil, err
+	}
+	trafficGB, err := s.settingService.GetInviteTrafficGB()
+	if err != nil {
+		return nil, err
+	}
+	days, err := s.settingService.GetInviteDays()
+	if err != nil {
+		return nil, err
+	}
+	return s.AddInviteCode(&model.InviteCode{
+		Referrer:  email,
+		InboundId: traffic.InboundId,
+		PlanId:    client.PlanId,
+		TotalGB:   int64(trafficGB),
+		Days:      days,
+		MaxUses:   maxUses,
+	})
+}
+
+// IsInviteEnabled reports whether invite codes can be redeemed.
+func (s *InviteService) IsInviteEnabled() bool {
+	enable, err := s.settingService.GetInviteEnable()
+	return err == nil && enable
+}
+
+// RedeemInvite creates a client from an invite code for a Telegram user or a web visitor, once per
+// Telegram user and IP and within the daily cap, and gives the referrer its bonus unless the code is
+// redeemed from the referrer's own address. Returns the client and whether Xray needs a restart.
+func (s *InviteService) RedeemInvite(code string, tgId int64, ip string) (*model.Client, bool, error) {
+	if !s.IsInviteEnabled() {
+		return nil, false, ErrInviteClosed
+	}
+	inviteLock.Lock()
+	defer inviteLock.Unlock()
+
+	ip = signupIpKey(ip)
+	db := database.GetDB()
+	invite := &model.InviteCode{}
+	err := db.Model(model.InviteCode{}).Where("code = ?", strings.TrimSpace(code)).First(invite).Error
+	if database.IsNotFound(err) {
+		return nil, false, ErrInviteInvalid
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+		return nil, false, ErrInviteInvalid
+	}
+	if invite.ExpiryTime > 0 && invite.ExpiryTime <= time.Now().UnixMilli() {
+		return nil, false, ErrInviteInvalid
+	}
+	if err := s.checkRedemption(invite, tgId, ip); err != nil {
+		return nil, false, err
+	}
+
+	client := model.Client{
+		Email:   inviteEmailPrefix + strings.ToLower(random.Seq(8)),
+		TotalGB: invite.TotalGB * 1024 * 1024 * 1024,
+		TgID:    tgId,
+		Comment: "invite " + invite.Code,
+		PlanId:  invite.PlanId,
+	}
+	if invite.Days > 0 {
+		client.ExpiryTime = time.Now().AddDate(0, 0, invite.Days).UnixMilli()
+	}
+	created, needRestart, err := s.inboundService.addSignupClient(invite.InboundId, client)
+	if err != nil {
+		return nil, false, err
+	}
+
+	referral := &model.Referral{
+		Code:      invite.Code,
+		Referrer:  invite.Referrer,
+		Email:     created.Email,
+		TgId:      tgId,
+		Ip:        ip,
+		CreatedAt: time.Now().Unix(),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(referral).Error; err != nil {
+			return err
+		}
+		return tx.Model(invite).Update("uses", gorm.Expr("uses + 1")).Error
+	})
+	if err != nil {
+		return created, needRestart, err
+	}
+	if invite.Referrer != "" {
+		if s.isReferrerAddress(invite.Referrer, ip) {
+			logger.Warning("No referral bonus for", invite.Referrer, ": invite redeemed from its own address", ip)
+		} else {
+			restart, err := s.giveReferralBonus(invite.Referrer)
+			if err != nil {
+				logger.Warning("Unable to give the referral bonus to", invite.Referrer, ":", err)
+			}
+			needRestart = needRestart || restart
+		}
+	}
+	logger.Infof("Invite %s redeemed by %s for tg %d ip %s", invite.Code, created.Email, tgId, ip)
+	return created, needRestart, nil
+}
+
+// checkRedemption returns an error when the Telegram user or the IP already redeemed an invite code,
+// when the referrer redeems its own code, or when the daily cap is reached.
+func (s *InviteService) checkRedemption(invite *model.InviteCode, tgId int64, ip string) error {
+	db := database.GetDB()
+	var count int64
+	if tgId > 0 {
+		if err := db.Model(model.Referral{}).Where("tg_id = ?", tgId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrInviteRedeemed
+		}
+		if invite.Referrer != "" {
+			_, referrer, err := s.inboundService.GetClientByEmail(invite.Referrer)
+			if err == nil && referrer.TgID == tgId {
+				return ErrInviteRedeemed
+			}
+		}
+	}
+	if ip != "" {
+		if err := db.Model(model.Referral{}).Where("ip = ?", ip).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrInviteRedeemed
+		}
+	}
+	dailyCap, err := s.settingService.GetInviteDailyCap()
+	if err != nil || dailyCap <= 0 {
+		return err
+	}
+	if err := db.Model(model.Referral{}).Where("created_at >= ?", s.inboundService.getDayStart()).Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= int64(dailyCap) {
+		return ErrInviteCapped
+	}
+	return nil
+}
+
+// isReferrerAddress reports whether an IP was used by the referrer itself: to sign up, to claim a
+// trial or to connect lately. A client redeeming its own code this way earns no bonus.
+func (s *InviteService) isReferrerAddress(referrer string, ip string) bool {
+	if ip == "" {
+		return false
+	}
+	db := database.GetDB()
+	var count int64
+	if err := db.Model(model.Referral{}).Where("email = ? AND ip = ?", referrer, ip).Count(&count).Error; err != nil || count > 0 {
+		return true
+	}
+	if err := db.Model(model.TrialClaim{}).Where("email = ? AND ip = ?", referrer, ip).Count(&count).Error; err != nil || count > 0 {
+		return true
+	}
+	record := &model.InboundClientIps{}
+	err := db.Model(model.InboundClientIps{}).Where("client_email = ?", referrer).First(record).Error
+	if database.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	var ips []string
+	if err := json.Unmarshal([]byte(record.Ips), &ips); err != nil {
+		return false
+	}
+	for _, used := range ips {
+		if signupIpKey(used) == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// giveReferralBonus adds the referral bonus quota and days to a client. Unlimited quotas and
+// expiries stay unlimited. Returns whether Xray needs a restart.
+func (s *InviteService) giveReferralBonus(email string) (bool, error) {
+	bonusGB, err := s.settingService.GetReferralBonusGB()
+	if err != nil {
+		return false, err
+	}
+	bonusDays, err := s.settingService.GetReferralBonusDays()
+	if err != nil {
+		return false, err
+	}
+	traffic, client, err := s.inboundService.GetClientByEmail(email)
+	if err != nil {
+		return false, err
+	}
+	needRestart := false
+	// The configured quota, the traffic total can be prorated for the current cycle
+	if bonusGB > 0 && client.TotalGB > 0 {
+		total := client.TotalGB + int64(bonusGB)*1024*1024*1024
+		totalGB := int(math.Round(float64(total) / (1024 * 1024 * 1024)))
+		restart, err := s.inboundService.ResetClientTrafficLimitByEmail(email, totalGB)
+		if err != nil {
+			return needRestart, err
+		}
+		needRestart = needRestart || restart
+	}
+	if bonusDays > 0 && traffic.ExpiryTime != 0 {
+		bonus := int64(bonusDays) * 86400000
+		expiry := traffic.ExpiryTime + bonus
+		if traffic.ExpiryTime < 0 {
+			// The expiry starts on first use and holds a negative duration
+			expiry = traffic.ExpiryTime - bonus
+		} else if traffic.ExpiryTime < time.Now().UnixMilli() {
+			expiry = time.Now().UnixMilli() + bonus
+		}
+		restart, err := s.inboundService.ResetClientExpiryTimeByEmail(email, expiry)
+		if err != nil {
+			return needRestart, err
+		}
+		needRestart = needRestart || restart
+	}
+	return needRestart, nil
+}
+
+// GetReferralTree returns the referral trees, rooted at the clients that were not referred themselves.
+func (s *InviteService) GetReferralTree() ([]*ReferralNode, error) {
+	db := database.GetDB()
+	var referrals []*model.Referral
+	if err := db.Model(model.Referral{}).Order("id asc").Find(&referrals).Error; err != nil {
+		return nil, err
+	}
+	nodes := map[string]*ReferralNode{}
+	node := func(email string) *ReferralNode {
+		if nodes[email] == nil {
+			nodes[email] = &ReferralNode{Email: email, Referrals: []*ReferralNode{}}
+		}
+		return nodes[email]
+	}
+	referred := map[string]bool{}
+	for _, referral := range referrals {
+		child := node(referral.Email)
+		child.Code = referral.Code
+		child.CreatedAt = referral.CreatedAt
+		if referral.Referrer != "" {
+			parent := node(referral.Referrer)
+			parent.Referrals = append(parent.Referrals, child)
+			referred[referral.Email] = true
+		}
+	}
+	roots := make([]*ReferralNode, 0)
+	for email, n := range nodes {
+		if !referred[email] && len(n.Referrals) > 0 {
+			roots = append(roots, n)
+		}
+	}
+	var count func(n *ReferralNode, seen map[string]bool) int
+	count = func(n *ReferralNode, seen map[string]bool) int {
+		if seen[n.Email] {
+			return 0
+		}
+		seen[n.Email] = true
+		n.Total = 0
+		for _, child := range n.Referrals {
+			n.Total += 1 + count(child, seen)
+		}
+		return n.Total
+	}
+	for _, root := range roots {
+		count(root, map[string]bool{})
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		if roots[i].Total != roots[j].Total {
+			return roots[i].Total > roots[j].Total
+		}
+		return roots[i].Email < roots[j].Email
+	})
+	return roots, nil
+}
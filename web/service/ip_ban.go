@@ -0,0 +1,297 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// defaultBlockOutboundTag is the blackhole outbound added for IP bans when the config has none.
+const defaultBlockOutboundTag = "ipban-blocked"
+
+var (
+	firewallBackendsLock sync.Mutex
+	firewallBackends     = map[string]FirewallBackend{}
+)
+
+// IpBanService enforces client IP limits by banning offending source IPs through a firewall backend.
+type IpBanService struct {
+	settingService SettingService
+}
+
+// GetBackendName returns the configured IP limit backend: fail2ban, xray, nftables or iptables.
+func (s *IpBanService) GetBackendName() string {
+	name, err := s.settingService.GetIpLimitBackend()
+	if err != nil || name == "" {
+		return "fail2ban"
+	}
+	return name
+}
+
+// IsNativeEnforcement reports whether IP limits are enforced by the panel instead of Fail2Ban.
+func (s *IpBanService) IsNativeEnforcement() bool {
+	return s.GetBackendName() != "fail2ban"
+}
+
+// BanIps bans the given source IPs of a client for the configured duration, reason names
+// the exceeded limit. Whitelisted and already banned IPs are skipped. Returns the newly banned IPs.
+// Bans through Xray take effect with the next FlushBans.
+func (s *IpBanService) BanIps(email string, ips []string, reason string) ([]string, error) {
+	backend, err := s.getBackend(s.GetBackendName())
+	if err != nil {
+		return nil, err
+	}
+	whitelist := s.getWhitelist()
+	minutes, err := s.settingService.GetIpBanDuration()
+	if err != nil {
+		minutes = 0
+	}
+	duration := time.Duration(minutes) * time.Minute
+
+	db := database.GetDB()
+	now := time.Now()
+	var banned []string
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil || isWhitelisted(ip, whitelist) {
+			continue
+		}
+		var count int64
+		if err := db.Model(model.IpBan{}).Where("ip = ?", ip).Count(&count).Error; err != nil {
+			return banned, err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := backend.Block(ip, duration); err != nil {
+			logger.Warning("Unable to ban ip", ip, "of", email, ":", err)
+			continue
+		}
+		ban := &model.IpBan{
+			Ip:        ip,
+			Email:     email,
+			Reason:    reason,
+			Backend:   backend.Name(),
+			CreatedAt: now.Unix(),
+		}
+		if duration > 0 {
+			ban.ExpiresAt = now.Add(duration).Unix()
+		}
+		if err := db.Create(ban).Error; err != nil {
+			return banned, err
+		}
+		logger.Infof("[LimitIP] Banned %s of %s", ip, email)
+		banned = append(banned, ip)
+	}
+	return banned, nil
+}
+
+// GetBans returns all active IP bans, newest first.
+func (s *IpBanService) GetBans() ([]*model.IpBan, error) {
+	db := database.GetDB()
+	var bans []*model.IpBan
+	err := db.Model(model.IpBan{}).Order("created_at desc").Find(&bans).Error
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// Unban lifts the ban of a source IP.
+func (s *IpBanService) Unban(ip string) error {
+	db := database.GetDB()
+	ban := &model.IpBan{}
+	err := db.Model(model.IpBan{}).Where("ip = ?", ip).First(ban).Error
+	if err != nil {
+		return err
+	}
+	return s.unban(ban)
+}
+
+// UnbanAll lifts every IP ban.
+func (s *IpBanService) UnbanAll() error {
+	bans, err := s.GetBans()
+	if err != nil {
+		return err
+	}
+	for _, ban := range bans {
+		if err := s.unban(ban); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnbanExpired lifts the bans whose duration has elapsed and returns how many were lifted.
+func (s *IpBanService) UnbanExpired() (int, error) {
+	db := database.GetDB()
+	var bans []*model.IpBan
+	err := db.Model(model.IpBan{}).Where("expires_at > 0 AND expires_at <= ?", time.Now().Unix()).Find(&bans).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, ban := range bans {
+		if err := s.unban(ban); err != nil {
+			return 0, err
+		}
+	}
+	return len(bans), nil
+}
+
+// FlushBans applies the bans added since the last call on the backends that batch them.
+func (s *IpBanService) FlushBans() {
+	firewallBackendsLock.Lock()
+	backends := make([]FirewallBackend, 0, len(firewallBackends))
+	for _, backend := range firewallBackends {
+		backends = append(backends, backend)
+	}
+	firewallBackendsLock.Unlock()
+	for _, backend := range backends {
+		if batch, ok := backend.(batchFirewall); ok {
+			if err := batch.Flush(); err != nil {
+				logger.Warning("Unable to apply the", backend.Name(), "ip bans:", err)
+			}
+		}
+	}
+}
+
+// RestoreBans applies the active bans again, e.g. after a reboot cleared the firewall sets.
+// Bans held by Xray need no restore since they are part of the generated config.
+func (s *IpBanService) RestoreBans() error {
+	bans, err := s.GetBans()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, ban := range bans {
+		if ban.Backend == "xray" || (ban.ExpiresAt > 0 && ban.ExpiresAt <= now) {
+			continue
+		}
+		backend, err := s.getBackend(ban.Backend)
+		if err != nil {
+			return err
+		}
+		var duration time.Duration
+		if ban.ExpiresAt > 0 {
+			duration = time.Duration(ban.ExpiresAt-now) * time.Second
+		}
+		if err := backend.Block(ban.Ip, duration); err != nil {
+			logger.Warning("Unable to restore ban of", ban.Ip, ":", err)
+		}
+	}
+	return nil
+}
+
+func (s *IpBanService) unban(ban *model.IpBan) error {
+	backend, err := s.getBackend(ban.Backend)
+	if err != nil {
+		return err
+	}
+	if err := backend.Unblock(ban.Ip); err != nil {
+		logger.Warning("Unable to unban ip", ban.Ip, ":", err)
+	}
+	db := database.GetDB()
+	return db.Delete(model.IpBan{}, ban.Id).Error
+}
+
+func (s *IpBanService) getBackend(name string) (FirewallBackend, error) {
+	if name == "fail2ban" {
+		return nil, common.NewError("ip bans are handled by fail2ban")
+	}
+	firewallBackendsLock.Lock()
+	defer firewallBackendsLock.Unlock()
+	if backend, ok := firewallBackends[name]; ok {
+		return backend, nil
+	}
+	backend, err := newFirewallBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	firewallBackends[name] = backend
+	return backend, nil
+}
+
+// getWhitelist parses the whitelisted IPs and CIDRs, separated by commas or new lines.
+func (s *IpBanService) getWhitelist() []*net.IPNet {
+	value, err := s.settingService.GetIpBanWhitelist()
+	if err != nil {
+		return nil
+	}
+	var whitelist []*net.IPNet
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n' || r == ' '
+	}) {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			logger.Warning("Invalid ip ban whitelist entry:", entry)
+			continue
+		}
+		whitelist = append(whitelist, ipNet)
+	}
+	return whitelist
+}
+
+func isWhitelisted(ip string, whitelist []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsLoopback() {
+		return true
+	}
+	for _, ipNet := range whitelist {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// getBlockOutboundTag returns the tag of the first blackhole outbound of the config.
+func getBlockOutboundTag(xrayConfig *xray.Config) string {
+	if xrayConfig == nil {
+		return defaultBlockOutboundTag
+	}
+	var outbounds []map[string]any
+	json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds)
+	for _, outbound := range outbounds {
+		if protocol, _ := outbound["protocol"].(string); protocol == "blackhole" {
+			if tag, ok := outbound["tag"].(string); ok && tag != "" {
+				return tag
+			}
+		}
+	}
+	return defaultBlockOutboundTag
+}
+
+// applyIpBanRules prepares the config for bans through Xray: it makes sure a blackhole outbound
+// exists and puts the rules of active bans ahead of all other routing rules.
+func (s *IpBanService) applyIpBanRules(xrayConfig *xray.Config) error {
+	blockTag := getBlockOutboundTag(xrayConfig)
+	if err := ensureBlackholeOutbound(xrayConfig, blockTag); err != nil {
+		return err
+	}
+
+	db := database.GetDB()
+	var bans []*model.IpBan
+	err := db.Model(model.IpBan{}).Where("backend = ?", "xray").Order("id asc").Find(&bans).Error
+	if err != nil || len(bans) == 0 {
+		return err
+	}
+	rules := make([]any, 0, len(bans))
+	for _, ban := range bans {
+		rules = append(rules, ipBanRule(ban.Ip, blockTag))
+	}
+	return prependRoutingRules(xrayConfig, rules)
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	leakWindow           = 24 * time.Hour     // Period the signals are measured over
+	leakReportCooldown   = 24 * time.Hour     // Minimum time between two reports of a client
+	leakFetchRetention   = 7 * 24 * time.Hour // How long subscription fetch IPs are kept
+	leakTrafficFactor    = 3.0                // Traffic over its daily average that makes a full signal
+	leakReportScore      = 100                // Score from which a client is reported
+	leakSignalScore      = 50                 // Points of a signal at its threshold
+	leakLimitDeviceCount = 1                  // Device limit set by the limit response
+)
+
+// LeakScore is how likely a client is to share its subscription, with the signals it is built from.
+type LeakScore struct {
+	Email        string  `json:"email"`
+	InboundId    int     `json:"inboundId"`
+	SubId        string  `json:"subId"`
+	Score        int     `json:"score"`
+	SubIps       int     `json:"subIps"`       // Distinct IPs the subscription was fetched from in the last day
+	ConnIps      int     `json:"connIps"`      // Distinct IPs the client connected from in the last day
+	TrafficRatio float64 `json:"trafficRatio"` // Traffic of the last day over the daily average of the week before
+}
+
+// LeakDetectionService detects subscriptions that are likely shared or resold. Each signal, subscription
+// fetch IPs, connection IPs and traffic spikes, is divided by its threshold and adds leakSignalScore points
+// at the threshold; the traffic signal is capped so that it only backs the other two.
+type LeakDetectionService struct {
+	inboundService  InboundService
+	settingService  SettingService
+	rotationService CredentialRotationService
+}
+
+// RecordSubscriptionFetch records that a subscription was fetched from an IP, only while leak detection is enabled.
+func (s *LeakDetectionService) RecordSubscriptionFetch(subId string, ip string) error {
+	if subId == "" || ip == "" {
+		return nil
+	}
+	if enable, err := s.settingService.GetLeakDetectEnable(); err != nil || !enable {
+		return err
+	}
+	now := time.Now().Unix()
+	db := database.GetDB()
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "sub_id"}, {Name: "ip"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"last_seen": now,
+			"count":     gorm.Expr("subscription_fetches.count + 1"),
+		}),
+	}).Create(&model.SubscriptionFetch{SubId: subId, Ip: ip, FirstSeen: now, LastSeen: now, Count: 1}).Error
+}
+
+// GetLeakScores returns the clients showing any sharing signal, highest score first.
+func (s *LeakDetectionService) GetLeakScores() ([]*LeakScore, error) {
+	subThreshold, err := s.settingService.GetLeakSubIps()
+	if err != nil {
+		return nil, err
+	}
+	connThreshold, err := s.settingService.GetLeakConnIps()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	since := now.Add(-leakWindow).Unix()
+	db := database.GetDB()
+
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	scores := map[string]*LeakScore{}
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		for _, client := range clients {
+			if client.Email != "" {
+				scores[client.Email] = &LeakScore{Email: client.Email, InboundId: inbound.Id, SubId: client.SubID}
+			}
+		}
+	}
+
+	var fetches []struct {
+		SubId string
+		Ips   int
+	}
+	err = db.Model(model.SubscriptionFetch{}).Select("sub_id, COUNT(*) AS ips").
+		Where("last_seen >= ?", since).Group("sub_id").Scan(&fetches).Error
+	if err != nil {
+		return nil, err
+	}
+	subIps := make(map[string]int, len(fetches))
+	for _, fetch := range fetches {
+		subIps[fetch.SubId] = fetch.Ips
+	}
+
+	// Connection IPs come from the connection log and from the device sessions
+	var connections []struct {
+		Email string
+		Ip    string
+	}
+	err = db.Model(model.ConnectionLog{}).Distinct("email", "ip").
+		Where("time >= ? AND email != ''", since).Scan(&connections).Error
+	if err != nil {
+		return nil, err
+	}
+	var sessions []struct {
+		Email string
+		Ip    string
+	}
+	err = db.Model(model.ClientSession{}).Select("email, ip").Where("last_seen >= ?", since).Scan(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	connIps := map[string]map[string]bool{}
+	for _, connection := range append(connections, sessions...) {
+		if connIps[connection.Email] == nil {
+			connIps[connection.Email] = map[string]bool{}
+		}
+		connIps[connection.Email][connection.Ip] = true
+	}
+
+	var recent, before []struct {
+		Email   string
+		Traffic int64
+	}
+	err = db.Model(model.ClientTrafficHistory{}).Select("email, SUM(up + down) AS traffic").
+		Where("period = ? AND time >= ?", "hour", since).Group("email").Scan(&recent).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(model.ClientTrafficHistory{}).Select("email, SUM(up + down) AS traffic").
+		Where("period = ? AND time >= ? AND time < ?", "hour", since-7*86400, since).Group("email").Scan(&before).Error
+	if err != nil {
+		return nil, err
+	}
+	average := make(map[string]float64, len(before))
+	for _, row := range before {
+		average[row.Email] = float64(row.Traffic) / 7
+	}
+
+	for _, row := range recent {
+		if score, ok := scores[row.Email]; ok && average[row.Email] > 0 {
+			score.TrafficRatio = math.Round(float64(row.Traffic)/average[row.Email]*10) / 10
+		}
+	}
+	result := make([]*LeakScore, 0)
+	for _, score := range scores {
+		if score.SubId != "" {
+			score.SubIps = subIps[score.SubId]
+		}
+		score.ConnIps = len(connIps[score.Email])
+		signal := math.Min(score.TrafficRatio/leakTrafficFactor, 1)
+		if subThreshold > 0 {
+			signal += float64(score.SubIps) / float64(subThreshold)
+		}
+		if connThreshold > 0 {
+			signal += float64(score.ConnIps) / float64(connThreshold)
+		}
+		score.Score = int(math.Round(signal * leakSignalScore))
+		if score.Score > 0 {
+			result = append(result, score)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Email < result[j].Email
+	})
+	return result, nil
+}
+
+// DetectLeaks reports the clients whose score reached leakReportScore and were not reported in the last
+// day, applying the configured response to them. Returns the new reports and whether Xray needs a restart.
+func (s *LeakDetectionService) DetectLeaks() ([]*model.LeakReport, bool, error) {
+	db := database.GetDB()
+	now := time.Now()
+	// Purged even when detection is off, so that fetches recorded before it was turned off do not stay
+	if err := db.Where("last_seen < ?", now.Add(-leakFetchRetention).Unix()).Delete(model.SubscriptionFetch{}).Error; err != nil {
+		return nil, false, err
+	}
+	enable, err := s.settingService.GetLeakDetectEnable()
+	if err != nil || !enable {
+		return nil, false, err
+	}
+	action, err := s.settingService.GetLeakAction()
+	if err != nil {
+		return nil, false, err
+	}
+
+	scores, err := s.GetLeakScores()
+	if err != nil {
+		return nil, false, err
+	}
+	var reports []*model.LeakReport
+	needRestart := false
+	rotatedSubs := map[string]string{}
+	for _, score := range scores {
+		if score.Score < leakReportScore {
+			break
+		}
+		report := &model.LeakReport{}
+		err := db.Model(model.LeakReport{}).Where("email = ?", score.Email).First(report).Error
+		if err != nil && !database.IsNotFound(err) {
+			return reports, needRestart, err
+		}
+		if report.Id > 0 && now.Sub(time.Unix(report.DetectedAt, 0)) < leakReportCooldown {
+			continue
+		}
+		report.Email = score.Email
+		report.SubId = score.SubId
+		report.Score = score.Score
+		report.SubIps = score.SubIps
+		report.ConnIps = score.ConnIps
+		report.TrafficRatio = score.TrafficRatio
+		report.DetectedAt = now.Unix()
+		report.Action = "none"
+
+		switch action {
+		case "rotate":
+			if newSubId, ok := rotatedSubs[score.SubId]; ok {
+				// Clients of the same subscription were rotated together
+				report.SubId = newSubId
+				report.Action = action
+				break
+			}
+			var restart bool
+			var err error
+			if score.SubId != "" {
+				var newSubId string
+				newSubId, restart, err = s.rotationService.RotateSubscription(score.SubId)
+				if err == nil {
+					rotatedSubs[score.SubId] = newSubId
+					report.SubId = newSubId
+				}
+			} else {
+				restart, err = s.rotationService.RotateClientCredential(score.InboundId, score.Email)
+			}
+			if err != nil {
+				logger.Warning("Unable to rotate the subscription of", score.Email, ":", err)
+				break
+			}
+			report.Action = action
+			needRestart = needRestart || restart
+		case "limit":
+			if err := s.limitClient(score.InboundId, score.Email); err != nil {
+				logger.Warning("Unable to limit", score.Email, ":", err)
+				break
+			}
+			report.Action = action
+		}
+		if err := db.Save(report).Error; err != nil {
+			return reports, needRestart, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, needRestart, nil
+}
+
+// limitClient lowers the device limit of a client and caps its speed at the throttle speeds.
+// Both are read from its settings by the device and speed limit jobs, so Xray is left alone.
+func (s *LeakDetectionService) limitClient(inboundId int, email string) error {
+	inbound, err := s.inboundService.GetInbound(inboundId)
+	if err != nil {
+		return err
+	}
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return err
+	}
+	speedUp, _ := s.settingService.GetThrottleSpeedUp()
+	speedDown, _ := s.settingService.GetThrottleSpeedDown()
+	clients, _ := settings["clients"].([]any)
+	for _, client := range clients {
+		c, ok := client.(map[string]any)
+		if !ok || c["email"] != email {
+			continue
+		}
+		c["limitDevice"] = leakLimitDeviceCount
+		if speedUp > 0 {
+			c["speedUp"] = speedUp
+		}
+		if speedDown > 0 {
+			c["speedDown"] = speedDown
+		}
+		c["updated_at"] = time.Now().UnixMilli()
+		data, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return err
+		}
+		db := database.GetDB()
+		return db.Model(model.Inbound{}).Where("id = ?", inboundId).Update("settings", string(data)).Error
+	}
+	return common.NewErrorf("client with email %s not found", email)
+}
+
+// GetLeakReports returns the clients reported for a likely leak, latest first.
+func (s *LeakDetectionService) GetLeakReports() ([]*model.LeakReport, error) {
+	db := database.GetDB()
+	reports := make([]*model.LeakReport, 0)
+	err := db.Model(model.LeakReport{}).Order("detected_at desc").Find(&reports).Error
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// DelLeakReport dismisses the leak report of a client, so it can be reported again at once.
+func (s *LeakDetectionService) DelLeakReport(email string) error {
+	db := database.GetDB()
+	return db.Where("email = ?", email).Delete(model.LeakReport{}).Error
+}
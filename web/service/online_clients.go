@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// onlineClientIpsMaxAge is how long collected online IPs are trusted without a new collection.
+const onlineClientIpsMaxAge = 30 * time.Second
+
+var (
+	onlineClientIpsLock sync.RWMutex
+	onlineClientIps     = map[string][]string{}
+	onlineClientIpsTime time.Time
+)
+
+// IsStatsIpSource reports whether client IPs and presence are read from the Xray stats service
+// instead of the access log.
+func (s *InboundService) IsStatsIpSource() bool {
+	source, err := s.settingService.GetClientIpSource()
+	return err == nil && source == "stats"
+}
+
+// CollectOnlineClientIps reads the live IPs of the enabled clients that had traffic since the last
+// collection, or were online then, from the Xray stats service. IPs of each client are ordered from
+// the least to the most recently connected.
+func (s *InboundService) CollectOnlineClientIps() (map[string][]string, error) {
+	if p == nil || !p.IsRunning() {
+		return nil, errors.New("xray is not running")
+	}
+
+	candidates := s.takeActiveClients()
+	onlineClientIpsLock.RLock()
+	for email := range onlineClientIps {
+		if !slices.Contains(candidates, email) {
+			candidates = append(candidates, email)
+		}
+	}
+	onlineClientIpsLock.RUnlock()
+
+	var emails []string
+	if len(candidates) > 0 {
+		db := database.GetDB()
+		err := db.Model(xray.ClientTraffic{}).Where("enable = ? AND email IN ?", true, candidates).Pluck("email", &emails).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.xrayApi.Init(p.GetAPIPort())
+	defer s.xrayApi.Close()
+	stats, err := s.xrayApi.GetOnlineClientIps(emails)
+	if err != nil {
+		// Clients queried before the failure are still recorded
+		logger.Debug("Unable to query the online IPs of every client:", err)
+	}
+
+	result := make(map[string][]string, len(stats))
+	for email, ipTimes := range stats {
+		ips := make([]string, 0, len(ipTimes))
+		for ip := range ipTimes {
+			if ip == "127.0.0.1" || ip == "::1" {
+				continue
+			}
+			ips = append(ips, ip)
+		}
+		if len(ips) == 0 {
+			continue
+		}
+		sort.Slice(ips, func(i, j int) bool {
+			if ipTimes[ips[i]] != ipTimes[ips[j]] {
+				return ipTimes[ips[i]] < ipTimes[ips[j]]
+			}
+			return ips[i] < ips[j]
+		})
+		result[email] = ips
+	}
+
+	onlineClientIpsLock.Lock()
+	onlineClientIps = result
+	onlineClientIpsTime = time.Now()
+	onlineClientIpsLock.Unlock()
+	return result, nil
+}
+
+// GetOnlineClientIps returns the live IPs of online clients from the last stats collection.
+func (s *InboundService) GetOnlineClientIps() map[string][]string {
+	onlineClientIpsLock.RLock()
+	defer onlineClientIpsLock.RUnlock()
+
+	result := make(map[string][]string, len(onlineClientIps))
+	if time.Since(onlineClientIpsTime) > onlineClientIpsMaxAge {
+		return result
+	}
+	for email, ips := range onlineClientIps {
+		result[email] = append([]string(nil), ips...)
+	}
+	return result
+}
+
+// enableUserOnlineStats turns on the statsUserOnline policy for every user level,
+// which the stats service needs to track online IPs.
+func enableUserOnlineStats(xrayConfig *xray.Config) error {
+	policy := map[string]any{}
+	if isConfigSet(xrayConfig.Policy) {
+		if err := json.Unmarshal(xrayConfig.Policy, &policy); err != nil {
+			return err
+		}
+	}
+	levels, _ := policy["levels"].(map[string]any)
+	if levels == nil {
+		levels = map[string]any{}
+	}
+	if _, ok := levels["0"]; !ok {
+		levels["0"] = map[string]any{}
+	}
+	for name, level := range levels {
+		levelConfig, ok := level.(map[string]any)
+		if !ok {
+			levelConfig = map[string]any{}
+		}
+		levelConfig["statsUserOnline"] = true
+		levels[name] = levelConfig
+	}
+	policy["levels"] = levels
+
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.Policy = data
+	return nil
+}
@@ -1,17 +1,29 @@
 package service
 
 import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/agassiz/3x-ui/v2/database"
 	"github.com/agassiz/3x-ui/v2/database/model"
 	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/util/proxy"
 	"github.com/agassiz/3x-ui/v2/xray"
 
 	"gorm.io/gorm"
 )
 
 // OutboundService provides business logic for managing Xray outbound configurations.
-// It handles outbound traffic monitoring and statistics.
-type OutboundService struct{}
+// It handles outbound traffic monitoring and statistics, observatory health,
+// panel-managed outbounds and their import from share links and remote subscriptions.
+type OutboundService struct {
+	settingService SettingService
+	xrayApi        xray.XrayAPI
+}
 
 func (s *OutboundService) AddTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) (error, bool) {
 	var err error
@@ -40,6 +52,7 @@ func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traff
 	}
 
 	var err error
+	now := time.Now()
 
 	for _, traffic := range traffics {
 		if traffic.IsOutbound {
@@ -47,17 +60,22 @@ func (s *OutboundService) addOutboundTraffic(tx *gorm.DB, traffics []*xray.Traff
 			var outbound model.OutboundTraffics
 
 			err = tx.Model(&model.OutboundTraffics{}).Where("tag = ?", traffic.Tag).
-				FirstOrCreate(&outbound).Error
+				FirstOrCreate(&outbound, model.OutboundTraffics{Tag: traffic.Tag}).Error
 			if err != nil {
 				return err
 			}
 
-			outbound.Tag = traffic.Tag
-			outbound.Up = outbound.Up + traffic.Up
-			outbound.Down = outbound.Down + traffic.Down
-			outbound.Total = outbound.Up + outbound.Down
+			// Increment in SQL so that quota settings saved meanwhile are kept
+			err = tx.Model(&outbound).Updates(map[string]any{
+				"up":    gorm.Expr("up + ?", traffic.Up),
+				"down":  gorm.Expr("down + ?", traffic.Down),
+				"total": gorm.Expr("up + down + ?", traffic.Up+traffic.Down),
+			}).Error
+			if err != nil {
+				return err
+			}
 
-			err = tx.Save(&outbound).Error
+			err = s.addOutboundTrafficHistory(tx, traffic, now)
 			if err != nil {
 				return err
 			}
@@ -79,7 +97,9 @@ func (s *OutboundService) GetOutboundsTraffic() ([]*model.OutboundTraffics, erro
 	return traffics, nil
 }
 
-func (s *OutboundService) ResetOutboundTraffic(tag string) error {
+// ResetOutboundTraffic resets the traffic counters of an outbound, or of all outbounds for "-alltags-".
+// Returns whether a rerouted outbound was restored and Xray needs restart.
+func (s *OutboundService) ResetOutboundTraffic(tag string) (bool, error) {
 	db := database.GetDB()
 
 	whereText := "tag "
@@ -89,14 +109,286 @@ func (s *OutboundService) ResetOutboundTraffic(tag string) error {
 		whereText += " = ?"
 	}
 
+	var rerouted int64
+	err := db.Model(model.OutboundTraffics{}).
+		Where(whereText+" AND quota_exceeded = ? AND quota_action = ?", tag, true, "reroute").
+		Count(&rerouted).Error
+	if err != nil {
+		return false, err
+	}
+
 	result := db.Model(model.OutboundTraffics{}).
 		Where(whereText, tag).
-		Updates(map[string]any{"up": 0, "down": 0, "total": 0})
+		Updates(map[string]any{"up": 0, "down": 0, "total": 0, "quota_exceeded": false, "last_reset_time": time.Now().Unix()})
+
+	err = result.Error
+	if err != nil {
+		return false, err
+	}
+
+	return rerouted > 0, nil
+}
+
+// GetOutbounds returns all panel-managed outbounds.
+func (s *OutboundService) GetOutbounds() ([]*model.Outbound, error) {
+	db := database.GetDB()
+	var outbounds []*model.Outbound
+	err := db.Model(model.Outbound{}).Order("id asc").Find(&outbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	return outbounds, nil
+}
+
+// GetOutbound returns a panel-managed outbound by ID.
+func (s *OutboundService) GetOutbound(id int) (*model.Outbound, error) {
+	db := database.GetDB()
+	outbound := &model.Outbound{}
+	err := db.Model(model.Outbound{}).First(outbound, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return outbound, nil
+}
+
+// AddOutbound validates and stores a panel-managed outbound.
+// Returns whether Xray needs restart and any error.
+func (s *OutboundService) AddOutbound(outbound *model.Outbound) (bool, error) {
+	if err := s.checkOutbound(outbound); err != nil {
+		return false, err
+	}
+	outbound.Id = 0
+	db := database.GetDB()
+	if err := db.Create(outbound).Error; err != nil {
+		return false, err
+	}
+	return outbound.Enable, nil
+}
 
-	err := result.Error
+// UpdateOutbound updates a panel-managed outbound.
+// Returns whether Xray needs restart and any error.
+func (s *OutboundService) UpdateOutbound(outbound *model.Outbound) (bool, error) {
+	oldOutbound, err := s.GetOutbound(outbound.Id)
+	if err != nil {
+		return false, err
+	}
+	if err := s.checkOutbound(outbound); err != nil {
+		return false, err
+	}
+
+	oldOutbound.Remark = outbound.Remark
+	oldOutbound.Enable = outbound.Enable
+	oldOutbound.Tag = outbound.Tag
+	oldOutbound.Protocol = outbound.Protocol
+	oldOutbound.Config = outbound.Config
+	oldOutbound.Link = outbound.Link
+
+	db := database.GetDB()
+	return true, db.Save(oldOutbound).Error
+}
+
+// DelOutbound deletes a panel-managed outbound.
+// Returns whether Xray needs restart and any error.
+func (s *OutboundService) DelOutbound(id int) (bool, error) {
+	outbound, err := s.GetOutbound(id)
+	if err != nil {
+		return false, err
+	}
+	db := database.GetDB()
+	return outbound.Enable, db.Delete(model.Outbound{}, id).Error
+}
+
+// ParseOutbounds parses share links, base64 or Clash subscription bodies
+// into outbounds without storing them.
+func (s *OutboundService) ParseOutbounds(body string, tagPrefix string) ([]*model.Outbound, error) {
+	parsed, err := proxy.ParseSubscription(body)
+	if err != nil {
+		return nil, err
+	}
+	existingTags, err := s.getUsedTags(0)
+	if err != nil {
+		return nil, err
+	}
+	return s.toOutbounds(parsed, tagPrefix, existingTags)
+}
+
+// ImportOutbounds parses share links or subscription bodies and stores them as outbounds.
+func (s *OutboundService) ImportOutbounds(body string, tagPrefix string) ([]*model.Outbound, bool, error) {
+	outbounds, err := s.ParseOutbounds(body, tagPrefix)
+	if err != nil {
+		return nil, false, err
+	}
+
+	db := database.GetDB()
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	for _, outbound := range outbounds {
+		outbound.Enable = true
+		err = tx.Create(outbound).Error
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return outbounds, len(outbounds) > 0, nil
+}
+
+// checkOutbound validates the tag and JSON config of a panel-managed outbound.
+func (s *OutboundService) checkOutbound(outbound *model.Outbound) error {
+	outbound.Tag = strings.TrimSpace(outbound.Tag)
+	if outbound.Tag == "" {
+		return common.NewError("outbound tag is empty")
+	}
+
+	// Allow a share link instead of the JSON config
+	if outbound.Config == "" && outbound.Link != "" {
+		parsed, err := proxy.ParseLink(outbound.Link)
+		if err != nil {
+			return err
+		}
+		config, err := json.MarshalIndent(parsed.Config, "", "  ")
+		if err != nil {
+			return err
+		}
+		outbound.Config = string(config)
+		outbound.Protocol = parsed.Protocol
+	}
+
+	config := map[string]any{}
+	if err := json.Unmarshal([]byte(outbound.Config), &config); err != nil {
+		return common.NewError("invalid outbound config:", err)
+	}
+	protocol, _ := config["protocol"].(string)
+	if protocol == "" {
+		return common.NewError("outbound protocol is empty")
+	}
+	outbound.Protocol = protocol
+
+	templateTags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return err
+	}
+	if templateTags[outbound.Tag] {
+		return common.NewError("outbound tag already used in Xray template:", outbound.Tag)
+	}
+	return nil
+}
+
+// getTemplateOutboundTags returns the outbound tags defined in the Xray config template.
+func (s *OutboundService) getTemplateOutboundTags() (map[string]bool, error) {
+	templateConfig, err := s.settingService.GetXrayConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+	xrayConfig := &xray.Config{}
+	if err := json.Unmarshal([]byte(templateConfig), xrayConfig); err != nil {
+		return nil, err
+	}
+	tags, _ := getRoutingTags(xrayConfig)
+	return tags, nil
+}
+
+// getUsedTags returns tags taken by the template and stored outbounds,
+// except those owned by the given subscription.
+func (s *OutboundService) getUsedTags(subscriptionId int) (map[string]bool, error) {
+	tags, err := s.getTemplateOutboundTags()
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	var stored []string
+	query := db.Model(model.Outbound{})
+	if subscriptionId > 0 {
+		query = query.Where("subscription_id <> ?", subscriptionId)
+	}
+	if err := query.Pluck("tag", &stored).Error; err != nil {
+		return nil, err
+	}
+	for _, tag := range stored {
+		tags[tag] = true
+	}
+	return tags, nil
+}
+
+var outboundTagInvalidChars = regexp.MustCompile(`[^\p{L}\p{N}._@-]+`)
+
+// toOutbounds converts parsed share links to outbounds with unique tags.
+func (s *OutboundService) toOutbounds(parsed []*proxy.ParsedOutbound, tagPrefix string, usedTags map[string]bool) ([]*model.Outbound, error) {
+	tagPrefix = strings.TrimSpace(tagPrefix)
+	var outbounds []*model.Outbound
+	for i, p := range parsed {
+		name := strings.Trim(outboundTagInvalidChars.ReplaceAllString(p.Remark, "-"), "-")
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", p.Protocol, i+1)
+		}
+		tag := name
+		if tagPrefix != "" {
+			tag = tagPrefix + "-" + name
+		}
+		base := tag
+		for n := 2; usedTags[tag]; n++ {
+			tag = fmt.Sprintf("%s-%d", base, n)
+		}
+		usedTags[tag] = true
+
+		config, err := json.MarshalIndent(p.Config, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		outbounds = append(outbounds, &model.Outbound{
+			Remark:   p.Remark,
+			Tag:      tag,
+			Protocol: p.Protocol,
+			Config:   string(config),
+			Link:     p.Link,
+		})
+	}
+	return outbounds, nil
+}
+
+// mergeOutbounds appends enabled panel-managed outbounds to the Xray config.
+// Outbounds whose tag is already defined in the template are skipped.
+func (s *OutboundService) mergeOutbounds(xrayConfig *xray.Config) error {
+	db := database.GetDB()
+	var outbounds []*model.Outbound
+	err := db.Model(model.Outbound{}).Where("enable = ?", true).Order("id asc").Find(&outbounds).Error
 	if err != nil {
 		return err
 	}
+	if len(outbounds) == 0 {
+		return nil
+	}
 
+	var configs []any
+	if len(xrayConfig.OutboundConfigs) > 0 {
+		if err := json.Unmarshal(xrayConfig.OutboundConfigs, &configs); err != nil {
+			return err
+		}
+	}
+	templateTags, _ := getRoutingTags(xrayConfig)
+	for _, outbound := range outbounds {
+		if templateTags[outbound.Tag] {
+			logger.Warning("Skip managed outbound with duplicate tag:", outbound.Tag)
+			continue
+		}
+		config := map[string]any{}
+		if err := json.Unmarshal([]byte(outbound.Config), &config); err != nil {
+			logger.Warningf("Skip managed outbound %s: %v", outbound.Tag, err)
+			continue
+		}
+		config["tag"] = outbound.Tag
+		configs = append(configs, config)
+	}
+
+	data, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.OutboundConfigs = data
 	return nil
 }
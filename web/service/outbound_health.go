@@ -0,0 +1,210 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// outboundHealthHistorySize is the number of probe samples kept per outbound.
+const outboundHealthHistorySize = 720
+
+// OutboundHealthSample is a single observatory probe result of an outbound.
+type OutboundHealthSample struct {
+	Time  int64 `json:"time"`
+	Alive bool  `json:"alive"`
+	Delay int64 `json:"delay"`
+}
+
+// OutboundHealth holds the latest observatory status of an outbound and its recent history.
+type OutboundHealth struct {
+	xray.OutboundStatus
+	DeadSince int64                  `json:"deadSince"` // Unix time the outbound was first seen dead, 0 while alive
+	History   []OutboundHealthSample `json:"history"`
+
+	alerted bool
+}
+
+var (
+	outboundHealthLock sync.RWMutex
+	outboundHealth     = map[string]*OutboundHealth{}
+)
+
+// CollectOutboundHealth polls the Xray observatory and records a sample for every probed outbound.
+// It returns the outbounds that have stayed dead longer than deadAfter and were not reported yet,
+// and the previously reported outbounds that are alive again.
+func (s *OutboundService) CollectOutboundHealth(deadAfter time.Duration) ([]*OutboundHealth, []*OutboundHealth, error) {
+	if p == nil || !p.IsRunning() {
+		return nil, nil, errors.New("xray is not running")
+	}
+	if !hasObservatory(p.GetConfig()) {
+		return nil, nil, nil
+	}
+
+	s.xrayApi.Init(p.GetAPIPort())
+	defer s.xrayApi.Close()
+	statuses, err := s.xrayApi.GetOutboundStatus()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outboundHealthLock.Lock()
+	defer outboundHealthLock.Unlock()
+
+	var dead, recovered []*OutboundHealth
+	now := time.Now().Unix()
+	seen := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		seen[status.Tag] = true
+		health, ok := outboundHealth[status.Tag]
+		if !ok {
+			health = &OutboundHealth{}
+			outboundHealth[status.Tag] = health
+		}
+		health.OutboundStatus = *status
+		health.History = append(health.History, OutboundHealthSample{
+			Time:  now,
+			Alive: status.Alive,
+			Delay: status.Delay,
+		})
+		if len(health.History) > outboundHealthHistorySize {
+			health.History = health.History[len(health.History)-outboundHealthHistorySize:]
+		}
+
+		if status.Alive {
+			health.DeadSince = 0
+			if health.alerted {
+				health.alerted = false
+				recovered = append(recovered, health.copy())
+			}
+			continue
+		}
+		if health.DeadSince == 0 {
+			health.DeadSince = now
+		}
+		if deadAfter > 0 && !health.alerted && now-health.DeadSince >= int64(deadAfter.Seconds()) {
+			health.alerted = true
+			dead = append(dead, health.copy())
+		}
+	}
+
+	// Forget outbounds that are no longer probed, e.g. after a config change
+	for tag := range outboundHealth {
+		if !seen[tag] {
+			delete(outboundHealth, tag)
+		}
+	}
+	return dead, recovered, nil
+}
+
+// GetOutboundsHealth returns the recorded health of every probed outbound, sorted by tag.
+func (s *OutboundService) GetOutboundsHealth() []*OutboundHealth {
+	outboundHealthLock.RLock()
+	defer outboundHealthLock.RUnlock()
+
+	result := make([]*OutboundHealth, 0, len(outboundHealth))
+	for _, health := range outboundHealth {
+		result = append(result, health.copy())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
+
+// GetBalancersStatus returns the current selection of every balancer in the running config.
+func (s *OutboundService) GetBalancersStatus() ([]*xray.BalancerStatus, error) {
+	if p == nil || !p.IsRunning() {
+		return nil, errors.New("xray is not running")
+	}
+	_, balancerTags := getRoutingTags(p.GetConfig())
+	if len(balancerTags) == 0 {
+		return []*xray.BalancerStatus{}, nil
+	}
+	tags := make([]string, 0, len(balancerTags))
+	for tag := range balancerTags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	s.xrayApi.Init(p.GetAPIPort())
+	defer s.xrayApi.Close()
+
+	balancers := make([]*xray.BalancerStatus, 0, len(tags))
+	for _, tag := range tags {
+		balancer, err := s.xrayApi.GetBalancerInfo(tag)
+		if err != nil {
+			logger.Debug("Unable to get balancer info:", tag, err)
+			balancer = &xray.BalancerStatus{Tag: tag}
+		}
+		balancers = append(balancers, balancer)
+	}
+	return balancers, nil
+}
+
+// copy returns a snapshot of the health that is safe to use without holding the lock.
+func (h *OutboundHealth) copy() *OutboundHealth {
+	c := *h
+	c.History = slices.Clone(h.History)
+	return &c
+}
+
+// hasObservatory reports whether the config enables an observatory or a burst observatory.
+func hasObservatory(xrayConfig *xray.Config) bool {
+	if xrayConfig == nil {
+		return false
+	}
+	return isConfigSet(xrayConfig.Observatory) || isConfigSet(xrayConfig.BurstObservatory)
+}
+
+// isConfigSet reports whether a raw config section is present and not null.
+func isConfigSet(raw []byte) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+// ensureApiServices enables the Xray API services needed to read observatory results
+// and balancer selections when the config uses them, along with any extra services given.
+func ensureApiServices(xrayConfig *xray.Config, extra ...string) error {
+	if !isConfigSet(xrayConfig.API) {
+		return nil
+	}
+	required := extra
+	if hasObservatory(xrayConfig) {
+		required = append(required, "ObservatoryService")
+	}
+	if _, balancerTags := getRoutingTags(xrayConfig); len(balancerTags) > 0 {
+		required = append(required, "RoutingService")
+	}
+	if len(required) == 0 {
+		return nil
+	}
+
+	api := map[string]any{}
+	if err := json.Unmarshal(xrayConfig.API, &api); err != nil {
+		return err
+	}
+	services, _ := api["services"].([]any)
+	changed := false
+	for _, service := range required {
+		if !slices.Contains(services, any(service)) {
+			services = append(services, service)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	api["services"] = services
+	data, err := json.MarshalIndent(api, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.API = data
+	return nil
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Retention of outbound traffic history buckets.
+const (
+	outboundHourlyRetention = 30 * 24 * time.Hour
+	outboundDailyRetention  = 400 * 24 * time.Hour
+)
+
+// addOutboundTrafficHistory adds a traffic delta to the hourly and daily buckets of an outbound.
+func (s *OutboundService) addOutboundTrafficHistory(tx *gorm.DB, traffic *xray.Traffic, now time.Time) error {
+	if traffic.Up == 0 && traffic.Down == 0 {
+		return nil
+	}
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	now = now.In(loc)
+	buckets := map[string]int64{
+		"hour": time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, loc).Unix(),
+		"day":  time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).Unix(),
+	}
+	for period, bucket := range buckets {
+		history := &model.OutboundTrafficHistory{
+			Tag:    traffic.Tag,
+			Period: period,
+			Time:   bucket,
+			Up:     traffic.Up,
+			Down:   traffic.Down,
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "tag"}, {Name: "period"}, {Name: "time"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"up":   gorm.Expr("up + ?", traffic.Up),
+				"down": gorm.Expr("down + ?", traffic.Down),
+			}),
+		}).Create(history).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOutboundTrafficHistory returns the hourly or daily traffic buckets of an outbound
+// between from and to (Unix seconds, 0 for no bound).
+func (s *OutboundService) GetOutboundTrafficHistory(tag string, period string, from int64, to int64) ([]*model.OutboundTrafficHistory, error) {
+	if period != "hour" && period != "day" {
+		return nil, common.NewError("invalid history period:", period)
+	}
+	db := database.GetDB()
+	query := db.Model(model.OutboundTrafficHistory{}).Where("tag = ? AND period = ?", tag, period)
+	if from > 0 {
+		query = query.Where("time >= ?", from)
+	}
+	if to > 0 {
+		query = query.Where("time <= ?", to)
+	}
+	var history []*model.OutboundTrafficHistory
+	err := query.Order("time asc").Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// UpdateOutboundQuota saves the quota, reset day and quota action of an outbound.
+// Returns whether the change affects routing and Xray needs restart.
+func (s *OutboundService) UpdateOutboundQuota(limit *model.OutboundTraffics) (bool, error) {
+	if limit.Quota < 0 {
+		return false, common.NewError("invalid quota:", limit.Quota)
+	}
+	if limit.ResetDay < 0 || limit.ResetDay > 31 {
+		return false, common.NewError("invalid reset day:", limit.ResetDay)
+	}
+	switch limit.QuotaAction {
+	case "":
+		limit.QuotaAction = "alert"
+	case "alert":
+	case "reroute":
+		if limit.FallbackTag == "" || limit.FallbackTag == limit.Tag {
+			return false, common.NewError("invalid fallback outbound:", limit.FallbackTag)
+		}
+	default:
+		return false, common.NewError("invalid quota action:", limit.QuotaAction)
+	}
+
+	db := database.GetDB()
+	var outbound model.OutboundTraffics
+	err := db.Model(&model.OutboundTraffics{}).Where("tag = ?", limit.Tag).
+		FirstOrCreate(&outbound, model.OutboundTraffics{Tag: limit.Tag}).Error
+	if err != nil {
+		return false, err
+	}
+
+	wasRerouted := outbound.QuotaExceeded && outbound.QuotaAction == "reroute"
+	exceeded := limit.Quota > 0 && outbound.Total >= limit.Quota
+	updates := map[string]any{
+		"quota":          limit.Quota,
+		"reset_day":      limit.ResetDay,
+		"quota_action":   limit.QuotaAction,
+		"fallback_tag":   limit.FallbackTag,
+		"quota_exceeded": exceeded,
+	}
+	if limit.ResetDay > 0 && (outbound.ResetDay == 0 || outbound.LastResetTime == 0) {
+		// The current period starts now, otherwise the next tick would reset the counters at once
+		updates["last_reset_time"] = time.Now().Unix()
+	}
+	err = db.Model(&outbound).Updates(updates).Error
+	if err != nil {
+		return false, err
+	}
+	isRerouted := exceeded && limit.QuotaAction == "reroute"
+	return wasRerouted || isRerouted, nil
+}
+
+// CheckOutboundQuotas marks outbounds that reached their quota in the current period.
+// Returns the newly exceeded outbounds and whether a reroute requires an Xray restart.
+func (s *OutboundService) CheckOutboundQuotas() ([]*model.OutboundTraffics, bool, error) {
+	db := database.GetDB()
+	var exceeded []*model.OutboundTraffics
+	err := db.Model(model.OutboundTraffics{}).
+		Where("quota > 0 AND total >= quota AND quota_exceeded = ?", false).
+		Find(&exceeded).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	needRestart := false
+	for _, outbound := range exceeded {
+		err = db.Model(outbound).Update("quota_exceeded", true).Error
+		if err != nil {
+			return nil, false, err
+		}
+		outbound.QuotaExceeded = true
+		if outbound.QuotaAction == "reroute" {
+			needRestart = true
+		}
+		logger.Infof("Outbound %s reached its traffic quota", outbound.Tag)
+	}
+	return exceeded, needRestart, nil
+}
+
+// ResetDueOutboundTraffics resets outbounds whose monthly reset day has come.
+// Days beyond the end of a month reset on its last day.
+// Returns the reset tags and whether a rerouted outbound was restored.
+func (s *OutboundService) ResetDueOutboundTraffics() ([]string, bool, error) {
+	db := database.GetDB()
+	var outbounds []*model.OutboundTraffics
+	err := db.Model(model.OutboundTraffics{}).Where("reset_day > 0").Find(&outbounds).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	loc, err := s.settingService.GetTimeLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	now := time.Now().In(loc)
+
+	var tags []string
+	needRestart := false
+	for _, outbound := range outbounds {
+		resetAt := monthlyResetTime(now, outbound.ResetDay)
+		if now.Before(resetAt) {
+			resetAt = monthlyResetTime(now.AddDate(0, 0, -now.Day()), outbound.ResetDay)
+		}
+		if outbound.LastResetTime >= resetAt.Unix() {
+			continue
+		}
+		restart, err := s.ResetOutboundTraffic(outbound.Tag)
+		if err != nil {
+			return tags, needRestart, err
+		}
+		needRestart = needRestart || restart
+		tags = append(tags, outbound.Tag)
+	}
+	return tags, needRestart, nil
+}
+
+// monthlyResetTime returns midnight of the given reset day in the month of t,
+// clamped to the last day of that month.
+func monthlyResetTime(t time.Time, day int) time.Time {
+	lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, t.Location())
+}
+
+// DelOldOutboundTrafficHistory removes history buckets past their retention period.
+func (s *OutboundService) DelOldOutboundTrafficHistory() error {
+	db := database.GetDB()
+	now := time.Now()
+	err := db.Where("period = ? AND time < ?", "hour", now.Add(-outboundHourlyRetention).Unix()).
+		Delete(model.OutboundTrafficHistory{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Where("period = ? AND time < ?", "day", now.Add(-outboundDailyRetention).Unix()).
+		Delete(model.OutboundTrafficHistory{}).Error
+}
+
+// applyQuotaReroutes points routing rules of outbounds that hit their quota with the
+// reroute action to their fallback outbound. When the default outbound is rerouted,
+// a catch-all rule is appended so unmatched traffic also uses the fallback.
+func (s *OutboundService) applyQuotaReroutes(xrayConfig *xray.Config) error {
+	db := database.GetDB()
+	var exceeded []*model.OutboundTraffics
+	err := db.Model(model.OutboundTraffics{}).
+		Where("quota_exceeded = ? AND quota_action = ? AND fallback_tag <> ''", true, "reroute").
+		Find(&exceeded).Error
+	if err != nil || len(exceeded) == 0 {
+		return err
+	}
+
+	outboundTags, _ := getRoutingTags(xrayConfig)
+	fallbacks := map[string]string{}
+	for _, outbound := range exceeded {
+		if !outboundTags[outbound.FallbackTag] {
+			logger.Warningf("Skip reroute of outbound %s: unknown fallback %s", outbound.Tag, outbound.FallbackTag)
+			continue
+		}
+		fallbacks[outbound.Tag] = outbound.FallbackTag
+	}
+	// Follow chains of exhausted fallbacks, stopping on loops
+	resolve := func(tag string) string {
+		target, ok := fallbacks[tag]
+		if !ok {
+			return tag
+		}
+		visited := map[string]bool{tag: true}
+		for {
+			next, ok := fallbacks[target]
+			if !ok || visited[target] {
+				return target
+			}
+			visited[target] = true
+			target = next
+		}
+	}
+
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	rules, _ := routing["rules"].([]any)
+	for _, r := range rules {
+		rule, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		if tag, ok := rule["outboundTag"].(string); ok {
+			rule["outboundTag"] = resolve(tag)
+		}
+	}
+
+	var outbounds []map[string]any
+	json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds)
+	if len(outbounds) > 0 {
+		if defaultTag, ok := outbounds[0]["tag"].(string); ok && resolve(defaultTag) != defaultTag {
+			rules = append(rules, map[string]any{
+				"type":        "field",
+				"network":     "tcp,udp",
+				"outboundTag": resolve(defaultTag),
+			})
+		}
+	}
+	routing["rules"] = rules
+
+	data, err := json.MarshalIndent(routing, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = data
+	return nil
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/util/proxy"
+)
+
+const (
+	// maxSubscriptionSize limits the body size read from a remote outbound subscription.
+	maxSubscriptionSize = 10 << 20
+	// maxSubscriptionBackoff caps the delay between refreshes of a failing subscription, in minutes.
+	maxSubscriptionBackoff = 24 * 60
+)
+
+// GetSubscriptions returns all outbound subscriptions.
+func (s *OutboundService) GetSubscriptions() ([]*model.OutboundSubscription, error) {
+	db := database.GetDB()
+	var subscriptions []*model.OutboundSubscription
+	err := db.Model(model.OutboundSubscription{}).Order("id asc").Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// GetSubscription returns an outbound subscription by ID.
+func (s *OutboundService) GetSubscription(id int) (*model.OutboundSubscription, error) {
+	db := database.GetDB()
+	subscription := &model.OutboundSubscription{}
+	err := db.Model(model.OutboundSubscription{}).First(subscription, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// AddSubscription stores a new outbound subscription and fetches it right away.
+// A failed first fetch is recorded on the subscription and does not prevent adding it.
+func (s *OutboundService) AddSubscription(subscription *model.OutboundSubscription) (bool, error) {
+	if err := checkSubscription(subscription); err != nil {
+		return false, err
+	}
+	subscription.Id = 0
+	subscription.LastUpdated = 0
+	subscription.LastAttempt = 0
+	subscription.Failures = 0
+	subscription.LastError = ""
+
+	db := database.GetDB()
+	if err := db.Create(subscription).Error; err != nil {
+		return false, err
+	}
+	if subscription.TagPrefix == "" {
+		subscription.TagPrefix = fmt.Sprintf("sub%d", subscription.Id)
+		if err := db.Model(subscription).Update("tag_prefix", subscription.TagPrefix).Error; err != nil {
+			return false, err
+		}
+	}
+	if !subscription.Enable {
+		return false, nil
+	}
+	needRestart, err := s.RefreshSubscription(subscription.Id)
+	if err != nil {
+		logger.Warning("Unable to fetch outbound subscription:", err)
+	}
+	return needRestart, nil
+}
+
+// UpdateSubscription updates the settings of an outbound subscription.
+func (s *OutboundService) UpdateSubscription(subscription *model.OutboundSubscription) (bool, error) {
+	oldSubscription, err := s.GetSubscription(subscription.Id)
+	if err != nil {
+		return false, err
+	}
+	if err := checkSubscription(subscription); err != nil {
+		return false, err
+	}
+	if subscription.TagPrefix == "" {
+		subscription.TagPrefix = oldSubscription.TagPrefix
+	}
+	needRefresh := subscription.Url != oldSubscription.Url || subscription.TagPrefix != oldSubscription.TagPrefix
+
+	oldSubscription.Remark = subscription.Remark
+	oldSubscription.Url = subscription.Url
+	oldSubscription.Enable = subscription.Enable
+	oldSubscription.TagPrefix = subscription.TagPrefix
+	oldSubscription.Interval = subscription.Interval
+
+	db := database.GetDB()
+	if err := db.Save(oldSubscription).Error; err != nil {
+		return false, err
+	}
+	if !needRefresh || !oldSubscription.Enable {
+		return false, nil
+	}
+	needRestart, err := s.RefreshSubscription(oldSubscription.Id)
+	if err != nil {
+		logger.Warning("Unable to fetch outbound subscription:", err)
+	}
+	return needRestart, nil
+}
+
+// DelSubscription deletes an outbound subscription together with its outbounds.
+func (s *OutboundService) DelSubscription(id int) (bool, error) {
+	db := database.GetDB()
+	tx := db.Begin()
+	var err error
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	result := tx.Where("subscription_id = ?", id).Delete(model.Outbound{})
+	if err = result.Error; err != nil {
+		return false, err
+	}
+	err = tx.Delete(model.OutboundSubscription{}, id).Error
+	return result.RowsAffected > 0, err
+}
+
+// RefreshSubscription fetches an outbound subscription and synchronizes its outbounds.
+// Outbounds keep their enable state across refreshes and vanished ones are removed.
+// Returns whether the generated outbounds changed and Xray needs restart.
+func (s *OutboundService) RefreshSubscription(id int) (bool, error) {
+	subscription, err := s.GetSubscription(id)
+	if err != nil {
+		return false, err
+	}
+
+	db := database.GetDB()
+	parsed, err := s.fetchSubscription(subscription.Url)
+	if err != nil {
+		recordSubscriptionFailure(subscription, err)
+		return false, err
+	}
+
+	usedTags, err := s.getUsedTags(subscription.Id)
+	if err != nil {
+		return false, err
+	}
+	outbounds, err := s.toOutbounds(parsed, subscription.TagPrefix, usedTags)
+	if err != nil {
+		recordSubscriptionFailure(subscription, err)
+		return false, err
+	}
+
+	var oldOutbounds []*model.Outbound
+	if err = db.Where("subscription_id = ?", subscription.Id).Find(&oldOutbounds).Error; err != nil {
+		return false, err
+	}
+	oldByTag := make(map[string]*model.Outbound, len(oldOutbounds))
+	for _, outbound := range oldOutbounds {
+		oldByTag[outbound.Tag] = outbound
+	}
+
+	needRestart := false
+	tx := db.Begin()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	for _, outbound := range outbounds {
+		outbound.SubscriptionId = subscription.Id
+		old, ok := oldByTag[outbound.Tag]
+		if !ok {
+			outbound.Enable = true
+			if err = tx.Create(outbound).Error; err != nil {
+				return false, err
+			}
+			needRestart = true
+			continue
+		}
+		delete(oldByTag, outbound.Tag)
+		if old.Config == outbound.Config && old.Link == outbound.Link && old.Remark == outbound.Remark {
+			continue
+		}
+		old.Remark = outbound.Remark
+		old.Protocol = outbound.Protocol
+		old.Config = outbound.Config
+		old.Link = outbound.Link
+		if err = tx.Save(old).Error; err != nil {
+			return false, err
+		}
+		needRestart = needRestart || old.Enable
+	}
+	for _, old := range oldByTag {
+		if err = tx.Delete(model.Outbound{}, old.Id).Error; err != nil {
+			return false, err
+		}
+		needRestart = needRestart || old.Enable
+	}
+
+	now := time.Now().Unix()
+	err = tx.Model(subscription).Updates(map[string]any{
+		"last_updated": now,
+		"last_attempt": now,
+		"failures":     0,
+		"last_error":   "",
+	}).Error
+	if err != nil {
+		return false, err
+	}
+	logger.Infof("Outbound subscription %d refreshed: %d outbounds", subscription.Id, len(outbounds))
+	return needRestart, nil
+}
+
+// recordSubscriptionFailure stores a failed refresh attempt so that the subscription is retried later.
+func recordSubscriptionFailure(subscription *model.OutboundSubscription, refreshErr error) {
+	db := database.GetDB()
+	err := db.Model(subscription).Updates(map[string]any{
+		"last_attempt": time.Now().Unix(),
+		"failures":     subscription.Failures + 1,
+		"last_error":   refreshErr.Error(),
+	}).Error
+	if err != nil {
+		logger.Warning("Unable to record outbound subscription failure:", err)
+	}
+}
+
+// RefreshDueSubscriptions refreshes every enabled subscription whose interval has elapsed.
+// A failing subscription waits twice as long after each failure, up to maxSubscriptionBackoff.
+func (s *OutboundService) RefreshDueSubscriptions() (bool, error) {
+	subscriptions, err := s.GetSubscriptions()
+	if err != nil {
+		return false, err
+	}
+	needRestart := false
+	now := time.Now().Unix()
+	for _, subscription := range subscriptions {
+		if !subscription.Enable || subscription.Interval <= 0 {
+			continue
+		}
+		if now-subscription.LastUpdated < int64(subscription.Interval)*60 {
+			continue
+		}
+		if subscription.Failures > 0 {
+			backoff := int64(subscription.Interval)
+			for i := 1; i < subscription.Failures && backoff < maxSubscriptionBackoff; i++ {
+				backoff *= 2
+			}
+			backoff = max(min(backoff, maxSubscriptionBackoff), int64(subscription.Interval))
+			if now-subscription.LastAttempt < backoff*60 {
+				continue
+			}
+		}
+		changed, err := s.RefreshSubscription(subscription.Id)
+		if err != nil {
+			logger.Warningf("Unable to refresh outbound subscription %d: %v", subscription.Id, err)
+			continue
+		}
+		needRestart = needRestart || changed
+	}
+	return needRestart, nil
+}
+
+// fetchSubscription downloads and parses a subscription body.
+func (s *OutboundService) fetchSubscription(subscriptionUrl string) ([]*proxy.ParsedOutbound, error) {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	req, err := http.NewRequest(http.MethodGet, subscriptionUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "3x-ui")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("subscription returned status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSubscriptionSize))
+	if err != nil {
+		return nil, err
+	}
+	return proxy.ParseSubscription(string(body))
+}
+
+// checkSubscription validates the URL and interval of an outbound subscription.
+func checkSubscription(subscription *model.OutboundSubscription) error {
+	subscription.Url = strings.TrimSpace(subscription.Url)
+	subscription.TagPrefix = strings.TrimSpace(subscription.TagPrefix)
+	u, err := url.Parse(subscription.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return common.NewError("invalid subscription url:", subscription.Url)
+	}
+	if subscription.Interval < 0 {
+		return common.NewError("invalid subscription interval:", subscription.Interval)
+	}
+	return nil
+}
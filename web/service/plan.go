@@ -0,0 +1,94 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/util/common"
+)
+
+// PlanService manages the plans clients can be assigned to through their planId.
+type PlanService struct{}
+
+// GetPlans returns every plan ordered by id.
+func (s *PlanService) GetPlans() ([]*model.Plan, error) {
+	db := database.GetDB()
+	var plans []*model.Plan
+	if err := db.Model(model.Plan{}).Order("id asc").Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// GetPlan returns the plan with the given id.
+func (s *PlanService) GetPlan(id int) (*model.Plan, error) {
+	db := database.GetDB()
+	plan := &model.Plan{}
+	if err := db.Model(model.Plan{}).First(plan, id).Error; err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// getPlanMap returns every plan keyed by id.
+func (s *PlanService) getPlanMap() (map[int]*model.Plan, error) {
+	plans, err := s.GetPlans()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]*model.Plan, len(plans))
+	for _, plan := range plans {
+		result[plan.Id] = plan
+	}
+	return result, nil
+}
+
+// AddPlan stores a new plan.
+func (s *PlanService) AddPlan(plan *model.Plan) (*model.Plan, error) {
+	if err := s.checkPlan(plan); err != nil {
+		return nil, err
+	}
+	plan.Id = 0
+	db := database.GetDB()
+	if err := db.Create(plan).Error; err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// UpdatePlan replaces the limits of an existing plan.
+func (s *PlanService) UpdatePlan(plan *model.Plan) (*model.Plan, error) {
+	if err := s.checkPlan(plan); err != nil {
+		return nil, err
+	}
+	if _, err := s.GetPlan(plan.Id); err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	if err := db.Save(plan).Error; err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// DelPlan deletes a plan. Clients still pointing at it fall back to their inbound's limits.
+func (s *PlanService) DelPlan(id int) error {
+	db := database.GetDB()
+	return db.Delete(model.Plan{}, id).Error
+}
+
+func (s *PlanService) checkPlan(plan *model.Plan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return common.NewError("plan name is empty")
+	}
+	if plan.SpeedUp < 0 || plan.SpeedDown < 0 || plan.SoftQuotaGB < 0 || plan.RotateDays < 0 ||
+		plan.Price < 0 || plan.RenewDays < 0 || plan.RenewGB < 0 {
+		return common.NewError("plan limits are not valid:", plan.Name)
+	}
+	if _, err := parseAccessSchedule(plan.AccessSchedule); err != nil {
+		return err
+	}
+	return nil
+}
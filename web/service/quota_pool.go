@@ -0,0 +1,178 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// PoolMember is the usage of one client drawing from a quota pool.
+type PoolMember struct {
+	Email  string `json:"email"`
+	Up     int64  `json:"up"`
+	Down   int64  `json:"down"`
+	Enable bool   `json:"enable"`
+}
+
+// QuotaPoolUsage is a quota pool with the usage of its members.
+type QuotaPoolUsage struct {
+	model.QuotaPool
+	Used    int64         `json:"used"`
+	Members []*PoolMember `json:"members"`
+}
+
+// QuotaPoolService manages the traffic quotas shared by several clients.
+// Clients join a pool through the poolId of their settings.
+type QuotaPoolService struct {
+	inboundService InboundService
+}
+
+// GetPools returns every pool with its usage.
+func (s *QuotaPoolService) GetPools() ([]*QuotaPoolUsage, error) {
+	db := database.GetDB()
+	var pools []*model.QuotaPool
+	if err := db.Model(model.QuotaPool{}).Order("id asc").Find(&pools).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*QuotaPoolUsage, 0, len(pools))
+	for _, pool := range pools {
+		usage, err := s.inboundService.getPoolUsage(db, pool)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, usage)
+	}
+	return result, nil
+}
+
+// GetPool returns a pool with its usage.
+func (s *QuotaPoolService) GetPool(id int) (*QuotaPoolUsage, error) {
+	db := database.GetDB()
+	pool := &model.QuotaPool{}
+	if err := db.Model(model.QuotaPool{}).First(pool, id).Error; err != nil {
+		return nil, err
+	}
+	return s.inboundService.getPoolUsage(db, pool)
+}
+
+// AddPool stores a new pool.
+func (s *QuotaPoolService) AddPool(pool *model.QuotaPool) (*model.QuotaPool, error) {
+	if err := s.checkPool(pool); err != nil {
+		return nil, err
+	}
+	pool.Id = 0
+	db := database.GetDB()
+	if err := db.Create(pool).Error; err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// UpdatePool changes the name and quota of a pool.
+func (s *QuotaPoolService) UpdatePool(pool *model.QuotaPool) (*model.QuotaPool, error) {
+	if err := s.checkPool(pool); err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	old := &model.QuotaPool{}
+	if err := db.Model(model.QuotaPool{}).First(old, pool.Id).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Save(pool).Error; err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// DelPool deletes a pool that no client draws from anymore.
+func (s *QuotaPoolService) DelPool(id int) error {
+	db := database.GetDB()
+	var members int64
+	if err := db.Model(xray.ClientTraffic{}).Where("pool_id = ?", id).Count(&members).Error; err != nil {
+		return err
+	}
+	if members > 0 {
+		return common.NewErrorf("quota pool still has %d members", members)
+	}
+	return db.Delete(model.QuotaPool{}, id).Error
+}
+
+// ResetPool resets the traffic of every member of a pool and enables them again.
+// Returns whether Xray needs a restart.
+func (s *QuotaPoolService) ResetPool(id int) (bool, error) {
+	db := database.GetDB()
+	var members []*xray.ClientTraffic
+	if err := db.Model(xray.ClientTraffic{}).Where("pool_id = ?", id).Find(&members).Error; err != nil {
+		return false, err
+	}
+	needRestart := false
+	for _, member := range members {
+		restart, err := s.inboundService.ResetClientTraffic(member.InboundId, member.Email)
+		if err != nil {
+			return needRestart, err
+		}
+		needRestart = needRestart || restart
+	}
+	return needRestart, nil
+}
+
+func (s *QuotaPoolService) checkPool(pool *model.QuotaPool) error {
+	pool.Name = strings.TrimSpace(pool.Name)
+	if pool.Name == "" {
+		return common.NewError("pool name is empty")
+	}
+	if pool.Total < 0 {
+		return common.NewError("pool quota is not valid:", pool.Total)
+	}
+	return nil
+}
+
+// GetClientPoolUsage returns the pool a client draws from with its usage, or nil when the client is in no pool.
+func (s *InboundService) GetClientPoolUsage(email string) (*QuotaPoolUsage, error) {
+	db := database.GetDB()
+	traffic := &xray.ClientTraffic{}
+	err := db.Model(xray.ClientTraffic{}).Where("email = ?", email).First(traffic).Error
+	if err != nil || traffic.PoolId == 0 {
+		return nil, err
+	}
+	pool := &model.QuotaPool{}
+	err = db.Model(model.QuotaPool{}).First(pool, traffic.PoolId).Error
+	if database.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.getPoolUsage(db, pool)
+}
+
+func (s *InboundService) getPoolUsage(tx *gorm.DB, pool *model.QuotaPool) (*QuotaPoolUsage, error) {
+	var members []*PoolMember
+	err := tx.Model(xray.ClientTraffic{}).
+		Select("email, up, down, enable").
+		Where("pool_id = ?", pool.Id).
+		Order("up + down desc").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	usage := &QuotaPoolUsage{QuotaPool: *pool, Members: members}
+	for _, member := range members {
+		usage.Used += member.Up + member.Down
+	}
+	return usage, nil
+}
+
+// getExhaustedPoolIds returns the pools whose members used up the shared quota.
+func (s *InboundService) getExhaustedPoolIds(tx *gorm.DB) ([]int, error) {
+	var ids []int
+	err := tx.Model(model.QuotaPool{}).
+		Where("total > 0 AND total <= (SELECT COALESCE(SUM(up + down), 0) FROM client_traffics WHERE client_traffics.pool_id = quota_pools.id)").
+		Pluck("id", &ids).Error
+	return ids, err
+}
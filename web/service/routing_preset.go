@@ -0,0 +1,217 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// torrentBlockOutboundTag is the blackhole outbound of the BitTorrent preset.
+// It is kept apart from other blocks so that BitTorrent hits can be told apart in the access log.
+const torrentBlockOutboundTag = "torrent-blocked"
+
+// torrentStrikeCooldown is the minimum time between two strikes of a client,
+// so that a single torrent session counts only once.
+const torrentStrikeCooldown = time.Hour
+
+// routingPresetNames lists the presets in the order their rules are added.
+var routingPresetNames = []string{"bittorrent", "private", "ads", "countries"}
+
+// RoutingPresetService manages the block rule presets added to the Xray routing by the panel
+// and the BitTorrent strike policy built on top of the BitTorrent preset.
+type RoutingPresetService struct {
+	settingService SettingService
+	inboundService InboundService
+}
+
+// GetPresets returns every preset, disabled ones included.
+func (s *RoutingPresetService) GetPresets() ([]*model.RoutingPreset, error) {
+	db := database.GetDB()
+	var stored []*model.RoutingPreset
+	if err := db.Model(model.RoutingPreset{}).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	presets := make([]*model.RoutingPreset, 0, len(routingPresetNames))
+	for _, name := range routingPresetNames {
+		index := slices.IndexFunc(stored, func(preset *model.RoutingPreset) bool { return preset.Name == name })
+		if index >= 0 {
+			presets = append(presets, stored[index])
+		} else {
+			presets = append(presets, &model.RoutingPreset{Name: name})
+		}
+	}
+	return presets, nil
+}
+
+// UpdatePreset saves the state, inbounds and countries of a preset. Returns whether Xray needs a restart.
+func (s *RoutingPresetService) UpdatePreset(preset *model.RoutingPreset) (bool, error) {
+	if !slices.Contains(routingPresetNames, preset.Name) {
+		return false, common.NewError("unknown routing preset:", preset.Name)
+	}
+	preset.InboundTags = strings.Join(splitList(preset.InboundTags), ",")
+	countries := splitList(strings.ToLower(preset.Countries))
+	if preset.Name == "countries" && preset.Enable && len(countries) == 0 {
+		return false, common.NewError("no country to block")
+	}
+	preset.Countries = strings.Join(countries, ",")
+
+	db := database.GetDB()
+	old := &model.RoutingPreset{}
+	err := db.Model(model.RoutingPreset{}).Where("name = ?", preset.Name).First(old).Error
+	if err != nil && !database.IsNotFound(err) {
+		return false, err
+	}
+	preset.Id = old.Id
+	if err := db.Save(preset).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyRoutingPresets puts the block rules of enabled presets ahead of the template rules, which
+// could otherwise route the same traffic elsewhere first.
+func (s *RoutingPresetService) applyRoutingPresets(xrayConfig *xray.Config) error {
+	presets, err := s.GetPresets()
+	if err != nil {
+		return err
+	}
+	var rules []any
+	for _, preset := range presets {
+		if !preset.Enable {
+			continue
+		}
+		rule := map[string]any{
+			"type":    "field",
+			"ruleTag": "preset-" + preset.Name,
+		}
+		switch preset.Name {
+		case "bittorrent":
+			rule["protocol"] = []string{"bittorrent"}
+		case "private":
+			rule["ip"] = []string{"geoip:private"}
+		case "ads":
+			rule["domain"] = []string{"geosite:category-ads-all"}
+		case "countries":
+			var ips []string
+			for _, country := range splitList(preset.Countries) {
+				ips = append(ips, "geoip:"+country)
+			}
+			if len(ips) == 0 {
+				continue
+			}
+			rule["ip"] = ips
+		}
+
+		blockTag := getBlockOutboundTag(xrayConfig)
+		if preset.Name == "bittorrent" {
+			blockTag = torrentBlockOutboundTag
+		}
+		if err := ensureBlackholeOutbound(xrayConfig, blockTag); err != nil {
+			return err
+		}
+		rule["outboundTag"] = blockTag
+		if inboundTags := splitList(preset.InboundTags); len(inboundTags) > 0 {
+			rule["inboundTag"] = inboundTags
+		}
+		rules = append(rules, rule)
+	}
+	return prependRoutingRulesAfterApi(xrayConfig, rules)
+}
+
+// GetStrikes returns the BitTorrent strikes of all clients, the most struck first.
+func (s *RoutingPresetService) GetStrikes() ([]*model.ClientStrike, error) {
+	db := database.GetDB()
+	var strikes []*model.ClientStrike
+	err := db.Model(model.ClientStrike{}).Order("strikes desc, last_strike desc").Find(&strikes).Error
+	if err != nil {
+		return nil, err
+	}
+	return strikes, nil
+}
+
+// ResetStrikes clears the strikes of a client. A client suspended by the policy stays disabled.
+func (s *RoutingPresetService) ResetStrikes(email string) error {
+	db := database.GetDB()
+	return db.Where("email = ?", email).Delete(model.ClientStrike{}).Error
+}
+
+// GetTorrentHits counts the BitTorrent block hits per client in the connection log entries after afterId.
+// It returns the counts and the highest entry id read.
+func (s *RoutingPresetService) GetTorrentHits(afterId int64) (map[string]int64, int64, error) {
+	db := database.GetDB()
+	var lastId int64
+	err := db.Model(model.ConnectionLog{}).Select("COALESCE(MAX(id), 0)").Scan(&lastId).Error
+	if err != nil {
+		return nil, afterId, err
+	}
+	var rows []struct {
+		Email string
+		Count int64
+	}
+	err = db.Model(model.ConnectionLog{}).
+		Select("email, COUNT(*) AS count").
+		Where("id > ? AND id <= ? AND outbound_tag = ? AND email != ?", afterId, lastId, torrentBlockOutboundTag, "").
+		Group("email").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, afterId, err
+	}
+	hits := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		hits[row.Email] = row.Count
+	}
+	return hits, lastId, nil
+}
+
+// AddTorrentStrike records a strike for a client caught hitting the BitTorrent block, at most once
+// per cooldown, and suspends the client once it reaches the strike limit.
+// It returns the strike record, whether a new strike was counted and whether the client got suspended.
+func (s *RoutingPresetService) AddTorrentStrike(email string) (*model.ClientStrike, bool, bool, error) {
+	db := database.GetDB()
+	strike := &model.ClientStrike{}
+	err := db.Model(model.ClientStrike{}).Where("email = ?", email).First(strike).Error
+	if err != nil && !database.IsNotFound(err) {
+		return nil, false, false, err
+	}
+	now := time.Now()
+	if strike.Id > 0 && now.Sub(time.Unix(strike.LastStrike, 0)) < torrentStrikeCooldown {
+		return strike, false, false, nil
+	}
+	strike.Email = email
+	strike.Strikes++
+	strike.LastStrike = now.Unix()
+
+	limit, err := s.settingService.GetTorrentStrikeLimit()
+	if err != nil {
+		limit = 0
+	}
+	suspend := limit > 0 && strike.Strikes >= limit
+	if suspend {
+		if _, _, err := s.inboundService.SetClientEnableByEmail(email, false); err != nil {
+			return strike, true, false, err
+		}
+		strike.Suspended = true
+	}
+	if err := db.Save(strike).Error; err != nil {
+		return strike, true, suspend, err
+	}
+	return strike, true, suspend, nil
+}
+
+// splitList splits a comma or new line separated list, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -47,6 +47,7 @@ var defaultValueMap = map[string]string{
 	"tgBotBackup":                 "false",
 	"tgBotLoginNotify":            "true",
 	"tgCpu":                       "80",
+	"tgOutboundDown":              "5",
 	"tgLang":                      "en-US",
 	"twoFactorEnable":             "false",
 	"twoFactorToken":              "",
@@ -73,6 +74,48 @@ var defaultValueMap = map[string]string{
 	"warp":                        "",
 	"externalTrafficInformEnable": "false",
 	"externalTrafficInformURI":    "",
+	"trafficFlushInterval":        "60",
+	"clientIpSource":              "log",
+	"ipLimitBackend":              "fail2ban",
+	"ipBanDuration":               "30",
+	"ipBanWhitelist":              "",
+	"deviceEvictPolicy":           "newest",
+	"deviceGraceWindow":           "180",
+	"connectionLogEnable":         "false",
+	"connectionLogRetention":      "7",
+	"connectionLogAnonymize":      "false",
+	"accessLogOffset":             "0",
+	"torrentStrikeLimit":          "3",
+	"speedLimitEnable":            "false",
+	"speedLimitInterface":         "",
+	"throttleSpeedUp":             "1",
+	"throttleSpeedDown":           "1",
+	"gracePeriod":                 "0",
+	"graceAction":                 "throttle",
+	"graceOutbound":               "blocked",
+	"autoDeleteDays":              "0",
+	"credentialOverlap":           "60",
+	"leakDetectEnable":            "false",
+	"leakSubIps":                  "20",
+	"leakConnIps":                 "10",
+	"leakAction":                  "none",
+	"forecastRemindDays":          "3",
+	"trialEnable":                 "false",
+	"trialInboundId":              "0",
+	"trialPlanId":                 "0",
+	"trialHours":                  "24",
+	"trialTrafficGB":              "1",
+	"trialDailyCap":               "10",
+	"trustedProxies":              "",
+	"inviteEnable":                "false",
+	"inviteDailyCap":              "10",
+	"inviteClientUses":            "0",
+	"inviteTrafficGB":             "10",
+	"inviteDays":                  "30",
+	"referralBonusGB":             "0",
+	"referralBonusDays":           "0",
+	"billingCurrency":             "USD",
+	"billingInvoiceDays":          "0",
 	// LDAP defaults
 	"ldapEnable":            "false",
 	"ldapHost":              "",
@@ -338,6 +381,10 @@ func (s *SettingService) GetTgCpu() (int, error) {
 	return s.getInt("tgCpu")
 }
 
+func (s *SettingService) GetTgOutboundDown() (int, error) {
+	return s.getInt("tgOutboundDown")
+}
+
 func (s *SettingService) GetTgLang() (string, error) {
 	return s.getString("tgLang")
 }
@@ -555,6 +602,183 @@ func (s *SettingService) SetExternalTrafficInformEnable(value bool) error {
 	return s.setBool("externalTrafficInformEnable", value)
 }
 
+func (s *SettingService) GetTrafficFlushInterval() (int, error) {
+	return s.getInt("trafficFlushInterval")
+}
+
+func (s *SettingService) GetClientIpSource() (string, error) {
+	return s.getString("clientIpSource")
+}
+
+func (s *SettingService) GetIpLimitBackend() (string, error) {
+	return s.getString("ipLimitBackend")
+}
+
+func (s *SettingService) GetIpBanDuration() (int, error) {
+	return s.getInt("ipBanDuration")
+}
+
+func (s *SettingService) GetIpBanWhitelist() (string, error) {
+	return s.getString("ipBanWhitelist")
+}
+
+func (s *SettingService) GetDeviceEvictPolicy() (string, error) {
+	return s.getString("deviceEvictPolicy")
+}
+
+func (s *SettingService) GetDeviceGraceWindow() (int, error) {
+	return s.getInt("deviceGraceWindow")
+}
+
+func (s *SettingService) GetConnectionLogEnable() (bool, error) {
+	return s.getBool("connectionLogEnable")
+}
+
+func (s *SettingService) GetConnectionLogRetention() (int, error) {
+	return s.getInt("connectionLogRetention")
+}
+
+func (s *SettingService) GetConnectionLogAnonymize() (bool, error) {
+	return s.getBool("connectionLogAnonymize")
+}
+
+func (s *SettingService) GetTorrentStrikeLimit() (int, error) {
+	return s.getInt("torrentStrikeLimit")
+}
+
+func (s *SettingService) GetSpeedLimitEnable() (bool, error) {
+	return s.getBool("speedLimitEnable")
+}
+
+func (s *SettingService) GetSpeedLimitInterface() (string, error) {
+	return s.getString("speedLimitInterface")
+}
+
+func (s *SettingService) GetThrottleSpeedUp() (int, error) {
+	return s.getInt("throttleSpeedUp")
+}
+
+func (s *SettingService) GetThrottleSpeedDown() (int, error) {
+	return s.getInt("throttleSpeedDown")
+}
+
+func (s *SettingService) GetGracePeriod() (int, error) {
+	return s.getInt("gracePeriod")
+}
+
+func (s *SettingService) GetGraceAction() (string, error) {
+	return s.getString("graceAction")
+}
+
+func (s *SettingService) GetGraceOutbound() (string, error) {
+	return s.getString("graceOutbound")
+}
+
+func (s *SettingService) GetAutoDeleteDays() (int, error) {
+	return s.getInt("autoDeleteDays")
+}
+
+func (s *SettingService) GetCredentialOverlap() (int, error) {
+	return s.getInt("credentialOverlap")
+}
+
+func (s *SettingService) GetLeakDetectEnable() (bool, error) {
+	return s.getBool("leakDetectEnable")
+}
+
+func (s *SettingService) GetLeakSubIps() (int, error) {
+	return s.getInt("leakSubIps")
+}
+
+func (s *SettingService) GetLeakConnIps() (int, error) {
+	return s.getInt("leakConnIps")
+}
+
+func (s *SettingService) GetLeakAction() (string, error) {
+	return s.getString("leakAction")
+}
+
+func (s *SettingService) GetForecastRemindDays() (int, error) {
+	return s.getInt("forecastRemindDays")
+}
+
+func (s *SettingService) GetTrialEnable() (bool, error) {
+	return s.getBool("trialEnable")
+}
+
+func (s *SettingService) GetTrialInboundId() (int, error) {
+	return s.getInt("trialInboundId")
+}
+
+func (s *SettingService) GetTrialPlanId() (int, error) {
+	return s.getInt("trialPlanId")
+}
+
+func (s *SettingService) GetTrialHours() (int, error) {
+	return s.getInt("trialHours")
+}
+
+func (s *SettingService) GetTrialTrafficGB() (int, error) {
+	return s.getInt("trialTrafficGB")
+}
+
+func (s *SettingService) GetTrialDailyCap() (int, error) {
+	return s.getInt("trialDailyCap")
+}
+
+func (s *SettingService) GetTrustedProxies() (string, error) {
+	return s.getString("trustedProxies")
+}
+
+func (s *SettingService) GetInviteEnable() (bool, error) {
+	return s.getBool("inviteEnable")
+}
+
+func (s *SettingService) GetInviteDailyCap() (int, error) {
+	return s.getInt("inviteDailyCap")
+}
+
+func (s *SettingService) GetInviteClientUses() (int, error) {
+	return s.getInt("inviteClientUses")
+}
+
+func (s *SettingService) GetInviteTrafficGB() (int, error) {
+	return s.getInt("inviteTrafficGB")
+}
+
+func (s *SettingService) GetInviteDays() (int, error) {
+	return s.getInt("inviteDays")
+}
+
+func (s *SettingService) GetReferralBonusGB() (int, error) {
+	return s.getInt("referralBonusGB")
+}
+
+func (s *SettingService) GetReferralBonusDays() (int, error) {
+	return s.getInt("referralBonusDays")
+}
+
+func (s *SettingService) GetBillingCurrency() (string, error) {
+	return s.getString("billingCurrency")
+}
+
+func (s *SettingService) GetBillingInvoiceDays() (int, error) {
+	return s.getInt("billingInvoiceDays")
+}
+
+// GetAccessLogOffset returns how far the access log has been ingested into the connection log.
+func (s *SettingService) GetAccessLogOffset() (int64, error) {
+	str, err := s.getString("accessLogOffset")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+func (s *SettingService) SetAccessLogOffset(offset int64) error {
+	return s.setString("accessLogOffset", strconv.FormatInt(offset, 10))
+}
+
 func (s *SettingService) GetExternalTrafficInformURI() (string, error) {
 	return s.getString("externalTrafficInformURI")
 }
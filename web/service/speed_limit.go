@@ -0,0 +1,472 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+)
+
+const (
+	// speedLimitIfb is the intermediate device ingress traffic is redirected to so that uploads can be shaped.
+	speedLimitIfb = "ifb0"
+	// speedLimitHandle is the handle of the panel's root qdiscs. Qdiscs with other handles belong to
+	// the host and are never removed.
+	speedLimitHandle = "5855:"
+	// speedLimitFirstMinor is the class minor number given to the first shaped client.
+	speedLimitFirstMinor = 0x10
+)
+
+// speedLimitState remembers what was applied, so that tc is only touched for clients whose caps or IPs changed.
+var speedLimitState struct {
+	lock    sync.Mutex
+	iface   string
+	ready   bool // The qdiscs are set up on iface
+	clients map[string]*shapedClient
+}
+
+// shapedClient is the shaping applied to a client on both devices.
+type shapedClient struct {
+	minor int      // Minor number of the client's class, its filters use the priorities derived from it
+	up    int      // Upload cap in Mbps, 0 for none
+	down  int      // Download cap in Mbps, 0 for none
+	ips   []string // Shaped IPs, sorted
+}
+
+// ClientSpeed is the effective speed cap of a client.
+type ClientSpeed struct {
+	Email     string `json:"email"`
+	Up        int    `json:"up"`        // Upload cap in Mbps, 0 for no cap
+	Down      int    `json:"down"`      // Download cap in Mbps, 0 for no cap
+	Throttled bool   `json:"throttled"` // The client used up its soft quota and is in the throttled tier
+}
+
+// SpeedLimitService caps the bandwidth of clients.
+//
+// Xray policy levels only carry timeouts, buffer sizes and stats switches, there is no bandwidth
+// setting to map clients to. Caps are therefore enforced with Linux tc, keyed by the online IPs
+// of each client: an HTB qdisc on the interface shapes downloads and an HTB qdisc on an ifb device
+// fed by the interface's ingress shapes uploads. Each client gets one class shared by all its IPs,
+// updated in place when its caps or IPs change.
+type SpeedLimitService struct {
+	settingService SettingService
+	inboundService InboundService
+	planService    PlanService
+	run            commandRunner
+}
+
+// IsEnabled reports whether client speed caps are enforced.
+func (s *SpeedLimitService) IsEnabled() bool {
+	enable, err := s.settingService.GetSpeedLimitEnable()
+	return err == nil && enable
+}
+
+// GetClientSpeeds returns the effective speed caps of every client that has one.
+// A client's own cap wins over its plan's, which wins over its inbound's. Clients whose usage
+// reached their soft quota, or that are in their grace period when it throttles, are held to the
+// throttle speeds instead when those are lower.
+func (s *SpeedLimitService) GetClientSpeeds() (map[string]*ClientSpeed, error) {
+	inbounds, err := s.inboundService.GetAllInbounds()
+	if err != nil {
+		return nil, err
+	}
+	plans, err := s.planService.getPlanMap()
+	if err != nil {
+		return nil, err
+	}
+	throttleUp, err := s.settingService.GetThrottleSpeedUp()
+	if err != nil {
+		throttleUp = 1
+	}
+	throttleDown, err := s.settingService.GetThrottleSpeedDown()
+	if err != nil {
+		throttleDown = 1
+	}
+	graceThrottled := s.inboundService.isGraceThrottled()
+
+	speeds := map[string]*ClientSpeed{}
+	for _, inbound := range inbounds {
+		clients, err := s.inboundService.GetClients(inbound)
+		if err != nil {
+			continue
+		}
+		usage := make(map[string]int64, len(inbound.ClientStats))
+		inGrace := map[string]bool{}
+		for _, traffic := range inbound.ClientStats {
+			usage[traffic.Email] = traffic.Up + traffic.Down
+			inGrace[traffic.Email] = traffic.Stage == ClientStageGrace
+		}
+		for _, client := range clients {
+			if !client.Enable || client.Email == "" {
+				continue
+			}
+			plan := plans[client.PlanId]
+			if plan == nil {
+				plan = &model.Plan{}
+			}
+			speed := &ClientSpeed{
+				Email: client.Email,
+				Up:    firstPositive(client.SpeedUp, plan.SpeedUp, inbound.SpeedUp),
+				Down:  firstPositive(client.SpeedDown, plan.SpeedDown, inbound.SpeedDown),
+			}
+			softQuota := client.SoftQuotaGB
+			if softQuota == 0 {
+				softQuota = plan.SoftQuotaGB
+			}
+			if (softQuota > 0 && usage[client.Email] >= softQuota*1024*1024*1024) || (graceThrottled && inGrace[client.Email]) {
+				speed.Throttled = true
+				speed.Up = lowerCap(speed.Up, throttleUp)
+				speed.Down = lowerCap(speed.Down, throttleDown)
+			}
+			if speed.Up > 0 || speed.Down > 0 {
+				speeds[client.Email] = speed
+			}
+		}
+	}
+	return speeds, nil
+}
+
+// ApplySpeedLimits shapes the online IPs of clients with a speed cap.
+// Only the clients whose caps or IPs changed since the last call are updated.
+func (s *SpeedLimitService) ApplySpeedLimits() error {
+	if runtime.GOOS != "linux" {
+		return common.NewError("speed limits need Linux tc")
+	}
+	iface, err := s.getInterface()
+	if err != nil {
+		return err
+	}
+	speeds, err := s.GetClientSpeeds()
+	if err != nil {
+		return err
+	}
+	clientIps := s.getOnlineClientIps()
+
+	wanted := map[string]*shapedClient{}
+	for email, speed := range speeds {
+		if len(clientIps[email]) == 0 {
+			continue
+		}
+		ips := append([]string(nil), clientIps[email]...)
+		sort.Strings(ips)
+		wanted[email] = &shapedClient{up: speed.Up, down: speed.Down, ips: ips}
+	}
+
+	speedLimitState.lock.Lock()
+	defer speedLimitState.lock.Unlock()
+	run := s.getRunner()
+	if speedLimitState.iface != iface {
+		if speedLimitState.iface != "" {
+			clearShaping(run, speedLimitState.iface)
+		} else {
+			// Left over from a previous run of the panel
+			clearShaping(run, iface)
+		}
+		speedLimitState.iface = iface
+		speedLimitState.ready = false
+		speedLimitState.clients = map[string]*shapedClient{}
+	}
+	if len(wanted) == 0 {
+		if speedLimitState.ready {
+			clearShaping(run, iface)
+			speedLimitState.ready = false
+			speedLimitState.clients = map[string]*shapedClient{}
+		}
+		return nil
+	}
+	if !speedLimitState.ready {
+		if err := setupShaping(run, iface); err != nil {
+			return err
+		}
+		speedLimitState.ready = true
+	}
+
+	changed := 0
+	for email, applied := range speedLimitState.clients {
+		if wanted[email] == nil {
+			removeShapedClient(run, iface, applied)
+			delete(speedLimitState.clients, email)
+			changed++
+		}
+	}
+	emails := make([]string, 0, len(wanted))
+	for email := range wanted {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	for _, email := range emails {
+		applied := speedLimitState.clients[email]
+		if applied == nil {
+			applied = &shapedClient{minor: nextShapedMinor()}
+			speedLimitState.clients[email] = applied
+		}
+		want := wanted[email]
+		if applied.up == want.up && applied.down == want.down && slices.Equal(applied.ips, want.ips) {
+			continue
+		}
+		if err := updateShapedClient(run, iface, applied, want); err != nil {
+			// Start over on the next call rather than keep a half applied state
+			clearShaping(run, iface)
+			speedLimitState.ready = false
+			speedLimitState.clients = map[string]*shapedClient{}
+			return err
+		}
+		changed++
+	}
+	if changed > 0 {
+		logger.Debugf("Speed limits updated for %d clients, %d online clients shaped on %s", changed, len(emails), iface)
+	}
+	return nil
+}
+
+// ClearSpeedLimits removes the shaping added by ApplySpeedLimits.
+func (s *SpeedLimitService) ClearSpeedLimits() {
+	speedLimitState.lock.Lock()
+	defer speedLimitState.lock.Unlock()
+	if speedLimitState.ready {
+		clearShaping(s.getRunner(), speedLimitState.iface)
+	}
+	speedLimitState.iface = ""
+	speedLimitState.ready = false
+	speedLimitState.clients = nil
+}
+
+func (s *SpeedLimitService) getRunner() commandRunner {
+	if s.run != nil {
+		return s.run
+	}
+	return runCommand
+}
+
+// getInterface returns the configured interface, or the interface of the default route.
+func (s *SpeedLimitService) getInterface() (string, error) {
+	iface, err := s.settingService.GetSpeedLimitInterface()
+	if err == nil && strings.TrimSpace(iface) != "" {
+		return strings.TrimSpace(iface), nil
+	}
+	out, err := s.getRunner()("ip", "route", "show", "default")
+	if err != nil {
+		return "", common.NewErrorf("default route lookup failed: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	fields := strings.Fields(string(out))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "dev" {
+			return fields[i+1], nil
+		}
+	}
+	return "", common.NewError("no default route interface found")
+}
+
+// getOnlineClientIps returns the IPs of online clients, from the Xray stats service or from the
+// IPs recorded out of the access log.
+func (s *SpeedLimitService) getOnlineClientIps() map[string][]string {
+	if s.inboundService.IsStatsIpSource() {
+		return s.inboundService.GetOnlineClientIps()
+	}
+	result := map[string][]string{}
+	if p == nil || !p.IsRunning() {
+		return result
+	}
+	emails := s.inboundService.GetOnlineClients()
+	if len(emails) == 0 {
+		return result
+	}
+	db := database.GetDB()
+	var records []*model.InboundClientIps
+	if err := db.Model(model.InboundClientIps{}).Where("client_email IN ?", emails).Find(&records).Error; err != nil {
+		return result
+	}
+	for _, record := range records {
+		var ips []string
+		if err := json.Unmarshal([]byte(record.Ips), &ips); err == nil && len(ips) > 0 {
+			result[record.ClientEmail] = ips
+		}
+	}
+	return result
+}
+
+// setupShaping adds the panel's qdiscs. It fails rather than replace qdiscs set up by the host.
+func setupShaping(run commandRunner, iface string) error {
+	if handle := getQdiscHandle(run, iface, "root"); handle != "" && handle != "0:" {
+		return common.NewErrorf("%s already has a root qdisc %s", iface, handle)
+	}
+	if getQdiscHandle(run, iface, "ingress") != "" {
+		return common.NewErrorf("%s already has an ingress qdisc", iface)
+	}
+	// The ifb device may already exist, a real failure shows up when it is set up
+	run("ip", "link", "add", speedLimitIfb, "type", "ifb")
+	if out, err := run("ip", "link", "set", "dev", speedLimitIfb, "up"); err != nil {
+		return common.NewErrorf("ifb setup failed: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	commands := [][]string{
+		{"tc", "qdisc", "add", "dev", iface, "root", "handle", speedLimitHandle, "htb"},
+		{"tc", "qdisc", "add", "dev", iface, "handle", "ffff:", "ingress"},
+		{"tc", "qdisc", "replace", "dev", speedLimitIfb, "root", "handle", speedLimitHandle, "htb"},
+		{"tc", "filter", "add", "dev", iface, "parent", "ffff:", "protocol", "all", "u32", "match", "u32", "0", "0",
+			"action", "mirred", "egress", "redirect", "dev", speedLimitIfb},
+	}
+	for i, command := range commands {
+		if out, err := run(command[0], command[1:]...); err != nil {
+			// Undo what was added, the ingress qdisc cannot be told apart from the host's without its filter
+			if i > 1 {
+				run("tc", "qdisc", "del", "dev", iface, "ingress")
+			}
+			if i > 0 {
+				run("tc", "qdisc", "del", "dev", iface, "root", "handle", speedLimitHandle)
+			}
+			return common.NewErrorf("tc setup failed: %v %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// updateShapedClient brings the class and filters of a client from applied to want.
+func updateShapedClient(run commandRunner, iface string, applied *shapedClient, want *shapedClient) error {
+	ipsChanged := !slices.Equal(applied.ips, want.ips)
+	if err := shapeDirection(run, iface, applied.minor, applied.down, want.down, ipsChanged, want.ips, "dst"); err != nil {
+		return err
+	}
+	if err := shapeDirection(run, speedLimitIfb, applied.minor, applied.up, want.up, ipsChanged, want.ips, "src"); err != nil {
+		return err
+	}
+	applied.up = want.up
+	applied.down = want.down
+	applied.ips = want.ips
+	return nil
+}
+
+// shapeDirection updates the class of a client on dev from oldMbps to mbps and sends the traffic of
+// its IPs to it. Traffic matching no class is left unshaped.
+func shapeDirection(run commandRunner, dev string, minor int, oldMbps int, mbps int, ipsChanged bool, ips []string, direction string) error {
+	if mbps <= 0 {
+		if oldMbps > 0 {
+			delShapedClass(run, dev, minor)
+		}
+		return nil
+	}
+	classId := shapedClassId(minor)
+	if mbps != oldMbps {
+		rate := fmt.Sprintf("%dmbit", mbps)
+		out, err := run("tc", "class", "replace", "dev", dev, "parent", speedLimitHandle, "classid", classId, "htb", "rate", rate, "ceil", rate)
+		if err != nil {
+			return common.NewErrorf("tc class %s failed: %v %s", classId, err, strings.TrimSpace(string(out)))
+		}
+	}
+	if oldMbps > 0 && !ipsChanged {
+		return nil
+	}
+	delShapedFilters(run, dev, minor)
+	for _, ip := range ips {
+		// Filters of one priority must share a protocol, so IPv6 gets its own
+		protocol, prio, match, prefix := "ip", minor*2, "ip", "/32"
+		if isIPv6(ip) {
+			protocol, prio, match, prefix = "ipv6", minor*2+1, "ip6", "/128"
+		}
+		out, err := run("tc", "filter", "add", "dev", dev, "parent", speedLimitHandle, "protocol", protocol, "prio", strconv.Itoa(prio),
+			"u32", "match", match, direction, ip+prefix, "flowid", classId)
+		if err != nil {
+			return common.NewErrorf("tc filter %s failed: %v %s", ip, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// removeShapedClient deletes the classes and filters of a client.
+func removeShapedClient(run commandRunner, iface string, applied *shapedClient) {
+	if applied.down > 0 {
+		delShapedClass(run, iface, applied.minor)
+	}
+	if applied.up > 0 {
+		delShapedClass(run, speedLimitIfb, applied.minor)
+	}
+}
+
+// delShapedFilters deletes the filters of a client on dev. Missing filters are not an error.
+func delShapedFilters(run commandRunner, dev string, minor int) {
+	run("tc", "filter", "del", "dev", dev, "parent", speedLimitHandle, "protocol", "ip", "prio", strconv.Itoa(minor*2))
+	run("tc", "filter", "del", "dev", dev, "parent", speedLimitHandle, "protocol", "ipv6", "prio", strconv.Itoa(minor*2+1))
+}
+
+// delShapedClass deletes the class of a client on dev together with its filters.
+func delShapedClass(run commandRunner, dev string, minor int) {
+	delShapedFilters(run, dev, minor)
+	run("tc", "class", "del", "dev", dev, "classid", shapedClassId(minor))
+}
+
+// shapedClassId returns the class id of a client's class.
+func shapedClassId(minor int) string {
+	return fmt.Sprintf("%s%x", speedLimitHandle, minor)
+}
+
+// nextShapedMinor returns the lowest class minor number not used by a shaped client.
+func nextShapedMinor() int {
+	used := map[int]bool{}
+	for _, applied := range speedLimitState.clients {
+		used[applied.minor] = true
+	}
+	minor := speedLimitFirstMinor
+	for used[minor] {
+		minor++
+	}
+	return minor
+}
+
+// getQdiscHandle returns the handle of the root or ingress qdisc of dev, or "" when it has none.
+func getQdiscHandle(run commandRunner, dev string, parent string) string {
+	out, err := run("tc", "qdisc", "show", "dev", dev, parent)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		// qdisc htb 5855: root refcnt 2 ...
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "qdisc" {
+			return fields[2]
+		}
+	}
+	return ""
+}
+
+// clearShaping removes the qdiscs added by setupShaping. Qdiscs of the host are left alone.
+func clearShaping(run commandRunner, iface string) {
+	if getQdiscHandle(run, iface, "root") == speedLimitHandle {
+		run("tc", "qdisc", "del", "dev", iface, "root", "handle", speedLimitHandle)
+	}
+	// The ingress qdisc always has handle ffff:, it is the panel's when it redirects to the ifb device
+	if out, err := run("tc", "filter", "show", "dev", iface, "parent", "ffff:"); err == nil && strings.Contains(string(out), speedLimitIfb) {
+		run("tc", "qdisc", "del", "dev", iface, "ingress")
+	}
+	if getQdiscHandle(run, speedLimitIfb, "root") == speedLimitHandle {
+		run("tc", "qdisc", "del", "dev", speedLimitIfb, "root", "handle", speedLimitHandle)
+	}
+}
+
+// firstPositive returns the first value above zero, or zero.
+func firstPositive(values ...int) int {
+	for _, value := range values {
+		if value > 0 {
+			return value
+		}
+	}
+	return 0
+}
+
+// lowerCap returns the stricter of two caps where zero means no cap.
+func lowerCap(current int, limit int) int {
+	if limit <= 0 {
+		return current
+	}
+	if current <= 0 || limit < current {
+		return limit
+	}
+	return current
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"math/big"
 	"net"
@@ -104,11 +105,14 @@ const (
 // Tgbot provides business logic for Telegram bot integration.
 // It handles bot commands, user interactions, and status reporting via Telegram.
 type Tgbot struct {
-	inboundService InboundService
-	settingService SettingService
-	serverService  ServerService
-	xrayService    XrayService
-	lastStatus     *Status
+	inboundService       InboundService
+	settingService       SettingService
+	serverService        ServerService
+	xrayService          XrayService
+	connectionLogService ConnectionLogService
+	trialService         TrialService
+	inviteService        InviteService
+	lastStatus           *Status
 }
 
 // NewTgbot creates a new Tgbot instance.
@@ -245,6 +249,7 @@ func (t *Tgbot) Start(i18nFS embed.FS) error {
 			{Command: "help", Description: t.I18nBot("tgbot.commands.helpDesc")},
 			{Command: "status", Description: t.I18nBot("tgbot.commands.statusDesc")},
 			{Command: "id", Description: t.I18nBot("tgbot.commands.idDesc")},
+			{Command: "invite", Description: t.I18nBot("tgbot.commands.inviteDesc")},
 		},
 	})
 	if err != nil {
@@ -584,7 +589,7 @@ func (t *Tgbot) OnReceive() {
 
 // answerCommand processes incoming command messages from Telegram users.
 func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin bool) {
-	msg, onlyMessage := "", false
+	msg, onlyMessage, offerTrial := "", false, false
 
 	command, _, commandArgs := tu.ParseCommand(message.Text)
 
@@ -602,6 +607,14 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 		msg += t.I18nBot("tgbot.commands.start", "Firstname=="+message.From.FirstName)
 		if isAdmin {
 			msg += t.I18nBot("tgbot.commands.welcome", "Hostname=="+hostname)
+		} else if len(commandArgs) > 0 {
+			// Invite links open the bot with the code as start parameter
+			onlyMessage = true
+			t.redeemInvite(chatId, message.From.ID, commandArgs[0])
+		} else if t.trialService.IsTrialEnabled() {
+			// Users without any client are offered a trial account
+			traffics, err := t.inboundService.GetClientTrafficTgBot(message.From.ID)
+			offerTrial = err == nil && len(traffics) == 0
 		}
 		msg += "\n\n" + t.I18nBot("tgbot.commands.pleaseChoose")
 	case "status":
@@ -628,6 +641,26 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 		} else {
 			handleUnknownCommand()
 		}
+	case "destinations":
+		onlyMessage = true
+		if !isAdmin {
+			handleUnknownCommand()
+		} else if len(commandArgs) > 0 {
+			t.searchClientDestinations(chatId, commandArgs[0])
+		} else {
+			msg += t.I18nBot("tgbot.commands.destinationsUsage")
+		}
+	case "invite":
+		onlyMessage = true
+		if len(commandArgs) > 0 && !isAdmin {
+			t.redeemInvite(chatId, message.From.ID, commandArgs[0])
+		} else if len(commandArgs) > 0 {
+			t.sendClientInvite(chatId, commandArgs[0])
+		} else if !isAdmin {
+			t.sendOwnInvite(chatId, message.From.ID)
+		} else {
+			msg += t.I18nBot("tgbot.commands.inviteUsage")
+		}
 	case "restart":
 		onlyMessage = true
 		if isAdmin {
@@ -656,6 +689,14 @@ func (t *Tgbot) answerCommand(message *telego.Message, chatId int64, isAdmin boo
 	if msg != "" {
 		t.sendResponse(chatId, msg, onlyMessage, isAdmin)
 	}
+	if offerTrial {
+		inlineKeyboard := tu.InlineKeyboard(
+			tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton(t.I18nBot("tgbot.buttons.claimTrial")).WithCallbackData(t.encodeQuery("client_trial")),
+			),
+		)
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.trialOffer"), inlineKeyboard)
+	}
 }
 
 // sendResponse sends the response message based on the onlyMessage flag.
@@ -1583,6 +1624,9 @@ func (t *Tgbot) answerCallback(callbackQuery *telego.CallbackQuery, isAdmin bool
 		tgUserID := callbackQuery.From.ID
 		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.buttons.clientUsage"))
 		t.getClientUsage(chatId, tgUserID)
+	case "client_trial":
+		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.buttons.claimTrial"))
+		t.claimTrial(chatId, callbackQuery.From.ID)
 	case "client_commands":
 		t.sendCallbackAnswerTgBot(callbackQuery.ID, t.I18nBot("tgbot.buttons.commands"))
 		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.commands.helpClientCommands"))
@@ -2191,13 +2235,19 @@ func (t *Tgbot) SendAnswer(chatId int64, msg string, isAdmin bool) {
 
 // SendMsgToTgbot sends a message to the Telegram bot with optional reply markup.
 func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.ReplyMarkup) {
+	t.TrySendMsgToTgbot(chatId, msg, replyMarkup...)
+}
+
+// TrySendMsgToTgbot sends a message like SendMsgToTgbot and returns the first error, so that the
+// caller can tell whether it was delivered.
+func (t *Tgbot) TrySendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.ReplyMarkup) error {
 	if !isRunning {
-		return
+		return common.NewError("Telegram bot is not running")
 	}
 
 	if msg == "" {
 		logger.Info("[tgbot] message is empty!")
-		return
+		return common.NewError("message is empty")
 	}
 
 	var allMessages []string
@@ -2222,6 +2272,7 @@ func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.R
 	} else {
 		allMessages = append(allMessages, msg)
 	}
+	var sendErr error
 	for n, message := range allMessages {
 		params := telego.SendMessageParams{
 			ChatID:    tu.ID(chatId),
@@ -2235,12 +2286,16 @@ func (t *Tgbot) SendMsgToTgbot(chatId int64, msg string, replyMarkup ...telego.R
 		_, err := bot.SendMessage(context.Background(), &params)
 		if err != nil {
 			logger.Warning("Error sending telegram message :", err)
+			if sendErr == nil {
+				sendErr = err
+			}
 		}
 		// Reduced delay to improve performance (only needed for rate limiting)
 		if n < len(allMessages)-1 { // Only delay between messages, not after the last one
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
+	return sendErr
 }
 
 // buildSubscriptionURLs builds the HTML sub page URL and JSON subscription URL for a client email
@@ -2308,6 +2363,142 @@ func (t *Tgbot) buildSubscriptionURLs(email string) (string, string, error) {
 	return subURL, subJsonURL, nil
 }
 
+// GetSubscriptionURLs returns the subscription URL and the JSON subscription URL of a client,
+// the latter empty when JSON subscriptions are disabled.
+func (t *Tgbot) GetSubscriptionURLs(email string) (string, string, error) {
+	return t.buildSubscriptionURLs(email)
+}
+
+// claimTrial creates a trial account for a Telegram user and sends its subscription links.
+func (t *Tgbot) claimTrial(chatId int64, tgUserID int64) {
+	client, needRestart, err := t.trialService.CreateTrial(tgUserID, "")
+	switch {
+	case errors.Is(err, ErrTrialDisabled):
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.trialDisabled"))
+		return
+	case errors.Is(err, ErrTrialClaimed):
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.trialClaimed"))
+		return
+	case errors.Is(err, ErrTrialCapped):
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.trialCapped"))
+		return
+	case err != nil && client == nil:
+		logger.Warning("Unable to create a trial account:", err)
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.errorOperation"))
+		return
+	}
+	if needRestart {
+		t.xrayService.SetToNeedRestart()
+	}
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.trialCreated",
+		"Email=="+client.Email,
+		"Time=="+time.UnixMilli(client.ExpiryTime).Format("2006-01-02 15:04:05")))
+	t.sendClientSubLinks(chatId, client.Email)
+	t.TrialCreatedNotify(client.Email, "", tgUserID)
+}
+
+// redeemInvite creates an account for a Telegram user from an invite code and sends its subscription links.
+func (t *Tgbot) redeemInvite(chatId int64, tgUserID int64, code string) {
+	client, needRestart, err := t.inviteService.RedeemInvite(code, tgUserID, "")
+	switch {
+	case errors.Is(err, ErrInviteClosed):
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.inviteDisabled"))
+		return
+	case errors.Is(err, ErrInviteCapped):
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.inviteCapped"))
+		return
+	case errors.Is(err, ErrInviteInvalid):
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.inviteInvalid"))
+		return
+	case errors.Is(err, ErrInviteRedeemed):
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.inviteRedeemed"))
+		return
+	case err != nil && client == nil:
+		logger.Warning("Unable to redeem an invite code:", err)
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.errorOperation"))
+		return
+	}
+	if needRestart {
+		t.xrayService.SetToNeedRestart()
+	}
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.inviteCreated", "Email=="+client.Email))
+	t.sendClientSubLinks(chatId, client.Email)
+	t.ReferralNotify(code, client.Email)
+}
+
+// sendOwnInvite sends a Telegram user the invite code of its first client.
+func (t *Tgbot) sendOwnInvite(chatId int64, tgUserID int64) {
+	traffics, err := t.inboundService.GetClientTrafficTgBot(tgUserID)
+	if err != nil || len(traffics) == 0 {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.noResult"))
+		return
+	}
+	t.sendClientInvite(chatId, traffics[0].Email)
+}
+
+// sendClientInvite sends the invite code a client shares, with a link that opens the bot.
+func (t *Tgbot) sendClientInvite(chatId int64, email string) {
+	invite, err := t.inviteService.CreateClientInvite(email)
+	if errors.Is(err, ErrInviteDisabled) {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.inviteDisabled"))
+		return
+	}
+	if err != nil {
+		logger.Warning("Unable to create an invite code:", err)
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.answers.errorOperation")+"\r\n"+err.Error())
+		return
+	}
+	link := invite.Code
+	if me, err := bot.GetMe(context.Background()); err == nil {
+		link = "https://t.me/" + me.Username + "?start=" + invite.Code
+	}
+	uses := "∞"
+	if invite.MaxUses > 0 {
+		uses = strconv.Itoa(invite.MaxUses - invite.Uses)
+	}
+	t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.inviteCode",
+		"Email=="+email,
+		"Code=="+invite.Code,
+		"Link=="+link,
+		"Uses=="+uses))
+}
+
+// ReferralNotify tells the admins and the referrer of an invite code that a client signed up with it.
+func (t *Tgbot) ReferralNotify(code string, email string) {
+	if !t.IsRunning() {
+		return
+	}
+	invite := &model.InviteCode{}
+	db := database.GetDB()
+	if err := db.Model(model.InviteCode{}).Where("code = ?", code).First(invite).Error; err != nil {
+		return
+	}
+	t.SendMsgToTgbotAdmins(t.I18nBot("tgbot.messages.inviteNotify", "Email=="+email, "Code=="+invite.Code))
+	if invite.Referrer == "" {
+		return
+	}
+	_, referrer, err := t.inboundService.GetClientByEmail(invite.Referrer)
+	if err != nil || referrer == nil || referrer.TgID == 0 {
+		return
+	}
+	t.SendMsgToTgbot(referrer.TgID, t.I18nBot("tgbot.messages.referralJoined", "Email=="+invite.Referrer))
+}
+
+// TrialCreatedNotify tells the admins that a trial account was claimed on the web or through the bot.
+func (t *Tgbot) TrialCreatedNotify(email string, ip string, tgUserID int64) {
+	if !t.IsRunning() {
+		return
+	}
+	msg := t.I18nBot("tgbot.messages.trialNotify", "Email=="+email)
+	if tgUserID > 0 {
+		msg += t.I18nBot("tgbot.messages.TGUser", "TelegramID=="+strconv.FormatInt(tgUserID, 10))
+	}
+	if ip != "" {
+		msg += t.I18nBot("tgbot.messages.ip", "IP=="+ip)
+	}
+	t.SendMsgToTgbotAdmins(msg)
+}
+
 // sendClientSubLinks sends the subscription links for the client to the chat.
 func (t *Tgbot) sendClientSubLinks(chatId int64, email string) {
 	subURL, subJsonURL, err := t.buildSubscriptionURLs(email)
@@ -2988,6 +3179,28 @@ func (t *Tgbot) clientInfoMsg(
 		output += t.I18nBot("tgbot.messages.upload", "Upload=="+common.FormatTraffic(traffic.Up))
 		output += t.I18nBot("tgbot.messages.download", "Download=="+common.FormatTraffic(traffic.Down))
 		output += t.I18nBot("tgbot.messages.total", "UpDown=="+common.FormatTraffic((traffic.Up+traffic.Down)), "Total=="+total)
+		if usage, err := t.inboundService.GetClientsUsage([]string{traffic.Email}, 1); err == nil {
+			output += t.I18nBot("tgbot.messages.usedToday", "UpDown=="+common.FormatTraffic(usage))
+		}
+		if usage, err := t.inboundService.GetClientsUsage([]string{traffic.Email}, 30); err == nil {
+			output += t.I18nBot("tgbot.messages.usedMonth", "UpDown=="+common.FormatTraffic(usage))
+		}
+		if err := t.inboundService.FillForecasts([]*xray.ClientTraffic{traffic}); err == nil && traffic.DailyAverage > 0 {
+			output += t.I18nBot("tgbot.messages.dailyAverage", "UpDown=="+common.FormatTraffic(traffic.DailyAverage))
+			if traffic.DepletionTime > 0 {
+				output += t.I18nBot("tgbot.messages.depletion", "Time=="+time.UnixMilli(traffic.DepletionTime).Format("2006-01-02 15:04"))
+			}
+		}
+		if pool, err := t.inboundService.GetClientPoolUsage(traffic.Email); err == nil && pool != nil {
+			poolTotal := t.I18nBot("tgbot.unlimited")
+			if pool.Total > 0 {
+				poolTotal = common.FormatTraffic(pool.Total)
+			}
+			output += t.I18nBot("tgbot.messages.pool", "Name=="+pool.Name, "UpDown=="+common.FormatTraffic(pool.Used), "Total=="+poolTotal)
+			for _, member := range pool.Members {
+				output += t.I18nBot("tgbot.messages.poolMember", "Email=="+member.Email, "UpDown=="+common.FormatTraffic(member.Up+member.Down))
+			}
+		}
 	}
 	if printRefreshed {
 		output += t.I18nBot("tgbot.messages.refreshedOn", "Time=="+time.Now().Format("2006-01-02 15:04:05"))
@@ -3166,6 +3379,45 @@ func (t *Tgbot) searchClient(chatId int64, email string, messageID ...int) {
 	}
 }
 
+// searchClientDestinations sends the top destinations, ports and countries of a client from the connection log.
+func (t *Tgbot) searchClientDestinations(chatId int64, email string) {
+	stats, err := t.connectionLogService.GetClientDestinationStats(email, 0, 0, 10)
+	if err != nil {
+		logger.Warning(err)
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.wentWrong"))
+		return
+	}
+	if stats.Connections == 0 {
+		t.SendMsgToTgbot(chatId, t.I18nBot("tgbot.messages.noConnections", "Email=="+email))
+		return
+	}
+
+	share := func(count int64) string {
+		return strconv.FormatFloat(float64(count)*100/float64(stats.Connections), 'f', 1, 64)
+	}
+	list := func(counts []DestinationCount) string {
+		if len(counts) == 0 {
+			return "-\r\n"
+		}
+		var sb strings.Builder
+		for _, count := range counts {
+			sb.WriteString("<code>" + html.EscapeString(count.Name) + "</code>: " + strconv.FormatInt(count.Count, 10) + "\r\n")
+		}
+		return sb.String()
+	}
+
+	output := ""
+	output += t.I18nBot("tgbot.messages.email", "Email=="+email)
+	output += t.I18nBot("tgbot.messages.connections",
+		"Count=="+strconv.FormatInt(stats.Connections, 10),
+		"Udp=="+share(stats.Udp),
+		"Torrent=="+share(stats.Torrent))
+	output += t.I18nBot("tgbot.messages.topDomains", "List=="+list(stats.Domains))
+	output += t.I18nBot("tgbot.messages.topPorts", "List=="+list(stats.Ports))
+	output += t.I18nBot("tgbot.messages.topCountries", "List=="+list(stats.Countries))
+	t.SendMsgToTgbot(chatId, output)
+}
+
 // addClient handles the process of adding a new client to an inbound.
 func (t *Tgbot) addClient(chatId int64, msg string, messageID ...int) {
 	inbound, err := t.inboundService.GetInbound(receiver_inbound_ID)
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// trafficLimitsMaxAge bounds how long cached limits are used before being read again,
+// so that limits edited in the panel are picked up between flushes.
+const trafficLimitsMaxAge = time.Minute
+
+// trafficLimit holds the stored usage and the limits of a client or an inbound.
+type trafficLimit struct {
+	used       int64
+	total      int64
+	expiryTime int64   // milliseconds
+	multiplier float64 // traffic multiplier of a client, 0 when counted as is
+}
+
+// reached reports whether the limit is hit once the pending traffic is added.
+func (l trafficLimit) reached(pending int64, now int64) bool {
+	pending = applyTrafficMultiplier(pending, l.multiplier)
+	return (l.total > 0 && l.used+pending >= l.total) || (l.expiryTime > 0 && l.expiryTime <= now)
+}
+
+// trafficAccumulator merges the traffic deltas read from Xray until they are written to the database.
+type trafficAccumulator struct {
+	lock           sync.Mutex
+	traffics       map[string]*xray.Traffic
+	clientTraffics map[string]*xray.ClientTraffic
+	activeClients  map[string]bool // Clients with traffic since the online IPs were last collected
+	clientLimits   map[string]trafficLimit
+	inboundLimits  map[string]trafficLimit
+	limitsTime     time.Time
+	lastFlush      time.Time
+}
+
+var pendingTraffic = &trafficAccumulator{
+	traffics:       map[string]*xray.Traffic{},
+	clientTraffics: map[string]*xray.ClientTraffic{},
+	activeClients:  map[string]bool{},
+}
+
+// AccumulateTraffic merges traffic deltas into the in-memory buffer and updates the online clients.
+// It returns whether the buffer should be flushed now, either because the flush interval
+// has elapsed or because a client or an inbound reached its traffic or time limit.
+func (s *InboundService) AccumulateTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) bool {
+	nowMilli := time.Now().UnixMilli()
+	var onlineClients []string
+
+	pendingTraffic.lock.Lock()
+	for _, traffic := range traffics {
+		key := traffic.Tag
+		if traffic.IsOutbound {
+			key = ">" + key
+		}
+		pending, ok := pendingTraffic.traffics[key]
+		if !ok {
+			pending = &xray.Traffic{
+				IsInbound:  traffic.IsInbound,
+				IsOutbound: traffic.IsOutbound,
+				Tag:        traffic.Tag,
+			}
+			pendingTraffic.traffics[key] = pending
+		}
+		pending.Up += traffic.Up
+		pending.Down += traffic.Down
+	}
+	for _, traffic := range clientTraffics {
+		// Traffic on a rotated credential counts toward its client
+		email := strings.TrimSuffix(traffic.Email, rotatedEmailSuffix)
+		pending, ok := pendingTraffic.clientTraffics[email]
+		if !ok {
+			pending = &xray.ClientTraffic{Email: email}
+			pendingTraffic.clientTraffics[email] = pending
+		}
+		pending.Up += traffic.Up
+		pending.Down += traffic.Down
+		if traffic.Up+traffic.Down > 0 {
+			pending.LastOnline = nowMilli
+			pendingTraffic.activeClients[email] = true
+			if !slices.Contains(onlineClients, email) {
+				onlineClients = append(onlineClients, email)
+			}
+		}
+	}
+	lastFlush := pendingTraffic.lastFlush
+	pendingTraffic.lock.Unlock()
+
+	// Clients holding a connection without traffic are online too when IPs come from stats
+	for email := range s.GetOnlineClientIps() {
+		if !slices.Contains(onlineClients, email) {
+			onlineClients = append(onlineClients, email)
+		}
+	}
+	if p != nil {
+		p.SetOnlineClients(onlineClients)
+	}
+
+	interval, err := s.settingService.GetTrafficFlushInterval()
+	if err != nil {
+		interval = 0
+	}
+	if time.Since(lastFlush) >= time.Duration(interval)*time.Second {
+		return true
+	}
+	return s.isTrafficLimitReached()
+}
+
+// TakePendingTraffic returns the buffered traffic and empties the buffer.
+func (s *InboundService) TakePendingTraffic() ([]*xray.Traffic, []*xray.ClientTraffic) {
+	pendingTraffic.lock.Lock()
+	defer pendingTraffic.lock.Unlock()
+
+	traffics := make([]*xray.Traffic, 0, len(pendingTraffic.traffics))
+	for _, traffic := range pendingTraffic.traffics {
+		traffics = append(traffics, traffic)
+	}
+	clientTraffics := make([]*xray.ClientTraffic, 0, len(pendingTraffic.clientTraffics))
+	for _, traffic := range pendingTraffic.clientTraffics {
+		clientTraffics = append(clientTraffics, traffic)
+	}
+	pendingTraffic.traffics = map[string]*xray.Traffic{}
+	pendingTraffic.clientTraffics = map[string]*xray.ClientTraffic{}
+	pendingTraffic.lastFlush = time.Now()
+	// Stored usage changes with the flush, read the limits again on the next check
+	pendingTraffic.limitsTime = time.Time{}
+	return traffics, clientTraffics
+}
+
+// RestorePendingTraffic merges traffic that could not be written back into the buffer,
+// so that it is written with the next flush.
+func (s *InboundService) RestorePendingTraffic(traffics []*xray.Traffic, clientTraffics []*xray.ClientTraffic) {
+	pendingTraffic.lock.Lock()
+	defer pendingTraffic.lock.Unlock()
+	for _, traffic := range traffics {
+		key := traffic.Tag
+		if traffic.IsOutbound {
+			key = ">" + key
+		}
+		if pending, ok := pendingTraffic.traffics[key]; ok {
+			pending.Up += traffic.Up
+			pending.Down += traffic.Down
+		} else {
+			pendingTraffic.traffics[key] = traffic
+		}
+	}
+	for _, traffic := range clientTraffics {
+		if pending, ok := pendingTraffic.clientTraffics[traffic.Email]; ok {
+			pending.Up += traffic.Up
+			pending.Down += traffic.Down
+			pending.LastOnline = max(pending.LastOnline, traffic.LastOnline)
+		} else {
+			pendingTraffic.clientTraffics[traffic.Email] = traffic
+		}
+	}
+}
+
+// takeActiveClients returns the clients that had traffic since the last call and forgets them.
+func (s *InboundService) takeActiveClients() []string {
+	pendingTraffic.lock.Lock()
+	defer pendingTraffic.lock.Unlock()
+	emails := make([]string, 0, len(pendingTraffic.activeClients))
+	for email := range pendingTraffic.activeClients {
+		emails = append(emails, email)
+	}
+	pendingTraffic.activeClients = map[string]bool{}
+	return emails
+}
+
+// DiscardPendingClientTraffic drops the buffered traffic of clients whose usage was reset.
+func (s *InboundService) DiscardPendingClientTraffic(emails ...string) {
+	pendingTraffic.lock.Lock()
+	defer pendingTraffic.lock.Unlock()
+	for _, email := range emails {
+		delete(pendingTraffic.clientTraffics, email)
+	}
+}
+
+// DiscardPendingInboundTraffic drops the buffered traffic of the inbounds with the given tags,
+// or of all inbounds when no tag is given.
+func (s *InboundService) DiscardPendingInboundTraffic(tags ...string) {
+	pendingTraffic.lock.Lock()
+	defer pendingTraffic.lock.Unlock()
+	for key, traffic := range pendingTraffic.traffics {
+		if traffic.IsInbound && (len(tags) == 0 || slices.Contains(tags, traffic.Tag)) {
+			delete(pendingTraffic.traffics, key)
+		}
+	}
+}
+
+// isTrafficLimitReached checks the stored usage plus the buffered traffic against
+// the traffic and time limits of enabled clients and inbounds.
+func (s *InboundService) isTrafficLimitReached() bool {
+	pendingTraffic.lock.Lock()
+	defer pendingTraffic.lock.Unlock()
+
+	if time.Since(pendingTraffic.limitsTime) >= trafficLimitsMaxAge {
+		clientLimits, inboundLimits, err := s.loadTrafficLimits()
+		if err != nil {
+			logger.Warning("Unable to load traffic limits:", err)
+			return true
+		}
+		pendingTraffic.clientLimits = clientLimits
+		pendingTraffic.inboundLimits = inboundLimits
+		pendingTraffic.limitsTime = time.Now()
+	}
+
+	now := time.Now().UnixMilli()
+	for email, limit := range pendingTraffic.clientLimits {
+		var pending int64
+		if traffic, ok := pendingTraffic.clientTraffics[email]; ok {
+			pending = traffic.Up + traffic.Down
+		}
+		if limit.reached(pending, now) {
+			return true
+		}
+	}
+	for tag, limit := range pendingTraffic.inboundLimits {
+		var pending int64
+		if traffic, ok := pendingTraffic.traffics[tag]; ok {
+			pending = traffic.Up + traffic.Down
+		}
+		if limit.reached(pending, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTrafficLimits reads the usage and limits of enabled clients and inbounds that have any limit.
+func (s *InboundService) loadTrafficLimits() (map[string]trafficLimit, map[string]trafficLimit, error) {
+	db := database.GetDB()
+
+	var clients []*xray.ClientTraffic
+	err := db.Model(xray.ClientTraffic{}).
+		Select("inbound_id, email, up, down, total, expiry_time").
+		Where("enable = ? AND (total > 0 OR expiry_time > 0) AND stage <> ?", true, ClientStageGrace).
+		Find(&clients).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	inboundIds := make([]int, 0, len(clients))
+	for _, client := range clients {
+		inboundIds = append(inboundIds, client.InboundId)
+	}
+	multipliers, err := s.getTrafficMultipliers(db, inboundIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientLimits := make(map[string]trafficLimit, len(clients))
+	for _, client := range clients {
+		clientLimits[client.Email] = trafficLimit{
+			used:       client.Up + client.Down,
+			total:      client.Total,
+			expiryTime: client.ExpiryTime,
+			multiplier: multipliers[client.Email],
+		}
+	}
+
+	var inbounds []*model.Inbound
+	err = db.Model(model.Inbound{}).
+		Select("tag, up, down, total, expiry_time").
+		Where("enable = ? AND (total > 0 OR expiry_time > 0)", true).
+		Find(&inbounds).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	inboundLimits := make(map[string]trafficLimit, len(inbounds))
+	for _, inbound := range inbounds {
+		inboundLimits[inbound.Tag] = trafficLimit{
+			used:       inbound.Up + inbound.Down,
+			total:      inbound.Total,
+			expiryTime: inbound.ExpiryTime,
+		}
+	}
+	return clientLimits, inboundLimits, nil
+}
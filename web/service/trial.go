@@ -0,0 +1,229 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/util/random"
+)
+
+// trialEmailPrefix starts the email of every trial client.
+const trialEmailPrefix = "trial-"
+
+var (
+	// trialLock serializes claims so that the one-time and daily limits hold under concurrent requests.
+	trialLock sync.Mutex
+
+	ErrTrialDisabled = common.NewError("trial accounts are disabled")
+	ErrTrialClaimed  = common.NewError("a trial account was already claimed")
+	ErrTrialCapped   = common.NewError("the daily trial limit is reached")
+)
+
+// TrialService hands out trial accounts. Each Telegram user and each IP can claim one, at most the
+// daily cap are handed out per day, and the clients are deleted once they expire.
+type TrialService struct {
+	inboundService InboundService
+	settingService SettingService
+}
+
+// IsTrialEnabled reports whether trial accounts can be claimed.
+func (s *TrialService) IsTrialEnabled() bool {
+	enable, err := s.settingService.GetTrialEnable()
+	if err != nil || !enable {
+		return false
+	}
+	inboundId, err := s.settingService.GetTrialInboundId()
+	return err == nil && inboundId > 0
+}
+
+// signupIpKey returns the key an IP is limited by. IPv6 visitors usually hold a whole /64, so their
+// addresses collapse to it.
+func signupIpKey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		return ipv4.String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// checkClaim returns an error when the Telegram user or the IP already claimed a trial account,
+// or when the daily cap is reached.
+func (s *TrialService) checkClaim(tgId int64, ip string) error {
+	db := database.GetDB()
+	var count int64
+	if tgId > 0 {
+		if err := db.Model(model.TrialClaim{}).Where("tg_id = ?", tgId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTrialClaimed
+		}
+	}
+	if ip != "" {
+		if err := db.Model(model.TrialClaim{}).Where("ip = ?", ip).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTrialClaimed
+		}
+	}
+	dailyCap, err := s.settingService.GetTrialDailyCap()
+	if err != nil || dailyCap <= 0 {
+		return err
+	}
+	if err := db.Model(model.TrialClaim{}).Where("created_at >= ?", s.inboundService.getDayStart()).Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= int64(dailyCap) {
+		return ErrTrialCapped
+	}
+	return nil
+}
+
+// CreateTrial creates a trial client on the trial inbound for a Telegram user or a web visitor.
+// Returns the client and whether Xray needs a restart.
+func (s *TrialService) CreateTrial(tgId int64, ip string) (*model.Client, bool, error) {
+	if !s.IsTrialEnabled() {
+		return nil, false, ErrTrialDisabled
+	}
+	trialLock.Lock()
+	defer trialLock.Unlock()
+
+	ip = signupIpKey(ip)
+	if err := s.checkClaim(tgId, ip); err != nil {
+		return nil, false, err
+	}
+	inboundId, err := s.settingService.GetTrialInboundId()
+	if err != nil {
+		return nil, false, err
+	}
+	planId, err := s.settingService.GetTrialPlanId()
+	if err != nil {
+		return nil, false, err
+	}
+	hours, err := s.settingService.GetTrialHours()
+	if err != nil {
+		return nil, false, err
+	}
+	trafficGB, err := s.settingService.GetTrialTrafficGB()
+	if err != nil {
+		return nil, false, err
+	}
+	client, needRestart, err := s.inboundService.addSignupClient(inboundId, model.Client{
+		Email:      trialEmailPrefix + strings.ToLower(random.Seq(8)),
+		TotalGB:    int64(trafficGB) * 1024 * 1024 * 1024,
+		ExpiryTime: time.Now().Add(time.Duration(hours) * time.Hour).UnixMilli(),
+		TgID:       tgId,
+		Comment:    "trial",
+		PlanId:     planId,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	db := database.GetDB()
+	claim := &model.TrialClaim{
+		TgId:      tgId,
+		Ip:        ip,
+		InboundId: inboundId,
+		Email:     client.Email,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := db.Create(claim).Error; err != nil {
+		return client, needRestart, err
+	}
+	logger.Infof("Trial account %s created for tg %d ip %s", client.Email, tgId, ip)
+	return client, needRestart, nil
+}
+
+// getDayStart returns the Unix time the current day started at in the panel's time zone.
+func (s *InboundService) getDayStart() int64 {
+	loc := s.getTimeLocation()
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).Unix()
+}
+
+// addSignupClient creates a client that signed up by itself on an inbound, filling in its credential
+// and subscription. Returns the client and whether Xray needs a restart.
+func (s *InboundService) addSignupClient(inboundId int, client model.Client) (*model.Client, bool, error) {
+	inbound, err := s.GetInbound(inboundId)
+	if err != nil {
+		return nil, false, err
+	}
+	field := credentialField(inbound.Protocol)
+	if field == "" {
+		return nil, false, common.NewError("inbound does not support clients:", inbound.Protocol)
+	}
+	var settings map[string]any
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return nil, false, err
+	}
+	method, _ := settings["method"].(string)
+
+	now := time.Now().UnixMilli()
+	client.Enable = true
+	client.SubID = random.Seq(16)
+	client.CreatedAt = now
+	client.UpdatedAt = now
+	if field == "id" {
+		client.ID = newCredential(inbound.Protocol, method)
+		if inbound.Protocol == model.VMESS {
+			client.Security = "auto"
+		}
+	} else {
+		client.Password = newCredential(inbound.Protocol, method)
+	}
+	data, err := json.Marshal(map[string][]model.Client{"clients": {client}})
+	if err != nil {
+		return nil, false, err
+	}
+	needRestart, err := s.AddInboundClient(&model.Inbound{Id: inboundId, Settings: string(data)})
+	if err != nil {
+		return nil, false, err
+	}
+	return &client, needRestart, nil
+}
+
+// DelExpiredTrials deletes the trial clients that expired. Clients extended by an admin are kept, and
+// the claims stay so that the same user cannot claim another trial. Returns whether Xray needs a restart.
+func (s *TrialService) DelExpiredTrials() (bool, error) {
+	db := database.GetDB()
+	var claims []*model.TrialClaim
+	if err := db.Model(model.TrialClaim{}).Where("deleted = ?", false).Find(&claims).Error; err != nil {
+		return false, err
+	}
+	now := time.Now().UnixMilli()
+	needRestart := false
+	for _, claim := range claims {
+		traffic, _, err := s.inboundService.GetClientInboundByEmail(claim.Email)
+		if err != nil {
+			return needRestart, err
+		}
+		if traffic != nil {
+			if traffic.ExpiryTime <= 0 || traffic.ExpiryTime > now {
+				continue
+			}
+			restart, err := s.inboundService.DelInboundClientByEmail(traffic.InboundId, claim.Email)
+			if err != nil {
+				logger.Warning("Unable to delete trial client", claim.Email, ":", err)
+				continue
+			}
+			needRestart = needRestart || restart
+		}
+		// The client expired or was deleted already
+		if err := db.Model(claim).Update("deleted", true).Error; err != nil {
+			return needRestart, err
+		}
+	}
+	return needRestart, nil
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/util/common"
+	"github.com/agassiz/3x-ui/v2/xray"
+
+	"gorm.io/gorm"
+)
+
+// Reasons recorded with usage snapshots.
+const (
+	resetReasonManual   = "manual"   // Reset from the panel, the API or the bot
+	resetReasonPeriodic = "periodic" // Reset by the traffic reset period of the inbound
+	resetReasonRenew    = "renew"    // Reset by the automatic renewal of an expired client
+	resetReasonSchedule = "schedule" // Reset by the reset schedule of the client
+	resetReasonPayment  = "payment"  // Reset by the plan renewal of a paid invoice
+)
+
+// ClientMonthlyUsage is the traffic of a client over one calendar month.
+type ClientMonthlyUsage struct {
+	Email     string                       `json:"email"`
+	InboundId int                          `json:"inboundId"`
+	Up        int64                        `json:"up"`                 // Raw upload from the daily history
+	Down      int64                        `json:"down"`               // Raw download from the daily history
+	Snapshots []*model.ClientUsageSnapshot `json:"snapshots" gorm:"-"` // Periods closed by a reset during the month
+}
+
+// MonthlyUsage is the usage of every client and inbound over one calendar month, in the panel time zone.
+type MonthlyUsage struct {
+	Month    string                        `json:"month"` // YYYY-MM
+	From     int64                         `json:"from"`  // Start of the month in seconds
+	To       int64                         `json:"to"`    // Start of the next month in seconds
+	Clients  []*ClientMonthlyUsage         `json:"clients"`
+	Inbounds []*model.InboundUsageSnapshot `json:"inbounds"` // Inbound periods closed by a reset during the month
+}
+
+// archiveClientUsage records a usage snapshot for every client matching the condition that used
+// traffic since its last reset, and starts a new period for all of them.
+func (s *InboundService) archiveClientUsage(tx *gorm.DB, reason string, query any, args ...any) error {
+	var traffics []*xray.ClientTraffic
+	err := tx.Model(xray.ClientTraffic{}).Where(query, args...).
+		Where("up + down > 0 OR raw_up + raw_down > 0").Find(&traffics).Error
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	if len(traffics) > 0 {
+		snapshots := make([]*model.ClientUsageSnapshot, 0, len(traffics))
+		for _, traffic := range traffics {
+			snapshots = append(snapshots, &model.ClientUsageSnapshot{
+				InboundId:   traffic.InboundId,
+				Email:       traffic.Email,
+				PeriodStart: traffic.LastReset,
+				PeriodEnd:   now,
+				Up:          traffic.Up,
+				Down:        traffic.Down,
+				RawUp:       traffic.RawUp,
+				RawDown:     traffic.RawDown,
+				Total:       traffic.Total,
+				Reason:      reason,
+			})
+		}
+		if err = tx.CreateInBatches(snapshots, 100).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(xray.ClientTraffic{}).Where(query, args...).Update("last_reset", now).Error
+}
+
+// archiveInboundUsage records a usage snapshot for every inbound matching the condition that
+// used traffic since its last reset.
+func (s *InboundService) archiveInboundUsage(tx *gorm.DB, reason string, query any, args ...any) error {
+	var inbounds []*model.Inbound
+	err := tx.Model(model.Inbound{}).Where(query, args...).Where("up + down > 0").Find(&inbounds).Error
+	if err != nil || len(inbounds) == 0 {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	snapshots := make([]*model.InboundUsageSnapshot, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		snapshots = append(snapshots, &model.InboundUsageSnapshot{
+			InboundId:   inbound.Id,
+			Tag:         inbound.Tag,
+			PeriodStart: inbound.LastTrafficResetTime,
+			PeriodEnd:   now,
+			Up:          inbound.Up,
+			Down:        inbound.Down,
+			Total:       inbound.Total,
+			Reason:      reason,
+		})
+	}
+	return tx.CreateInBatches(snapshots, 100).Error
+}
+
+// ResetInboundTraffic resets the traffic of one inbound and of its clients when its traffic reset
+// period comes, archiving the usage of the closed period. Other inbounds are left alone.
+func (s *InboundService) ResetInboundTraffic(id int) error {
+	db := database.GetDB()
+	inbound := &model.Inbound{}
+	if err := db.Model(model.Inbound{}).First(inbound, id).Error; err != nil {
+		return err
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := s.archiveInboundUsage(tx, resetReasonPeriodic, "id = ?", id); err != nil {
+			return err
+		}
+		return tx.Model(model.Inbound{}).Where("id = ?", id).Updates(map[string]any{"up": 0, "down": 0}).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.DiscardPendingInboundTraffic(inbound.Tag)
+	return s.resetAllClientTraffics(id, resetReasonPeriodic)
+}
+
+// GetUsageSnapshots returns the archived usage of clients whose period ended between from and to
+// (Unix seconds, 0 for no bound), optionally narrowed to one client or one inbound, latest first.
+func (s *InboundService) GetUsageSnapshots(email string, inboundId int, from int64, to int64) ([]*model.ClientUsageSnapshot, error) {
+	db := database.GetDB()
+	query := db.Model(model.ClientUsageSnapshot{})
+	if email != "" {
+		query = query.Where("email = ?", email)
+	}
+	if inboundId > 0 {
+		query = query.Where("inbound_id = ?", inboundId)
+	}
+	if from > 0 {
+		query = query.Where("period_end >= ?", from*1000)
+	}
+	if to > 0 {
+		query = query.Where("period_end < ?", to*1000)
+	}
+	snapshots := make([]*model.ClientUsageSnapshot, 0)
+	err := query.Order("period_end desc").Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// GetMonthlyUsage returns the usage of a calendar month given as YYYY-MM, or of the previous month
+// when month is empty. Traffic comes from the daily history, so it is not affected by resets.
+func (s *InboundService) GetMonthlyUsage(month string) (*MonthlyUsage, error) {
+	loc := s.getTimeLocation()
+	var start time.Time
+	if month == "" {
+		now := time.Now().In(loc)
+		start = time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, loc)
+	} else {
+		parsed, err := time.ParseInLocation("2006-01", month, loc)
+		if err != nil {
+			return nil, common.NewError("invalid month:", month)
+		}
+		start = parsed
+	}
+	end := start.AddDate(0, 1, 0)
+	usage := &MonthlyUsage{
+		Month:    start.Format("2006-01"),
+		From:     start.Unix(),
+		To:       end.Unix(),
+		Clients:  make([]*ClientMonthlyUsage, 0),
+		Inbounds: make([]*model.InboundUsageSnapshot, 0),
+	}
+
+	db := database.GetDB()
+	var totals []*ClientMonthlyUsage
+	err := db.Model(model.ClientTrafficHistory{}).
+		Select("email, MAX(inbound_id) AS inbound_id, SUM(up) AS up, SUM(down) AS down").
+		Where("period = ? AND time >= ? AND time < ?", "day", usage.From, usage.To).
+		Group("email").Order("email asc").Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := s.GetUsageSnapshots("", 0, usage.From, usage.To)
+	if err != nil {
+		return nil, err
+	}
+	clients := make(map[string]*ClientMonthlyUsage, len(totals))
+	for _, total := range totals {
+		total.Snapshots = make([]*model.ClientUsageSnapshot, 0)
+		clients[total.Email] = total
+		usage.Clients = append(usage.Clients, total)
+	}
+	for _, snapshot := range snapshots {
+		client, ok := clients[snapshot.Email]
+		if !ok {
+			client = &ClientMonthlyUsage{Email: snapshot.Email, InboundId: snapshot.InboundId, Snapshots: make([]*model.ClientUsageSnapshot, 0)}
+			clients[snapshot.Email] = client
+			usage.Clients = append(usage.Clients, client)
+		}
+		client.Snapshots = append(client.Snapshots, snapshot)
+	}
+
+	err = db.Model(model.InboundUsageSnapshot{}).
+		Where("period_end >= ? AND period_end < ?", usage.From*1000, usage.To*1000).
+		Order("period_end desc").Find(&usage.Inbounds).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
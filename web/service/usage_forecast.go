@@ -0,0 +1,172 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/agassiz/3x-ui/v2/database"
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+const (
+	// forecastDays is the window of the moving average usage forecasts are based on.
+	forecastDays = 7
+	// dailyAveragesMaxAge bounds how long the daily averages are reused. The history they come from
+	// only grows with the traffic flushes, so inbound lists between two flushes share one query.
+	dailyAveragesMaxAge = time.Minute
+)
+
+var (
+	dailyAveragesLock sync.Mutex
+	dailyAverages     map[string]float64
+	dailyAveragesTime time.Time
+)
+
+// UsageForecast estimates when a quota runs out from its recent consumption.
+type UsageForecast struct {
+	DailyAverage  int64 `json:"dailyAverage"`  // Traffic per day over the last forecastDays days, as counted toward the quota
+	DepletionTime int64 `json:"depletionTime"` // Estimated time the quota runs out in milliseconds, 0 when unlimited or unused
+}
+
+// getDailyAverages returns the raw traffic per day of the given clients over the last forecastDays days.
+// Clients with less history are averaged over the days they have, at least one.
+func (s *InboundService) getDailyAverages(emails []string) (map[string]float64, error) {
+	averages := make(map[string]float64, len(emails))
+	if len(emails) == 0 {
+		return averages, nil
+	}
+	dailyAveragesLock.Lock()
+	defer dailyAveragesLock.Unlock()
+	if time.Since(dailyAveragesTime) >= dailyAveragesMaxAge {
+		all, err := loadDailyAverages()
+		if err != nil {
+			return nil, err
+		}
+		dailyAverages = all
+		dailyAveragesTime = time.Now()
+	}
+	for _, email := range emails {
+		if average, ok := dailyAverages[email]; ok {
+			averages[email] = average
+		}
+	}
+	return averages, nil
+}
+
+// loadDailyAverages reads the raw traffic per day of every client with recent history.
+func loadDailyAverages() (map[string]float64, error) {
+	now := time.Now().Unix()
+	var rows []struct {
+		Email   string
+		Traffic int64
+		First   int64
+	}
+	db := database.GetDB()
+	err := db.Model(model.ClientTrafficHistory{}).
+		Select("email, SUM(up + down) AS traffic, MIN(time) AS first").
+		Where("period = ? AND time >= ?", "hour", now-forecastDays*86400).
+		Group("email").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	averages := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		days := math.Min(math.Max(float64(now-row.First)/86400, 1), forecastDays)
+		averages[row.Email] = float64(row.Traffic) / days
+	}
+	return averages, nil
+}
+
+// depletionTime returns when a quota runs out at the given daily usage, in milliseconds.
+func depletionTime(used int64, total int64, dailyAverage int64) int64 {
+	if total <= 0 || dailyAverage <= 0 {
+		return 0
+	}
+	now := time.Now().UnixMilli()
+	left := total - used
+	if left <= 0 {
+		return now
+	}
+	return now + int64(float64(left)/float64(dailyAverage)*86400000)
+}
+
+// FillForecasts sets the usage forecast of the given client traffics. The history holds raw traffic,
+// so averages are scaled by the traffic multiplier the client saw in its current cycle.
+func (s *InboundService) FillForecasts(traffics []*xray.ClientTraffic) error {
+	emails := make([]string, 0, len(traffics))
+	for _, traffic := range traffics {
+		emails = append(emails, traffic.Email)
+	}
+	averages, err := s.getDailyAverages(emails)
+	if err != nil {
+		return err
+	}
+	for _, traffic := range traffics {
+		average := averages[traffic.Email]
+		if raw := traffic.RawUp + traffic.RawDown; raw > 0 {
+			average *= float64(traffic.Up+traffic.Down) / float64(raw)
+		}
+		traffic.DailyAverage = int64(average)
+		traffic.DepletionTime = depletionTime(traffic.Up+traffic.Down, traffic.Total, traffic.DailyAverage)
+	}
+	return nil
+}
+
+// GetUsageForecast returns the forecast of a quota shared by the given clients, such as a subscription's.
+func (s *InboundService) GetUsageForecast(emails []string, used int64, total int64) (*UsageForecast, error) {
+	var traffics []*xray.ClientTraffic
+	db := database.GetDB()
+	if err := db.Model(xray.ClientTraffic{}).Where("email IN ?", emails).Find(&traffics).Error; err != nil {
+		return nil, err
+	}
+	if err := s.FillForecasts(traffics); err != nil {
+		return nil, err
+	}
+	forecast := &UsageForecast{}
+	for _, traffic := range traffics {
+		forecast.DailyAverage += traffic.DailyAverage
+	}
+	forecast.DepletionTime = depletionTime(used, total, forecast.DailyAverage)
+	return forecast, nil
+}
+
+// GetForecastReminders returns the enabled clients whose quota is forecast to run out within the
+// reminder days and who were not reminded in the last day.
+func (s *InboundService) GetForecastReminders() ([]*xray.ClientTraffic, error) {
+	days, err := s.settingService.GetForecastRemindDays()
+	if err != nil || days <= 0 {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	db := database.GetDB()
+	var traffics []*xray.ClientTraffic
+	err = db.Model(xray.ClientTraffic{}).
+		Where("enable = ? AND total > 0 AND up + down < total AND forecast_notified < ?", true, now-86400000).
+		Find(&traffics).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := s.FillForecasts(traffics); err != nil {
+		return nil, err
+	}
+	var reminders []*xray.ClientTraffic
+	for _, traffic := range traffics {
+		if traffic.DepletionTime == 0 || traffic.DepletionTime > now+int64(days)*86400000 {
+			continue
+		}
+		if traffic.ExpiryTime > 0 && traffic.ExpiryTime < traffic.DepletionTime {
+			// The client expires first, the expiry warning covers it
+			continue
+		}
+		reminders = append(reminders, traffic)
+	}
+	return reminders, nil
+}
+
+// MarkForecastReminded records that a client was sent its depletion reminder.
+func (s *InboundService) MarkForecastReminded(email string) error {
+	db := database.GetDB()
+	return db.Model(xray.ClientTraffic{}).Where("email = ?", email).Update("forecast_notified", time.Now().UnixMilli()).Error
+}
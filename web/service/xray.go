@@ -5,6 +5,7 @@ import (
 	"errors"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/agassiz/3x-ui/v2/logger"
 	"github.com/agassiz/3x-ui/v2/xray"
@@ -23,9 +24,13 @@ var (
 // XrayService provides business logic for Xray process management.
 // It handles starting, stopping, restarting Xray, and managing its configuration.
 type XrayService struct {
-	inboundService InboundService
-	settingService SettingService
-	xrayAPI        xray.XrayAPI
+	inboundService  InboundService
+	outboundService OutboundService
+	settingService  SettingService
+	ipBanService    IpBanService
+	presetService   RoutingPresetService
+	planService     PlanService
+	xrayAPI         xray.XrayAPI
 }
 
 // IsXrayRunning checks if the Xray process is currently running.
@@ -100,16 +105,32 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		return nil, err
 	}
 
+	if err := s.outboundService.mergeOutbounds(xrayConfig); err != nil {
+		logger.Warning("Unable to add managed outbounds:", err)
+	}
+
 	s.inboundService.AddTraffic(nil, nil)
 
 	inbounds, err := s.inboundService.GetAllInbounds()
 	if err != nil {
 		return nil, err
 	}
+	userRoutes := map[string]egressRoute{}
+	var inboundRoutes []inboundEgress
+	graceRoute := s.inboundService.getGraceRoute()
+	plans, err := s.planService.getPlanMap()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().In(s.inboundService.getTimeLocation())
+	var accessBlocked []string
 	for _, inbound := range inbounds {
 		if !inbound.Enable {
 			continue
 		}
+		if route := inboundEgressRoute(inbound); !route.isEmpty() {
+			inboundRoutes = append(inboundRoutes, inboundEgress{Tag: inbound.Tag, Route: route})
+		}
 		// get settings clients
 		settings := map[string]any{}
 		json.Unmarshal([]byte(inbound.Settings), &settings)
@@ -117,7 +138,9 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		if ok {
 			// check users active or not
 			clientStats := inbound.ClientStats
+			inGrace := map[string]bool{}
 			for _, clientTraffic := range clientStats {
+				inGrace[clientTraffic.Email] = clientTraffic.Enable && clientTraffic.Stage == ClientStageGrace
 				indexDecrease := 0
 				for index, client := range clients {
 					c := client.(map[string]any)
@@ -140,6 +163,33 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 						continue
 					}
 				}
+				// A rotated credential stays valid under a second user until its overlap ends
+				var alias map[string]any
+				oldCredential, _ := c["oldCredential"].(string)
+				until, _ := c["oldCredentialUntil"].(float64)
+				if email, ok := c["email"].(string); ok && email != "" {
+					if route := clientEgressFromMap(c); !route.isEmpty() {
+						userRoutes[email] = route
+					}
+					// Clients in their grace period only get the limited route
+					if inGrace[email] && !graceRoute.isEmpty() {
+						userRoutes[email] = graceRoute
+					}
+					if oldCredential != "" && int64(until) > now.UnixMilli() {
+						alias = map[string]any{"email": email + rotatedEmailSuffix}
+						if route, ok := userRoutes[email]; ok {
+							userRoutes[email+rotatedEmailSuffix] = route
+						}
+					}
+					// Clients outside their access schedule are added back by the access schedule job,
+					// their routes are kept so that they apply once it does
+					schedule, _ := c["accessSchedule"].(string)
+					planId, _ := c["planId"].(float64)
+					if !isAccessAllowed(clientAccessSchedule(schedule, int(planId), plans), now) {
+						accessBlocked = append(accessBlocked, email)
+						continue
+					}
+				}
 				for key := range c {
 					if key != "email" && key != "id" && key != "password" && key != "flow" && key != "method" {
 						delete(c, key)
@@ -149,6 +199,15 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 					}
 				}
 				final_clients = append(final_clients, any(c))
+				if alias != nil {
+					for key, value := range c {
+						if key != "email" {
+							alias[key] = value
+						}
+					}
+					alias[credentialField(inbound.Protocol)] = oldCredential
+					final_clients = append(final_clients, any(alias))
+				}
 			}
 
 			settings["clients"] = final_clients
@@ -188,6 +247,34 @@ func (s *XrayService) GetXrayConfig() (*xray.Config, error) {
 		inboundConfig := inbound.GenXrayInboundConfig()
 		xrayConfig.InboundConfigs = append(xrayConfig.InboundConfigs, *inboundConfig)
 	}
+
+	markAccessBlocked(accessBlocked)
+
+	if err := s.presetService.applyRoutingPresets(xrayConfig); err != nil {
+		return nil, err
+	}
+	if err := s.applyEgressRules(xrayConfig, userRoutes, inboundRoutes); err != nil {
+		return nil, err
+	}
+	if err := s.outboundService.applyQuotaReroutes(xrayConfig); err != nil {
+		logger.Warning("Unable to apply outbound quota reroutes:", err)
+	}
+	if s.inboundService.IsStatsIpSource() {
+		if err := enableUserOnlineStats(xrayConfig); err != nil {
+			return nil, err
+		}
+	}
+	var extraServices []string
+	if s.ipBanService.GetBackendName() == "xray" {
+		if err := s.ipBanService.applyIpBanRules(xrayConfig); err != nil {
+			return nil, err
+		}
+		// Bans are pushed to the running core through the routing API
+		extraServices = append(extraServices, "RoutingService")
+	}
+	if err := ensureApiServices(xrayConfig, extraServices...); err != nil {
+		return nil, err
+	}
 	return xrayConfig, nil
 }
 
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/agassiz/3x-ui/v2/database/model"
+	"github.com/agassiz/3x-ui/v2/logger"
+	"github.com/agassiz/3x-ui/v2/xray"
+)
+
+// egressRoute describes the outbound or balancer a group of users leaves Xray through.
+type egressRoute struct {
+	OutboundTag string
+	BalancerTag string
+}
+
+// isEmpty reports whether the route points to neither an outbound nor a balancer.
+func (r egressRoute) isEmpty() bool {
+	return r.OutboundTag == "" && r.BalancerTag == ""
+}
+
+// key returns a stable identifier used to group users sharing the same route.
+func (r egressRoute) key() string {
+	if r.BalancerTag != "" {
+		return "balancer:" + r.BalancerTag
+	}
+	return "outbound:" + r.OutboundTag
+}
+
+// inboundEgress binds an inbound tag to its default egress route.
+type inboundEgress struct {
+	Tag   string
+	Route egressRoute
+}
+
+// clientEgressFromMap extracts the egress route stored on a raw client settings entry.
+// A balancer takes precedence over an outbound when both are set.
+func clientEgressFromMap(c map[string]any) egressRoute {
+	route := egressRoute{}
+	if balancer, ok := c["egressBalancer"].(string); ok && balancer != "" {
+		route.BalancerTag = balancer
+		return route
+	}
+	if outbound, ok := c["egressOutbound"].(string); ok {
+		route.OutboundTag = outbound
+	}
+	return route
+}
+
+// inboundEgressRoute returns the default egress route configured on an inbound.
+func inboundEgressRoute(inbound *model.Inbound) egressRoute {
+	if inbound.EgressBalancer != "" {
+		return egressRoute{BalancerTag: inbound.EgressBalancer}
+	}
+	return egressRoute{OutboundTag: inbound.EgressOutbound}
+}
+
+// clientHasEgress reports whether a client overrides its egress route.
+func clientHasEgress(client model.Client) bool {
+	return client.EgressOutbound != "" || client.EgressBalancer != ""
+}
+
+// egressSignature summarizes the egress routing of an inbound and its clients.
+// Two inbounds with the same signature produce the same routing rules.
+func egressSignature(inbound *model.Inbound) string {
+	parts := []string{}
+	if route := inboundEgressRoute(inbound); !route.isEmpty() {
+		parts = append(parts, inbound.Tag+"="+route.key())
+	}
+	settings := map[string]any{}
+	json.Unmarshal([]byte(inbound.Settings), &settings)
+	if clients, ok := settings["clients"].([]any); ok {
+		for _, client := range clients {
+			c, ok := client.(map[string]any)
+			if !ok {
+				continue
+			}
+			if route := clientEgressFromMap(c); !route.isEmpty() {
+				email, _ := c["email"].(string)
+				parts = append(parts, email+"="+route.key())
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%v:%s", inbound.Enable, strings.Join(parts, ","))
+}
+
+// getRoutingTags returns the outbound and balancer tags defined in the given config.
+func getRoutingTags(xrayConfig *xray.Config) (map[string]bool, map[string]bool) {
+	outboundTags := map[string]bool{}
+	balancerTags := map[string]bool{}
+
+	var outbounds []map[string]any
+	if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err == nil {
+		for _, outbound := range outbounds {
+			if tag, ok := outbound["tag"].(string); ok && tag != "" {
+				outboundTags[tag] = true
+			}
+		}
+	}
+
+	routing := map[string]any{}
+	if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err == nil {
+		if balancers, ok := routing["balancers"].([]any); ok {
+			for _, balancer := range balancers {
+				if b, ok := balancer.(map[string]any); ok {
+					if tag, ok := b["tag"].(string); ok && tag != "" {
+						balancerTags[tag] = true
+					}
+				}
+			}
+		}
+	}
+	return outboundTags, balancerTags
+}
+
+// appendRoutingRules appends rules after the existing routing rules of the config.
+func appendRoutingRules(xrayConfig *xray.Config, rules []any) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	existing, _ := routing["rules"].([]any)
+	routing["rules"] = append(existing, rules...)
+
+	data, err := json.MarshalIndent(routing, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = data
+	return nil
+}
+
+// prependRoutingRules puts rules ahead of the existing routing rules of the config.
+func prependRoutingRules(xrayConfig *xray.Config, rules []any) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	existing, _ := routing["rules"].([]any)
+	routing["rules"] = append(rules, existing...)
+
+	data, err := json.MarshalIndent(routing, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = data
+	return nil
+}
+
+// prependRoutingRulesAfterApi puts rules ahead of the existing routing rules of the config,
+// but after the leading rules of the panel's API, so that blocking rules cannot cut it off.
+func prependRoutingRulesAfterApi(xrayConfig *xray.Config, rules []any) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	routing := map[string]any{}
+	if len(xrayConfig.RouterConfig) > 0 {
+		if err := json.Unmarshal(xrayConfig.RouterConfig, &routing); err != nil {
+			return err
+		}
+	}
+	existing, _ := routing["rules"].([]any)
+	i := 0
+	for ; i < len(existing); i++ {
+		rule, _ := existing[i].(map[string]any)
+		if tag, _ := rule["outboundTag"].(string); tag != "api" {
+			break
+		}
+	}
+	routing["rules"] = slices.Concat(existing[:i], rules, existing[i:])
+
+	data, err := json.MarshalIndent(routing, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.RouterConfig = data
+	return nil
+}
+
+// ensureBlackholeOutbound adds a blackhole outbound with the given tag unless the config has it.
+func ensureBlackholeOutbound(xrayConfig *xray.Config, tag string) error {
+	if outboundTags, _ := getRoutingTags(xrayConfig); outboundTags[tag] {
+		return nil
+	}
+	var outbounds []any
+	if len(xrayConfig.OutboundConfigs) > 0 {
+		if err := json.Unmarshal(xrayConfig.OutboundConfigs, &outbounds); err != nil {
+			return err
+		}
+	}
+	outbounds = append(outbounds, map[string]any{
+		"tag":      tag,
+		"protocol": "blackhole",
+	})
+	data, err := json.MarshalIndent(outbounds, "", "  ")
+	if err != nil {
+		return err
+	}
+	xrayConfig.OutboundConfigs = data
+	return nil
+}
+
+// routeRule builds a field routing rule sending matching traffic to the given route.
+func routeRule(route egressRoute) map[string]any {
+	rule := map[string]any{
+		"type": "field",
+	}
+	if route.BalancerTag != "" {
+		rule["balancerTag"] = route.BalancerTag
+	} else {
+		rule["outboundTag"] = route.OutboundTag
+	}
+	return rule
+}
+
+// applyEgressRules turns per-client and per-inbound egress settings into routing rules.
+// Rules are appended after the template rules so that hand-written rules such as
+// blocking keep precedence; client rules come before inbound defaults.
+// Routes pointing to unknown tags are skipped, as Xray refuses to start with them.
+func (s *XrayService) applyEgressRules(xrayConfig *xray.Config, userRoutes map[string]egressRoute, inboundRoutes []inboundEgress) error {
+	if len(userRoutes) == 0 && len(inboundRoutes) == 0 {
+		return nil
+	}
+	outboundTags, balancerTags := getRoutingTags(xrayConfig)
+	isKnown := func(route egressRoute) bool {
+		if route.BalancerTag != "" {
+			return balancerTags[route.BalancerTag]
+		}
+		return outboundTags[route.OutboundTag]
+	}
+
+	var rules []any
+
+	// Group users by route so each route produces a single rule
+	grouped := map[string][]string{}
+	routes := map[string]egressRoute{}
+	for email, route := range userRoutes {
+		if !isKnown(route) {
+			logger.Warningf("Skip egress of client %s: unknown tag %s", email, route.key())
+			continue
+		}
+		grouped[route.key()] = append(grouped[route.key()], email)
+		routes[route.key()] = route
+	}
+	keys := make([]string, 0, len(grouped))
+	for key := range grouped {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		emails := grouped[key]
+		sort.Strings(emails)
+		rule := routeRule(routes[key])
+		rule["user"] = emails
+		rules = append(rules, rule)
+	}
+
+	for _, inbound := range inboundRoutes {
+		if !isKnown(inbound.Route) {
+			logger.Warningf("Skip egress of inbound %s: unknown tag %s", inbound.Tag, inbound.Route.key())
+			continue
+		}
+		rule := routeRule(inbound.Route)
+		rule["inboundTag"] = []string{inbound.Tag}
+		rules = append(rules, rule)
+	}
+
+	return appendRoutingRules(xrayConfig, rules)
+}
@@ -310,9 +310,57 @@ func (s *Server) startTask() {
 	// check client ips from log file every 10 sec
 	s.cron.AddJob("@every 10s", job.NewCheckClientIpJob())
 
+	// Lift expired IP bans of the built-in IP limit enforcement every minute
+	s.cron.AddJob("@every 1m", job.NewIpBanJob())
+
+	// Tail the access log into the connection log every 10 seconds
+	s.cron.AddJob("@every 10s", job.NewConnectionLogJob())
+
+	// Strike clients caught by the BitTorrent block preset every minute
+	s.cron.AddJob("@every 1m", job.NewTorrentStrikeJob())
+
+	// Shape the online IPs of clients with a speed cap every 10 seconds
+	s.cron.AddJob("@every 10s", job.NewSpeedLimitJob())
+
+	// Reset clients on their own billing schedules every minute
+	s.cron.AddJob("@every 1m", job.NewClientResetJob())
+
+	// Move clients through warning, grace and disable stages every minute
+	s.cron.AddJob("@every 1m", job.NewClientStageJob())
+
+	// Add and remove clients at the boundaries of their access schedules every minute
+	s.cron.AddJob("@every 1m", job.NewAccessScheduleJob())
+
+	// Rotate client credentials and retire the previous ones after their overlap every minute
+	s.cron.AddJob("@every 1m", job.NewCredentialRotationJob())
+
+	// Score clients for subscription sharing and respond to likely leaks every 30 minutes
+	s.cron.AddJob("@every 30m", job.NewLeakDetectionJob())
+
+	// Remind clients whose quota is forecast to run out soon every hour
+	s.cron.AddJob("@every 1h", job.NewForecastReminderJob())
+
+	// Delete expired trial clients every 10 minutes
+	s.cron.AddJob("@every 10m", job.NewTrialCleanupJob())
+
+	// Invoice clients on a priced plan ahead of their expiry every hour
+	s.cron.AddJob("@every 1h", job.NewInvoiceJob())
+
 	// check client ips from log file every day
 	s.cron.AddJob("@daily", job.NewClearLogsJob())
 
+	// Drop expired client traffic history buckets every hour
+	s.cron.AddJob("@hourly", job.NewClearTrafficHistoryJob())
+
+	// Refresh outbound subscriptions, each one follows its own interval
+	s.cron.AddJob("@every 1m", job.NewOutboundSubscriptionJob())
+
+	// Record observatory results of outbounds every 30 seconds
+	s.cron.AddJob("@every 30s", job.NewCheckOutboundHealthJob())
+
+	// Check outbound quotas and monthly resets every minute
+	s.cron.AddJob("@every 1m", job.NewCheckOutboundTrafficJob())
+
 	// Inbound traffic reset jobs
 	// Run once a day, midnight
 	s.cron.AddJob("@daily", job.NewPeriodicTrafficResetJob("daily"))
@@ -447,6 +495,8 @@ func (s *Server) Stop() error {
 	if s.cron != nil {
 		s.cron.Stop()
 	}
+	// Write traffic still held in memory
+	job.NewXrayTrafficJob().Flush()
 	if s.tgbotService.IsRunning() {
 		s.tgbotService.Stop()
 	}
@@ -14,7 +14,9 @@ import (
 	"github.com/agassiz/3x-ui/v2/logger"
 	"github.com/agassiz/3x-ui/v2/util/common"
 
+	observatoryService "github.com/xtls/xray-core/app/observatory/command"
 	"github.com/xtls/xray-core/app/proxyman/command"
+	routerService "github.com/xtls/xray-core/app/router/command"
 	statsService "github.com/xtls/xray-core/app/stats/command"
 	"github.com/xtls/xray-core/common/protocol"
 	"github.com/xtls/xray-core/common/serial"
@@ -25,18 +27,22 @@ import (
 	"github.com/xtls/xray-core/proxy/vless"
 	"github.com/xtls/xray-core/proxy/vmess"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 // XrayAPI is a gRPC client for managing Xray core configuration, inbounds, outbounds, and statistics.
 type XrayAPI struct {
-	HandlerServiceClient *command.HandlerServiceClient
-	StatsServiceClient   *statsService.StatsServiceClient
-	grpcClient           *grpc.ClientConn
-	isConnected          bool
+	HandlerServiceClient     *command.HandlerServiceClient
+	StatsServiceClient       *statsService.StatsServiceClient
+	ObservatoryServiceClient *observatoryService.ObservatoryServiceClient
+	RoutingServiceClient     *routerService.RoutingServiceClient
+	grpcClient               *grpc.ClientConn
+	isConnected              bool
 }
 
-// Init connects to the Xray API server and initializes handler and stats service clients.
+// Init connects to the Xray API server and initializes handler, stats, observatory and routing service clients.
 func (x *XrayAPI) Init(apiPort int) error {
 	if apiPort <= 0 || apiPort > math.MaxUint16 {
 		return fmt.Errorf("invalid Xray API port: %d", apiPort)
@@ -54,8 +60,13 @@ func (x *XrayAPI) Init(apiPort int) error {
 	hsClient := command.NewHandlerServiceClient(conn)
 	ssClient := statsService.NewStatsServiceClient(conn)
 
+	osClient := observatoryService.NewObservatoryServiceClient(conn)
+	rsClient := routerService.NewRoutingServiceClient(conn)
+
 	x.HandlerServiceClient = &hsClient
 	x.StatsServiceClient = &ssClient
+	x.ObservatoryServiceClient = &osClient
+	x.RoutingServiceClient = &rsClient
 
 	return nil
 }
@@ -67,6 +78,8 @@ func (x *XrayAPI) Close() {
 	}
 	x.HandlerServiceClient = nil
 	x.StatsServiceClient = nil
+	x.ObservatoryServiceClient = nil
+	x.RoutingServiceClient = nil
 	x.isConnected = false
 }
 
@@ -216,6 +229,131 @@ func (x *XrayAPI) GetTraffic(reset bool) ([]*Traffic, []*ClientTraffic, error) {
 	return mapToSlice(tagTrafficMap), mapToSlice(emailTrafficMap), nil
 }
 
+// GetOnlineClientIps queries the online IP list of every given client.
+// Clients without a recent connection are left out of the result.
+// A failed query skips its client, the clients queried so far are returned with the last error.
+// It requires the statsUserOnline policy to be enabled.
+func (x *XrayAPI) GetOnlineClientIps(emails []string) (map[string]map[string]int64, error) {
+	if x.grpcClient == nil || x.StatsServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	onlineIps := make(map[string]map[string]int64)
+	var lastErr error
+	for _, email := range emails {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		resp, err := (*x.StatsServiceClient).GetStatsOnlineIpList(ctx, &statsService.GetStatsRequest{
+			Name: "user>>>" + email + ">>>online",
+		})
+		cancel()
+		if err != nil {
+			// The online map is only registered after the first connection of the client
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			lastErr = err
+			if status.Code(err) == codes.Unavailable {
+				break
+			}
+			continue
+		}
+		if len(resp.GetIps()) > 0 {
+			onlineIps[email] = resp.GetIps()
+		}
+	}
+	return onlineIps, lastErr
+}
+
+// GetOutboundStatus queries the observatory for the health of every probed outbound.
+func (x *XrayAPI) GetOutboundStatus() ([]*OutboundStatus, error) {
+	if x.grpcClient == nil || x.ObservatoryServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	resp, err := (*x.ObservatoryServiceClient).GetOutboundStatus(ctx, &observatoryService.GetOutboundStatusRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*OutboundStatus, 0, len(resp.GetStatus().GetStatus()))
+	for _, status := range resp.GetStatus().GetStatus() {
+		statuses = append(statuses, &OutboundStatus{
+			Tag:          status.GetOutboundTag(),
+			Alive:        status.GetAlive(),
+			Delay:        status.GetDelay(),
+			LastError:    status.GetLastErrorReason(),
+			LastSeenTime: status.GetLastSeenTime(),
+			LastTryTime:  status.GetLastTryTime(),
+		})
+	}
+	return statuses, nil
+}
+
+// GetBalancerInfo queries the router for the current selection of a balancer.
+func (x *XrayAPI) GetBalancerInfo(tag string) (*BalancerStatus, error) {
+	if x.grpcClient == nil || x.RoutingServiceClient == nil {
+		return nil, common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	resp, err := (*x.RoutingServiceClient).GetBalancerInfo(ctx, &routerService.GetBalancerInfoRequest{Tag: tag})
+	if err != nil {
+		return nil, err
+	}
+
+	balancer := resp.GetBalancer()
+	return &BalancerStatus{
+		Tag:      tag,
+		Override: balancer.GetOverride().GetTarget(),
+		Targets:  balancer.GetPrincipleTarget().GetTag(),
+	}, nil
+}
+
+// SetRoutingRules replaces the rules and balancers of the running router with the routing config
+// given in Xray JSON format, so that new rules can be put ahead of the existing ones.
+func (x *XrayAPI) SetRoutingRules(routing []byte) error {
+	if x.grpcClient == nil || x.RoutingServiceClient == nil {
+		return common.NewError("xray api is not initialized")
+	}
+	routerConfig := &conf.RouterConfig{}
+	if err := json.Unmarshal(routing, routerConfig); err != nil {
+		return err
+	}
+	config, err := routerConfig.Build()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err = (*x.RoutingServiceClient).AddRule(ctx, &routerService.AddRuleRequest{
+		Config:       serial.ToTypedMessage(config),
+		ShouldAppend: false,
+	})
+	return err
+}
+
+// RemoveRoutingRule removes the routing rule with the given rule tag from the running router.
+func (x *XrayAPI) RemoveRoutingRule(ruleTag string) error {
+	if x.grpcClient == nil || x.RoutingServiceClient == nil {
+		return common.NewError("xray api is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := (*x.RoutingServiceClient).RemoveRule(ctx, &routerService.RemoveRuleRequest{
+		RuleTag: ruleTag,
+	})
+	return err
+}
+
 // processTraffic aggregates a traffic stat into trafficMap using regex matches and value.
 func processTraffic(matches []string, value int64, trafficMap map[string]*Traffic) {
 	isInbound := matches[1] == "inbound"
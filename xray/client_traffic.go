@@ -11,9 +11,19 @@ type ClientTraffic struct {
 	SubId      string `json:"subId" form:"subId" gorm:"-"`
 	Up         int64  `json:"up" form:"up"`
 	Down       int64  `json:"down" form:"down"`
+	RawUp      int64  `json:"rawUp" form:"rawUp"`     // Upload before the traffic multiplier, Up is what counts toward Total
+	RawDown    int64  `json:"rawDown" form:"rawDown"` // Download before the traffic multiplier
 	AllTime    int64  `json:"allTime" form:"allTime"`
 	ExpiryTime int64  `json:"expiryTime" form:"expiryTime"`
 	Total      int64  `json:"total" form:"total"`
 	Reset      int    `json:"reset" form:"reset" gorm:"default:0"`
 	LastOnline int64  `json:"lastOnline" form:"lastOnline" gorm:"default:0"`
+	PoolId     int    `json:"poolId" form:"poolId" gorm:"index;default:0"` // Quota pool of the client, mirrored from its settings
+	LastReset  int64  `json:"lastReset" form:"lastReset" gorm:"default:0"` // Start of the current reset cycle in milliseconds
+	Stage      string `json:"stage" form:"stage" gorm:"default:''"`        // Enforcement stage: warning, grace or disabled, empty in good standing
+	StageTime  int64  `json:"stageTime" form:"stageTime" gorm:"default:0"` // When the client entered its stage in milliseconds
+
+	ForecastNotified int64 `json:"-" gorm:"default:0"`              // Last depletion reminder in milliseconds
+	DailyAverage     int64 `json:"dailyAverage" form:"-" gorm:"-"`  // Forecast: traffic per day over the last week
+	DepletionTime    int64 `json:"depletionTime" form:"-" gorm:"-"` // Forecast: when the quota runs out in milliseconds, 0 when unknown
 }
@@ -56,6 +56,12 @@ func (c *Config) Equals(other *Config) bool {
 	if !bytes.Equal(c.API, other.API) {
 		return false
 	}
+	if !bytes.Equal(c.Observatory, other.Observatory) {
+		return false
+	}
+	if !bytes.Equal(c.BurstObservatory, other.BurstObservatory) {
+		return false
+	}
 	if !bytes.Equal(c.Stats, other.Stats) {
 		return false
 	}
@@ -0,0 +1,18 @@
+package xray
+
+// OutboundStatus represents the health of an outbound as probed by the Xray observatory.
+type OutboundStatus struct {
+	Tag          string `json:"tag"`
+	Alive        bool   `json:"alive"`
+	Delay        int64  `json:"delay"`        // Probe delay in milliseconds
+	LastError    string `json:"lastError"`    // Reason of the last failed probe
+	LastSeenTime int64  `json:"lastSeenTime"` // Unix time the outbound was last seen alive
+	LastTryTime  int64  `json:"lastTryTime"`  // Unix time of the last probe
+}
+
+// BalancerStatus represents the current selection of an Xray routing balancer.
+type BalancerStatus struct {
+	Tag      string   `json:"tag"`
+	Override string   `json:"override"` // Target forced by an override, if any
+	Targets  []string `json:"targets"`  // Outbounds currently selected by the strategy
+}